@@ -13,6 +13,7 @@ func main() {
 		logger.Error("Failed to load configuration", "error", err)
 		os.Exit(1)
 	}
+	appConfig = cfg
 
 	// Initialize logger based on config
 	if cfg.LogLevel == "debug" {
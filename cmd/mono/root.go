@@ -1,18 +1,31 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"syscall"
+	"time"
 	"github.com/spf13/cobra"
 	"mono-mind/internal/analyzer"
 	"mono-mind/internal/build"
+	"mono-mind/internal/config"
 	"mono-mind/internal/impact"
 	"mono-mind/internal/logger"
 	"mono-mind/internal/refactor"
 	"mono-mind/internal/release"
+	"mono-mind/internal/tasks"
 	"mono-mind/internal/test"
 	"mono-mind/internal/visualization"
+	"mono-mind/internal/watch"
 )
 
+// appConfig holds the configuration loaded at startup
+var appConfig = config.DefaultConfig()
+
 // NewRootCmd creates the root command for the mono CLI
 func NewRootCmd() *cobra.Command {
 	rootCmd := &cobra.Command{
@@ -21,6 +34,9 @@ func NewRootCmd() *cobra.Command {
 		Long: `MonoMind is an AI-powered development assistant designed to autonomously 
 manage monorepos and complex codebases. It combines code analysis, build orchestration, 
 testing, refactoring, and release management into a single intelligent CLI-driven interface.`,
+		// main logs the error of a failed command
+		SilenceErrors: true,
+		SilenceUsage:  true,
 	}
 
 	// Add subcommands
@@ -29,8 +45,10 @@ testing, refactoring, and release management into a single intelligent CLI-drive
 	rootCmd.AddCommand(newBuildCmd())
 	rootCmd.AddCommand(newRefactorCmd())
 	rootCmd.AddCommand(newReleaseCmd())
+	rootCmd.AddCommand(newRunCmd())
 	rootCmd.AddCommand(newTestCmd())
 	rootCmd.AddCommand(newVisualizeCmd())
+	rootCmd.AddCommand(newWatchCmd())
 
 	// Add global flags
 	rootCmd.PersistentFlags().BoolVar(&logger.DebugFlag, "debug", false, "Enable debug logging")
@@ -100,7 +118,7 @@ func newBuildCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "build",
 		Short: "Incremental build based on changes",
-		Run: func(cmd *cobra.Command, args []string) {
+		RunE: func(cmd *cobra.Command, args []string) error {
 			logger.Info("Building affected modules...")
 			
 			// Get current directory as the root path
@@ -109,33 +127,248 @@ func newBuildCmd() *cobra.Command {
 			// First, analyze the repo to get the dependency graph
 			graph, err := analyzer.AnalyzeRepo(rootPath)
 			if err != nil {
-				logger.Error("Failed to analyze repository", "error", err)
-				return
+				return fmt.Errorf("failed to analyze repository: %v", err)
 			}
 			
+			// Get flags
+			noCache, _ := cmd.Flags().GetBool("no-cache")
+			parallel, _ := cmd.Flags().GetBool("parallel")
+			maxConcurrent, _ := cmd.Flags().GetInt("max-concurrent")
+			timeout, _ := cmd.Flags().GetDuration("timeout")
+			profile, _ := cmd.Flags().GetString("profile")
+			affected, _ := cmd.Flags().GetBool("affected")
+			since, _ := cmd.Flags().GetString("since")
+			files, _ := cmd.Flags().GetStringSlice("files")
+			
 			// Configure build
 			config := build.BuildConfig{
-				Parallel:      true,
-				MaxConcurrent: 4,
+				Parallel:      parallel,
+				MaxConcurrent: maxConcurrent,
 				DryRun:        false,
+				NoCache:       noCache,
+				Timeout:       timeout,
+				Profile:       profile,
+				Tasks:         tasks.NewResolver(appConfig),
+			}
+			
+			// Restrict the build to changed modules and their dependents if requested
+			if affected {
+				config.Modules, err = affectedModules(graph, since, files)
+				if err != nil {
+					return fmt.Errorf("failed to determine affected modules: %v", err)
+				}
+				if len(config.Modules) == 0 {
+					logger.Info("No affected modules, nothing to build")
+					return nil
+				}
 			}
 			
 			// Perform incremental build
 			result := build.IncrementalBuild(graph, config)
 			
+			for module, phase := range result.TimedOut {
+				logger.Error("Module timed out", "module", module, "phase", phase)
+			}
+			
 			logger.Info("Build completed", 
 				"modules_built", len(result.ModulesBuilt),
-				"errors", len(result.Errors))
+				"errors", len(result.Errors),
+				"duration", result.Duration,
+				"logs", tasks.LogDir(result.RunID))
+			
+			if len(result.Errors) > 0 {
+				return fmt.Errorf("build failed with %d errors", len(result.Errors))
+			}
+			return nil
 		},
 	}
+	
+	// Add flags
+	cmd.Flags().Bool("no-cache", false, "Rebuild modules even if their inputs are unchanged")
+	cmd.Flags().Bool("parallel", appConfig.Build.Parallel, "Build independent modules in parallel")
+	cmd.Flags().Int("max-concurrent", appConfig.Build.MaxConcurrent, "Maximum number of tasks to run at once")
+	cmd.Flags().Duration("timeout", configDuration(appConfig.Build.Timeout), "Maximum duration of the whole build (0 for none)")
+	cmd.Flags().String("profile", "", "Write a Chrome trace of the build timings to this file")
+	cmd.Flags().Bool("affected", false, "Only build changed modules and their dependents")
+	cmd.Flags().String("since", "HEAD", "Git ref to compare against when using --affected")
+	cmd.Flags().StringSlice("files", nil, "Changed files to use with --affected instead of git")
+	
 	return cmd
 }
 
+func newRunCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "run [task]",
+		Short: "Run a task across the module graph",
+		Long: `Run a named task (build, test, lint, generate, ...) in every module that
+declares it, honouring the task's depends_on relationships.`,
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			taskName := args[0]
+			logger.Info("Running task", "task", taskName)
+			
+			// Get flags
+			dryRun, _ := cmd.Flags().GetBool("dry-run")
+			noCache, _ := cmd.Flags().GetBool("no-cache")
+			maxConcurrent, _ := cmd.Flags().GetInt("max-concurrent")
+			timeout, _ := cmd.Flags().GetDuration("timeout")
+			profile, _ := cmd.Flags().GetString("profile")
+			affected, _ := cmd.Flags().GetBool("affected")
+			since, _ := cmd.Flags().GetString("since")
+			files, _ := cmd.Flags().GetStringSlice("files")
+			
+			// Get current directory as the root path
+			rootPath := "."
+			
+			// First, analyze the repo to get the dependency graph
+			graph, err := analyzer.AnalyzeRepo(rootPath)
+			if err != nil {
+				return fmt.Errorf("failed to analyze repository: %v", err)
+			}
+			
+			// Restrict the run to affected modules if requested
+			var modules []string
+			if affected {
+				modules, err = affectedModules(graph, since, files)
+				if err != nil {
+					return fmt.Errorf("failed to determine affected modules: %v", err)
+				}
+				if len(modules) == 0 {
+					logger.Info("No affected modules, nothing to run")
+					return nil
+				}
+			}
+			
+			resolver := tasks.NewResolver(appConfig)
+			plan, err := resolver.Plan(graph, taskName, modules)
+			if err != nil {
+				return fmt.Errorf("failed to plan task %s: %v", taskName, err)
+			}
+			if affected {
+				plan.Only(modules)
+			}
+			
+			options := tasks.RunOptions{
+				MaxConcurrent: maxConcurrent,
+				DryRun:        dryRun,
+				Timeout:       timeout,
+			}
+			if !noCache {
+				options.Cache = tasks.NewCache()
+			}
+			result := tasks.Run(plan, options)
+			
+			if profile != "" && !dryRun {
+				if err := tasks.WriteTrace(profile, plan, result); err != nil {
+					logger.Error("Failed to write task profile", "file", profile, "error", err)
+				}
+				tasks.WriteSummary(os.Stdout, plan, result)
+			}
+			
+			logger.Info("Task completed", 
+				"task", taskName,
+				"nodes", len(result.Nodes),
+				"modules_without_task", len(plan.Missing),
+				"errors", len(result.Errors),
+				"logs", result.LogDir)
+			
+			if len(result.Errors) > 0 {
+				return fmt.Errorf("task %s failed with %d errors", taskName, len(result.Errors))
+			}
+			return nil
+		},
+	}
+	
+	// Add flags
+	cmd.Flags().Bool("dry-run", false, "Preview the task plan without running it")
+	cmd.Flags().Bool("no-cache", false, "Run tasks even if their inputs are unchanged")
+	cmd.Flags().Int("max-concurrent", appConfig.Build.MaxConcurrent, "Maximum number of tasks to run at once")
+	cmd.Flags().Duration("timeout", configDuration(appConfig.Build.Timeout), "Maximum duration of the whole run (0 for none)")
+	cmd.Flags().String("profile", "", "Write a Chrome trace of the task timings to this file")
+	cmd.Flags().Bool("affected", false, "Only run the task in modules affected by changes")
+	cmd.Flags().String("since", "HEAD", "Git ref to compare against when using --affected")
+	cmd.Flags().StringSlice("files", nil, "Changed files to use with --affected instead of git")
+	
+	return cmd
+}
+
+// affectedModules returns the modules affected by the given files, or by
+// the files changed since a git ref when no files are given
+func affectedModules(graph *analyzer.RepoGraph, since string, files []string) ([]string, error) {
+	files, err := changedFiles(since, files)
+	if err != nil {
+		return nil, err
+	}
+	
+	result := impact.AnalyzeChanges(graph, files)
+	logger.Info("Affected modules", 
+		"changed_files", len(result.ChangedFiles),
+		"changed_modules", len(result.ChangedModules),
+		"affected_modules", len(result.AffectedModules))
+	
+	return result.AffectedModules, nil
+}
+
+// affectedTestModules returns the modules whose tests are affected by the
+// given or changed files, printing why each module was selected. With a
+// recorded test impact map, modules are narrowed to the tests that covered the
+// changed files; the selected tests are returned per module.
+func affectedTestModules(graph *analyzer.RepoGraph, since string, files []string, includeTestEdges bool) ([]string, map[string][]string, error) {
+	files, err := changedFiles(since, files)
+	if err != nil {
+		return nil, nil, err
+	}
+	
+	result := impact.AnalyzeTestImpact(graph, files, includeTestEdges)
+	
+	// Narrow the modules to the tests covering the changes when test impact was recorded
+	impactMap, err := impact.LoadTestImpactMap(impact.TestImpactFile)
+	if err != nil {
+		logger.Warn("Ignoring test impact map", "error", err)
+	} else if len(impactMap.Modules) > 0 {
+		impactMap.SelectTests(graph, result)
+	}
+	
+	for _, moduleName := range result.AffectedTests {
+		logger.Info("Selected module for testing", "module", moduleName, "reason", result.Reasons[moduleName])
+		if tests, ok := result.SelectedTests[moduleName]; ok {
+			logger.Info("Selected tests", "module", moduleName, "tests", strings.Join(tests, ","))
+		}
+	}
+	logger.Info("Affected tests", 
+		"changed_files", len(result.ChangedFiles),
+		"changed_modules", len(result.ChangedModules),
+		"affected_tests", len(result.AffectedTests))
+	
+	return result.AffectedTests, result.SelectedTests, nil
+}
+
+// changedFiles returns the given files, or the files changed since a git ref when none are given
+func changedFiles(since string, files []string) ([]string, error) {
+	if len(files) > 0 {
+		return files, nil
+	}
+	return impact.ChangedFiles(since)
+}
+
+// configDuration parses a duration from the configuration, returning 0 if it is unset or invalid
+func configDuration(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		logger.Error("Invalid duration in configuration", "value", value, "error", err)
+		return 0
+	}
+	return duration
+}
+
 func newRefactorCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "refactor",
 		Short: "Safe rename/move across modules",
-		Run: func(cmd *cobra.Command, args []string) {
+		RunE: func(cmd *cobra.Command, args []string) error {
 			logger.Info("Refactoring code...")
 			
 			// Get flags
@@ -143,53 +376,131 @@ func newRefactorCmd() *cobra.Command {
 			rename, _ := cmd.Flags().GetString("rename")
 			move, _ := cmd.Flags().GetString("move")
 			file, _ := cmd.Flags().GetString("file")
+			force, _ := cmd.Flags().GetBool("force")
+			patchFile, _ := cmd.Flags().GetString("patch")
+			allowDirty, _ := cmd.Flags().GetBool("allow-dirty")
+			undo, _ := cmd.Flags().GetBool("undo")
 			
 			// Configure refactor
 			config := refactor.RefactorConfig{
-				DryRun: dryRun,
+				DryRun:     dryRun,
+				Force:      force,
+				PatchFile:  patchFile,
+				AllowDirty: allowDirty,
 			}
 			
 			// Perform refactor based on flags
 			var result *refactor.RefactorResult
-			if rename != "" {
-				// Parse rename format: oldName:newName
-				names := strings.Split(rename, ":")
-				if len(names) != 2 {
-					logger.Error("Invalid rename format. Use oldName:newName")
-					return
+			if undo {
+				result = refactor.Undo(config)
+			} else if rename != "" {
+				// Parse rename format: target:newName, where the target may itself
+				// be a position given as file:line:col
+				separator := strings.LastIndex(rename, ":")
+				if separator <= 0 || separator == len(rename)-1 {
+					return fmt.Errorf("invalid rename format, use target:newName")
 				}
-				config.OldName = names[0]
-				config.NewName = names[1]
+				config.OldName = rename[:separator]
+				config.NewName = rename[separator+1:]
 				config.FilePath = file
 				result = refactor.Rename(config)
 			} else if move != "" {
 				// Parse move format: oldPath:newPath
 				paths := strings.Split(move, ":")
 				if len(paths) != 2 {
-					logger.Error("Invalid move format. Use oldPath:newPath")
-					return
+					return fmt.Errorf("invalid move format, use oldPath:newPath")
 				}
-				result = refactor.Move(paths[0], paths[1], dryRun)
+				config.OldPath = paths[0]
+				config.NewPath = paths[1]
+				result = refactor.Move(config)
 			} else {
-				logger.Info("No refactor operation specified. Use --rename or --move")
-				return
+				logger.Info("No refactor operation specified. Use --rename, --move or --undo")
+				return nil
 			}
 			
-			logger.Info("Refactor completed", 
-				"files_changed", len(result.FilesChanged),
-				"errors", len(result.Errors))
+			return printRefactorResult(result, dryRun)
 		},
 	}
 	
 	// Add flags
 	cmd.Flags().Bool("dry-run", false, "Preview changes without applying them")
-	cmd.Flags().String("rename", "", "Rename identifier (format: oldName:newName)")
+	cmd.Flags().String("rename", "", "Rename a Go identifier or JS/TS export (format: target:newName, the target being Name, pkg.Name, pkg.Type.Method, file:line:col or file.ts:export)")
 	cmd.Flags().String("move", "", "Move file/directory (format: oldPath:newPath)")
-	cmd.Flags().String("file", "", "Specific file to refactor (for rename operations)")
+	cmd.Flags().String("file", "", "Look up a bare rename target in the package of this file, or the JS/TS module exporting it")
+	cmd.Flags().Bool("force", false, "Rename even if the pre-flight checks fail, or undo even if files changed since")
+	cmd.Flags().String("patch", "", "Write the changes as a git-applyable patch to this file")
+	cmd.Flags().Bool("allow-dirty", false, "Refactor even if the git worktree has uncommitted changes")
+	cmd.Flags().Bool("undo", false, "Restore the files changed by the last refactoring")
+	
+	cmd.AddCommand(newRefactorExtractCmd())
+	
+	return cmd
+}
+
+func newRefactorExtractCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "extract",
+		Short: "Extract Go files into a new module",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			files, _ := cmd.Flags().GetStringSlice("files")
+			to, _ := cmd.Flags().GetString("to")
+			modulePath, _ := cmd.Flags().GetString("module")
+			dryRun, _ := cmd.Flags().GetBool("dry-run")
+			force, _ := cmd.Flags().GetBool("force")
+			patchFile, _ := cmd.Flags().GetString("patch")
+			allowDirty, _ := cmd.Flags().GetBool("allow-dirty")
+			
+			if len(files) == 0 || to == "" {
+				return fmt.Errorf("specify the files to extract with --files and the new module's directory with --to")
+			}
+			
+			result := refactor.Extract(refactor.RefactorConfig{
+				Files:      files,
+				NewPath:    to,
+				ModulePath: modulePath,
+				DryRun:     dryRun,
+				Force:      force,
+				PatchFile:  patchFile,
+				AllowDirty: allowDirty,
+			})
+			return printRefactorResult(result, dryRun)
+		},
+	}
+	
+	cmd.Flags().StringSlice("files", nil, "Go files to extract, all from one package (comma-separated)")
+	cmd.Flags().String("to", "", "Directory of the new module, named after its package")
+	cmd.Flags().String("module", "", "Module path of the new module (default: its import path in the enclosing module)")
+	cmd.Flags().Bool("dry-run", false, "Preview changes without applying them")
+	cmd.Flags().Bool("force", false, "Extract even if the pre-flight checks fail")
+	cmd.Flags().String("patch", "", "Write the changes as a git-applyable patch to this file")
+	cmd.Flags().Bool("allow-dirty", false, "Refactor even if the git worktree has uncommitted changes")
 	
 	return cmd
 }
 
+// printRefactorResult shows the diff of a dry run and the errors of a refactoring,
+// returning an error if the refactoring failed
+func printRefactorResult(result *refactor.RefactorResult, dryRun bool) error {
+	if dryRun && result.Patch != "" {
+		refactor.WriteDiff(os.Stdout, result.Patch)
+	}
+	
+	for _, refactorErr := range result.Errors {
+		logger.Error("Refactor failed", "error", refactorErr)
+	}
+	
+	logger.Info("Refactor completed", 
+		"files_changed", len(result.FilesChanged),
+		"skipped_packages", len(result.SkippedPackages),
+		"errors", len(result.Errors))
+	
+	if len(result.Errors) > 0 {
+		return fmt.Errorf("refactor failed with %d errors", len(result.Errors))
+	}
+	return nil
+}
+
 func newReleaseCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "release",
@@ -230,12 +541,28 @@ func newTestCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "test",
 		Short: "Run tests for affected modules",
-		Run: func(cmd *cobra.Command, args []string) {
+		RunE: func(cmd *cobra.Command, args []string) error {
 			logger.Info("Running tests...")
 			
 			// Get flags
 			dryRun, _ := cmd.Flags().GetBool("dry-run")
 			parallel, _ := cmd.Flags().GetBool("parallel")
+			timeout, _ := cmd.Flags().GetDuration("timeout")
+			junitFile, _ := cmd.Flags().GetString("junit")
+			tapFile, _ := cmd.Flags().GetString("tap")
+			affected, _ := cmd.Flags().GetBool("affected")
+			since, _ := cmd.Flags().GetString("since")
+			files, _ := cmd.Flags().GetStringSlice("files")
+			includeTestEdges, _ := cmd.Flags().GetBool("include-test-edges")
+			retries, _ := cmd.Flags().GetInt("retries")
+			shardSpec, _ := cmd.Flags().GetString("shard")
+			shardDurations, _ := cmd.Flags().GetString("shard-durations")
+			maxConcurrent, _ := cmd.Flags().GetInt("max-concurrent")
+			failFast, _ := cmd.Flags().GetBool("fail-fast")
+			coverage, _ := cmd.Flags().GetBool("coverage")
+			coverageDir, _ := cmd.Flags().GetString("coverage-dir")
+			minCoverage, _ := cmd.Flags().GetFloat64("min-coverage")
+			recordImpact, _ := cmd.Flags().GetBool("record-impact")
 			
 			// Get current directory as the root path
 			rootPath := "."
@@ -243,31 +570,147 @@ func newTestCmd() *cobra.Command {
 			// First, analyze the repo to get the dependency graph
 			graph, err := analyzer.AnalyzeRepo(rootPath)
 			if err != nil {
-				logger.Error("Failed to analyze repository", "error", err)
-				return
+				return fmt.Errorf("failed to analyze repository: %v", err)
 			}
 			
+			// Ctrl-C stops the run and kills the running test commands
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			
 			// Configure test
 			config := test.TestConfig{
 				Parallel:      parallel,
-				MaxConcurrent: 4,
+				MaxConcurrent: maxConcurrent,
 				DryRun:        dryRun,
+				Timeout:       timeout,
+				Retries:       retries,
+				Quarantine:    appConfig.Test.Quarantine,
+				FailFast:      failFast,
+				Coverage:      coverage,
+				CoverageDir:   coverageDir,
+				MinCoverage:   minCoverage,
+				RecordImpact:  recordImpact,
+				Tasks:         tasks.NewResolver(appConfig),
+				Context:       ctx,
+				Output:        os.Stdout,
+			}
+			
+			if shardSpec != "" {
+				shard, err := test.ParseShard(shardSpec)
+				if err != nil {
+					return fmt.Errorf("invalid shard: %v", err)
+				}
+				config.Shard = &shard
+				config.ShardDurations = shardDurations
+			}
+			
+			// Restrict the run to affected modules if requested
+			if affected {
+				config.Modules, config.Tests, err = affectedTestModules(graph, since, files, includeTestEdges)
+				if err != nil {
+					return fmt.Errorf("failed to determine affected modules: %v", err)
+				}
+				if len(config.Modules) == 0 {
+					logger.Info("No affected modules, nothing to test")
+					return nil
+				}
 			}
 			
 			// Run tests
 			result := test.RunTests(graph, config)
 			
+			// Report each failed and flaky test
+			for _, testCase := range result.Cases {
+				switch {
+				case testCase.Status == test.StatusFail && testCase.Quarantined:
+					logger.Warn("Quarantined test failed", "module", testCase.Module, "package", testCase.Package, "test", testCase.Name)
+				case testCase.Status == test.StatusFail:
+					logger.Error("Test failed", "module", testCase.Module, "package", testCase.Package, "test", testCase.Name)
+				case testCase.Flaky:
+					logger.Warn("Flaky test passed on retry", "module", testCase.Module, "test", testCase.Name, "attempts", testCase.Attempts)
+				}
+			}
+			
 			logger.Info("Test execution completed", 
 				"tests_run", result.TestsRun,
 				"tests_passed", result.TestsPassed,
 				"tests_failed", result.TestsFailed,
+				"tests_skipped", result.TestsSkipped,
+				"tests_flaky", result.TestsFlaky,
+				"tests_quarantined", result.TestsQuarantined,
+				"modules_cancelled", len(result.ModulesCancelled),
 				"errors", len(result.Errors))
+			
+			// Write the merged reports for CI
+			reports := []struct{ path, format string }{{junitFile, test.OutputJUnit}, {tapFile, test.OutputTAP}}
+			for _, report := range reports {
+				if report.path == "" {
+					continue
+				}
+				if err := test.WriteReportFile(report.path, report.format, result); err != nil {
+					logger.Error("Failed to write test report", "file", report.path, "error", err)
+					continue
+				}
+				logger.Info("Test report written", "format", report.format, "file", report.path)
+			}
+			
+			// Quarantined failures are not counted in TestsFailed or Errors
+			switch {
+			case result.TestsFailed > 0:
+				return fmt.Errorf("%d tests failed", result.TestsFailed)
+			case len(result.Errors) > 0:
+				return fmt.Errorf("tests failed with %d errors", len(result.Errors))
+			case len(result.ModulesCancelled) > 0:
+				return fmt.Errorf("test run cancelled before %d modules finished", len(result.ModulesCancelled))
+			}
+			return nil
 		},
 	}
 	
 	// Add flags
 	cmd.Flags().Bool("dry-run", false, "Preview test execution without running tests")
-	cmd.Flags().Bool("parallel", true, "Run tests in parallel")
+	cmd.Flags().Bool("parallel", appConfig.Test.Parallel, "Run tests in parallel")
+	cmd.Flags().Int("max-concurrent", appConfig.Test.MaxConcurrent, "Maximum number of modules to test at once")
+	cmd.Flags().Bool("fail-fast", false, "Stop the run after the first module with failing tests")
+	cmd.Flags().Duration("timeout", 0, "Maximum duration of the whole test run (0 for none)")
+	cmd.Flags().String("junit", "", "Write the test results to a JUnit XML report")
+	cmd.Flags().String("tap", "", "Write the test results to a TAP report")
+	cmd.Flags().Bool("affected", false, "Only test changed modules and their dependents")
+	cmd.Flags().String("since", "HEAD", "Git ref to compare against when using --affected")
+	cmd.Flags().StringSlice("files", nil, "Changed files to use with --affected instead of git")
+	cmd.Flags().Bool("include-test-edges", false, "With --affected, also test modules whose tests import an affected module")
+	cmd.Flags().Int("retries", appConfig.Test.Retries, "Number of times to rerun a module's failed tests")
+	cmd.Flags().String("shard", "", "Only run this machine's share of the tests, as index/total (e.g. 3/8)")
+	cmd.Flags().String("shard-durations", appConfig.Test.ShardDurations, "File of module test times shared by every shard: a test history or a JSON object of module to seconds")
+	cmd.Flags().Bool("coverage", false, "Collect coverage and write merged LCOV and Cobertura reports")
+	cmd.Flags().String("coverage-dir", filepath.Join(tasks.StateDir, "coverage"), "Directory for the merged coverage reports")
+	cmd.Flags().Float64("min-coverage", appConfig.Test.MinCoverage, "Minimum line coverage percentage per module with --coverage")
+	cmd.Flags().Bool("record-impact", false, "Record the source files each test covers for test-level --affected selection")
+	
+	cmd.AddCommand(newTestFlakyCmd())
+	
+	return cmd
+}
+
+func newTestFlakyCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "flaky",
+		Short: "Report flaky tests over recent runs",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			runs, _ := cmd.Flags().GetInt("runs")
+			
+			history, err := test.LoadHistory(test.HistoryFile)
+			if err != nil {
+				logger.Error("Failed to load test history", "error", err)
+				return
+			}
+			
+			test.WriteFlakyReport(os.Stdout, history.FlakyTests(runs), runs)
+		},
+	}
+	
+	cmd.Flags().Int("runs", 20, "Number of recent test runs to consider")
 	
 	return cmd
 }
@@ -330,4 +773,139 @@ func newVisualizeCmd() *cobra.Command {
 	cmd.Flags().String("output", "", "Output file for HTML visualization")
 	
 	return cmd
-}
\ No newline at end of file
+}
+
+func newWatchCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "watch",
+		Short: "Rerun a task for affected modules when files change",
+		Long: `Watch the repository and, whenever files change, rerun a task (test, build
+or any task declared in the configuration) for the modules affected by the
+change. The dependency graph is updated as imports change.`,
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			// Get flags
+			taskName, _ := cmd.Flags().GetString("task")
+			debounce, _ := cmd.Flags().GetDuration("debounce")
+			poll, _ := cmd.Flags().GetBool("poll")
+			pollInterval, _ := cmd.Flags().GetDuration("poll-interval")
+			
+			// Get current directory as the root path
+			rootPath := "."
+			
+			// Analyze the repo once; changes update the graph incrementally
+			graph, err := analyzer.AnalyzeRepo(rootPath)
+			if err != nil {
+				logger.Error("Failed to analyze repository", "error", err)
+				return
+			}
+			
+			// Ctrl-C stops watching and kills the running task commands
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			
+			resolver := tasks.NewResolver(appConfig)
+			cache := tasks.NewCache()
+			options := watch.Options{
+				Root:         rootPath,
+				Debounce:     debounce,
+				Poll:         poll,
+				PollInterval: pollInterval,
+				Ignore:       append([]string{tasks.StateDir}, appConfig.Watch.Ignore...),
+			}
+			
+			logger.Info("Watching for changes", "task", taskName, "modules", len(graph.Modules))
+			err = watch.Watch(ctx, options, func(paths []string) {
+				if changed := graph.UpdateFiles(paths); len(changed) > 0 {
+					logger.Info("Dependency graph updated", "modules", strings.Join(changed, ","))
+				}
+				runWatchTask(ctx, graph, taskName, paths, resolver, cache)
+				logger.Info("Watching for changes", "task", taskName)
+			})
+			if err != nil {
+				logger.Error("Failed to watch repository", "error", err)
+			}
+		},
+	}
+	
+	// Add flags
+	cmd.Flags().String("task", appConfig.Watch.Task, "Task to rerun for the affected modules")
+	cmd.Flags().Duration("debounce", configDuration(appConfig.Watch.Debounce), "Time to wait for further changes before running the task")
+	cmd.Flags().Bool("poll", false, "Poll for changes instead of using inotify")
+	cmd.Flags().Duration("poll-interval", configDuration(appConfig.Watch.PollInterval), "Time between scans when polling")
+	
+	return cmd
+}
+
+// runWatchTask runs a task for the modules affected by the changed files. The
+// test task reports individual tests; other tasks run through the task graph
+// and its cache. A change to the root means changes were lost, so the task
+// runs for every module.
+func runWatchTask(ctx context.Context, graph *analyzer.RepoGraph, taskName string, paths []string, resolver *tasks.Resolver, cache *tasks.Cache) {
+	everything := false
+	for _, path := range paths {
+		everything = everything || path == "."
+	}
+	
+	var (
+		modules []string
+		tests   map[string][]string
+		err     error
+	)
+	switch {
+	case everything:
+		logger.Warn("Running the task for every module", "task", taskName)
+	case taskName == "test":
+		modules, tests, err = affectedTestModules(graph, "", paths, false)
+	default:
+		modules, err = affectedModules(graph, "", paths)
+	}
+	if err != nil {
+		logger.Error("Failed to determine affected modules", "error", err)
+		return
+	}
+	if !everything && len(modules) == 0 {
+		logger.Info("No affected modules, nothing to run")
+		return
+	}
+	
+	if taskName == "test" {
+		result := test.RunTests(graph, test.TestConfig{
+			Parallel:      appConfig.Test.Parallel,
+			MaxConcurrent: appConfig.Test.MaxConcurrent,
+			Modules:       modules,
+			Tests:         tests,
+			Retries:       appConfig.Test.Retries,
+			Quarantine:    appConfig.Test.Quarantine,
+			Tasks:         resolver,
+			Context:       ctx,
+			Output:        os.Stdout,
+		})
+		logger.Info("Test execution completed", 
+			"tests_run", result.TestsRun,
+			"tests_failed", result.TestsFailed,
+			"errors", len(result.Errors),
+			"duration", result.Duration)
+		return
+	}
+	
+	plan, err := resolver.Plan(graph, taskName, modules)
+	if err != nil {
+		logger.Error("Failed to plan task", "task", taskName, "error", err)
+		return
+	}
+	if modules != nil {
+		plan.Only(modules)
+	}
+	
+	result := tasks.Run(plan, tasks.RunOptions{
+		MaxConcurrent: appConfig.Build.MaxConcurrent,
+		Cache:         cache,
+		Context:       ctx,
+	})
+	logger.Info("Task completed", 
+		"task", taskName,
+		"nodes", len(result.Nodes),
+		"errors", len(result.Errors),
+		"logs", result.LogDir)
+}
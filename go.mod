@@ -2,12 +2,17 @@ module mono-mind
 
 go 1.24.5
 
+require (
+	github.com/sirupsen/logrus v1.9.3
+	github.com/spf13/cobra v1.9.1
+	golang.org/x/mod v0.27.0
+	golang.org/x/sys v0.35.0
+	golang.org/x/tools v0.36.0
+	gopkg.in/yaml.v2 v2.4.0
+)
+
 require (
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
-	github.com/sirupsen/logrus v1.9.3 // indirect
-	github.com/spf13/cobra v1.9.1 // indirect
 	github.com/spf13/pflag v1.0.6 // indirect
-	golang.org/x/sys v0.35.0 // indirect
-	golang.org/x/tools v0.36.0 // indirect
-	gopkg.in/yaml.v2 v2.4.0 // indirect
+	golang.org/x/sync v0.16.0 // indirect
 )
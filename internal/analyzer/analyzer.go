@@ -4,7 +4,9 @@ import (
 	"bufio"
 	"os"
 	"path/filepath"
+	"reflect"
 	"regexp"
+	"sort"
 	"strings"
 	"mono-mind/internal/logger"
 )
@@ -15,6 +17,7 @@ type Module struct {
 	Path          string   `json:"path"`
 	Language      string   `json:"language"`
 	Dependencies  []string `json:"dependencies"`
+	TestDependencies []string `json:"test_dependencies"`
 	LastModified  string   `json:"last_modified"`
 }
 
@@ -22,6 +25,7 @@ type Module struct {
 type RepoGraph struct {
 	Modules map[string]Module   `json:"modules"`
 	Edges   map[string][]string `json:"edges"`
+	root    string
 }
 
 // AnalyzeRepo scans the repository and builds a dependency graph
@@ -32,6 +36,7 @@ func AnalyzeRepo(rootPath string) (*RepoGraph, error) {
 	graph := &RepoGraph{
 		Modules: make(map[string]Module),
 		Edges:   make(map[string][]string),
+		root:    rootPath,
 	}
 	
 	// Walk the directory tree
@@ -81,6 +86,147 @@ func shouldIgnoreDir(path string) bool {
 	return false
 }
 
+// IsIgnoredPath reports whether a path lies in a directory skipped by the analysis
+func IsIgnoredPath(path string) bool {
+	for _, part := range strings.Split(filepath.ToSlash(filepath.Clean(path)), "/") {
+		if shouldIgnoreDir(part) {
+			return true
+		}
+	}
+	return false
+}
+
+// UpdateFiles re-analyzes the modules containing the given changed, added or
+// removed files, so a long-running command keeps the graph current without
+// re-scanning the whole repository. Paths are given as the analysis walk sees
+// them, i.e. joined to the root passed to AnalyzeRepo. A path that is a
+// directory re-analyzes every module under it. It returns the sorted names of
+// the modules that were added, removed or whose dependencies changed.
+func (graph *RepoGraph) UpdateFiles(paths []string) []string {
+	// Collect the directories whose modules must be re-analyzed
+	dirs := map[string]bool{}
+	for _, path := range paths {
+		path = filepath.Clean(path)
+		if IsIgnoredPath(path) {
+			continue
+		}
+		
+		info, err := os.Stat(path)
+		if err == nil && info.IsDir() {
+			// A created or moved-in directory may hold new modules
+			filepath.Walk(path, func(sub string, subInfo os.FileInfo, err error) error {
+				if err != nil {
+					return nil
+				}
+				if shouldIgnoreDir(sub) {
+					if subInfo.IsDir() {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+				if subInfo.IsDir() {
+					dirs[sub] = true
+				}
+				return nil
+			})
+		} else {
+			dirs[filepath.Dir(path)] = true
+		}
+		
+		// Modules under a removed directory are dropped
+		for _, module := range graph.Modules {
+			modulePath := filepath.Clean(module.Path)
+			if modulePath == path || strings.HasPrefix(modulePath, path+string(filepath.Separator)) {
+				dirs[modulePath] = true
+			}
+		}
+	}
+	
+	modules := make(map[string]Module, len(graph.Modules))
+	for moduleName, module := range graph.Modules {
+		modules[moduleName] = module
+	}
+	
+	for dir := range dirs {
+		moduleName := filepath.Base(dir)
+		
+		// Modules are named after their directory, so directories sharing a
+		// name form a single module that only a full analysis can rebuild
+		if existing, exists := modules[moduleName]; exists && filepath.Clean(existing.Path) != dir {
+			logger.Debug("Module spans several directories, re-analyzing the repository", "module", moduleName)
+			return graph.reanalyze()
+		}
+		
+		rescanned := &RepoGraph{Modules: map[string]Module{}, Edges: map[string][]string{}}
+		entries, err := os.ReadDir(dir)
+		if err != nil && !os.IsNotExist(err) {
+			logger.Warn("Failed to re-analyze directory", "dir", dir, "error", err)
+			continue
+		}
+		for _, entry := range entries {
+			path := filepath.Join(dir, entry.Name())
+			if entry.IsDir() || shouldIgnoreDir(path) {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil {
+				continue
+			}
+			processFile(path, info, rescanned)
+		}
+		
+		if module, exists := rescanned.Modules[moduleName]; exists {
+			modules[moduleName] = module
+		} else if _, exists := modules[moduleName]; exists {
+			delete(modules, moduleName)
+		}
+	}
+	
+	return graph.replaceModules(modules)
+}
+
+// reanalyze rebuilds the graph from a full analysis of its root and returns
+// the modules that were added, removed or whose dependencies changed
+func (graph *RepoGraph) reanalyze() []string {
+	root := graph.root
+	if root == "" {
+		root = "."
+	}
+	
+	fresh, err := AnalyzeRepo(root)
+	if err != nil {
+		logger.Error("Failed to re-analyze repository", "error", err)
+		return []string{}
+	}
+	return graph.replaceModules(fresh.Modules)
+}
+
+// replaceModules swaps in a new set of modules, rebuilds the edges and returns
+// the modules that were added, removed or whose dependencies changed
+func (graph *RepoGraph) replaceModules(modules map[string]Module) []string {
+	changed := []string{}
+	for moduleName, module := range modules {
+		previous, exists := graph.Modules[moduleName]
+		if !exists || previous.Language != module.Language ||
+			!reflect.DeepEqual(previous.Dependencies, module.Dependencies) ||
+			!reflect.DeepEqual(previous.TestDependencies, module.TestDependencies) {
+			changed = append(changed, moduleName)
+		}
+	}
+	for moduleName := range graph.Modules {
+		if _, exists := modules[moduleName]; !exists {
+			changed = append(changed, moduleName)
+		}
+	}
+	
+	graph.Modules = modules
+	graph.Edges = make(map[string][]string)
+	buildDependencyEdges(graph)
+	
+	sort.Strings(changed)
+	return changed
+}
+
 // processFile processes a file and extracts module information
 func processFile(path string, info os.FileInfo, graph *RepoGraph) {
 	// Determine language based on file extension
@@ -112,6 +258,7 @@ func processFile(path string, info os.FileInfo, graph *RepoGraph) {
 			Path:         filepath.Dir(path),
 			Language:     language,
 			Dependencies: []string{},
+			TestDependencies: []string{},
 			LastModified: info.ModTime().String(),
 		}
 	} else {
@@ -120,14 +267,40 @@ func processFile(path string, info os.FileInfo, graph *RepoGraph) {
 		module.Language = language
 	}
 	
-	// Parse the file to extract dependencies
+	// Parse the file to extract dependencies; imports of test files are kept
+	// apart since only the module's tests, not the module itself, depend on them
 	dependencies := extractDependencies(path, language)
-	module.Dependencies = append(module.Dependencies, dependencies...)
+	if IsTestFile(path) {
+		module.TestDependencies = append(module.TestDependencies, dependencies...)
+	} else {
+		module.Dependencies = append(module.Dependencies, dependencies...)
+	}
 	
 	// Update the module in the graph
 	graph.Modules[moduleName] = module
 }
 
+// IsTestFile reports whether a source file contains tests, by the naming
+// conventions of Go, Jest/Vitest and pytest
+func IsTestFile(path string) bool {
+	base := filepath.Base(path)
+	name := strings.TrimSuffix(base, filepath.Ext(base))
+	
+	switch filepath.Ext(base) {
+	case ".go":
+		return strings.HasSuffix(name, "_test")
+	case ".js", ".jsx", ".ts", ".tsx":
+		if strings.HasSuffix(name, ".test") || strings.HasSuffix(name, ".spec") {
+			return true
+		}
+		return filepath.Base(filepath.Dir(path)) == "__tests__"
+	case ".py":
+		return strings.HasPrefix(name, "test_") || strings.HasSuffix(name, "_test") || name == "conftest"
+	}
+	
+	return false
+}
+
 // extractDependencies parses a file and extracts its dependencies using regex
 func extractDependencies(filePath, language string) []string {
 	dependencies := []string{}
@@ -183,11 +356,31 @@ func extractDependencies(filePath, language string) []string {
 		}
 	}
 	
+	// Go import blocks span several lines, so track whether we are inside one
+	importBlockStart := regexp.MustCompile(`^\s*import\s*\(`)
+	importBlockLine := regexp.MustCompile(`^\s*(?:[\w.]+\s+)?"([^"]+)"`)
+	inImportBlock := false
+	
 	// Read file line by line
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
 		line := scanner.Text()
 		
+		if language == "go" {
+			if inImportBlock {
+				if strings.HasPrefix(strings.TrimSpace(line), ")") {
+					inImportBlock = false
+				} else if match := importBlockLine.FindStringSubmatch(line); match != nil && !isStandardLibrary(match[1], language) {
+					dependencies = append(dependencies, match[1])
+				}
+				continue
+			}
+			if importBlockStart.MatchString(line) && !strings.Contains(line, ")") {
+				inImportBlock = true
+				continue
+			}
+		}
+		
 		// Apply each pattern to the line
 		for _, pattern := range importPatterns {
 			matches := pattern.FindAllStringSubmatch(line, -1)
@@ -268,6 +461,99 @@ func (graph *RepoGraph) GetModuleDependencies(moduleName string) []string {
 	return []string{}
 }
 
+// ResolveDependency maps a dependency as written in source (an import path or
+// package name) to the repository module that provides it
+func (graph *RepoGraph) ResolveDependency(dep string) (string, bool) {
+	if _, exists := graph.Modules[dep]; exists {
+		return dep, true
+	}
+	
+	// Go import paths end with the module's directory, e.g. mono-mind/internal/logger
+	for moduleName, module := range graph.Modules {
+		modulePath := filepath.ToSlash(filepath.Clean(module.Path))
+		if modulePath != "." && strings.HasSuffix(dep, "/"+modulePath) {
+			return moduleName, true
+		}
+	}
+	
+	return "", false
+}
+
+// GetInternalDependencies returns the repository modules a specific module depends on
+func (graph *RepoGraph) GetInternalDependencies(moduleName string) []string {
+	seen := map[string]bool{}
+	dependencies := []string{}
+	
+	for _, dep := range graph.GetModuleDependencies(moduleName) {
+		depModule, ok := graph.ResolveDependency(dep)
+		if !ok || depModule == moduleName || seen[depModule] {
+			continue
+		}
+		seen[depModule] = true
+		dependencies = append(dependencies, depModule)
+	}
+	
+	sort.Strings(dependencies)
+	return dependencies
+}
+
+// GetInternalDependents returns the repository modules that depend on a specific module
+func (graph *RepoGraph) GetInternalDependents(moduleName string) []string {
+	dependents := []string{}
+	
+	for mod := range graph.Modules {
+		for _, dep := range graph.GetInternalDependencies(mod) {
+			if dep == moduleName {
+				dependents = append(dependents, mod)
+				break
+			}
+		}
+	}
+	
+	sort.Strings(dependents)
+	return dependents
+}
+
+// GetTestDependents returns the repository modules whose tests, but not the
+// module code itself, depend on a specific module
+func (graph *RepoGraph) GetTestDependents(moduleName string) []string {
+	dependents := []string{}
+	
+	for mod, module := range graph.Modules {
+		if mod == moduleName {
+			continue
+		}
+		for _, dep := range module.TestDependencies {
+			if depModule, ok := graph.ResolveDependency(dep); ok && depModule == moduleName {
+				dependents = append(dependents, mod)
+				break
+			}
+		}
+	}
+	
+	sort.Strings(dependents)
+	return dependents
+}
+
+// ModuleForFile returns the module that contains a file, matching the deepest module directory
+func (graph *RepoGraph) ModuleForFile(filePath string) (string, bool) {
+	dir := filepath.Clean(filepath.Dir(filePath))
+	
+	for {
+		for moduleName, module := range graph.Modules {
+			if filepath.Clean(module.Path) == dir {
+				return moduleName, true
+			}
+		}
+		
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false
+		}
+		dir = parent
+	}
+}
+
 // GetDependentModules returns modules that depend on a specific module
 func (graph *RepoGraph) GetDependentModules(moduleName string) []string {
 	dependents := []string{}
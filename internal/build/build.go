@@ -1,13 +1,16 @@
 package build
 
 import (
+	"context"
 	"fmt"
-	"os/exec"
+	"io"
+	"os"
 	"path/filepath"
 	"strings"
+	"time"
 	"mono-mind/internal/analyzer"
 	"mono-mind/internal/logger"
-	"mono-mind/internal/plugins"
+	"mono-mind/internal/tasks"
 )
 
 // BuildConfig holds configuration for the build process
@@ -15,6 +18,11 @@ type BuildConfig struct {
 	Parallel      bool `json:"parallel"`
 	MaxConcurrent int  `json:"max_concurrent"`
 	DryRun        bool `json:"dry_run"`
+	NoCache       bool `json:"no_cache"`
+	Modules       []string `json:"modules"`
+	Timeout       time.Duration `json:"timeout"`
+	Profile       string `json:"profile"`
+	Tasks         *tasks.Resolver `json:"-"`
 }
 
 // BuildResult holds the result of a build operation
@@ -23,58 +31,109 @@ type BuildResult struct {
 	ModulesSkipped []string `json:"modules_skipped"`
 	Errors        []string `json:"errors"`
 	Duration      string   `json:"duration"`
+	RunID         string   `json:"run_id"`
+	LogFiles      map[string]string `json:"log_files"`
+	Attempts      map[string]int `json:"attempts"`
+	TimedOut      map[string]string `json:"timed_out"`
+	CriticalPath  []string `json:"critical_path"`
 }
 
 // IncrementalBuild performs an incremental build of affected modules
 func IncrementalBuild(graph *analyzer.RepoGraph, config BuildConfig) *BuildResult {
 	logger.Info("Starting incremental build")
 	
-	// Initialize plugin manager
-	pluginManager := plugins.NewPluginManager()
-	if err := pluginManager.LoadPluginsFromDir("plugins"); err != nil {
-		logger.Error("Failed to load plugins", "error", err)
-	}
-	
-	// Execute pre-build plugins
-	if err := pluginManager.ExecuteHook("pre-build"); err != nil {
-		logger.Error("Failed to execute pre-build hook", "error", err)
+	if config.Tasks == nil {
+		config.Tasks = tasks.NewResolver(nil)
 	}
 	
 	result := &BuildResult{
 		ModulesBuilt:  []string{},
 		ModulesSkipped: []string{},
 		Errors:        []string{},
+		LogFiles:      map[string]string{},
+		Attempts:      map[string]int{},
+		TimedOut:      map[string]string{},
 	}
 	
-	// In a real implementation, we would:
-	// 1. Determine which modules need to be rebuilt based on changes
-	// 2. Execute builds in the correct order based on dependencies
-	// 3. Handle parallel execution if enabled
-	// 4. Collect results and errors
+	// Plan the build task across the module graph so dependencies build first
+	plan, err := config.Tasks.Plan(graph, "build", config.Modules)
+	if err != nil {
+		logger.Error("Failed to plan build", "error", err)
+		result.Errors = append(result.Errors, err.Error())
+		return result
+	}
+	if len(config.Modules) > 0 {
+		// Only build the selected modules, not the upstream modules they depend on
+		plan.Only(config.Modules)
+	}
+	// Modules with no declared command, Makefile target or default command have nothing to build
+	for _, moduleName := range plan.Missing {
+		logger.Info("No build command for module, skipping", "module", moduleName)
+		result.ModulesSkipped = append(result.ModulesSkipped, moduleName)
+	}
 	
-	// For demonstration, we'll build all modules
-	for moduleName := range graph.Modules {
-		if config.DryRun {
-			logger.Info("Would build module (dry-run)", "module", moduleName)
-			result.ModulesBuilt = append(result.ModulesBuilt, moduleName)
-		} else {
-			logger.Info("Building module", "module", moduleName)
-			
-			// Execute build command for the module
-			// In a real implementation, this would be language-specific
-			err := buildModule(moduleName, graph.Modules[moduleName])
-			if err != nil {
-				logger.Error("Failed to build module", "module", moduleName, "error", err)
-				result.Errors = append(result.Errors, moduleName+": "+err.Error())
-			} else {
-				result.ModulesBuilt = append(result.ModulesBuilt, moduleName)
-			}
+	maxConcurrent := config.MaxConcurrent
+	if !config.Parallel {
+		maxConcurrent = 1
+	}
+	
+	// Run the plan; the pre-build and post-build plugin hooks run around it
+	options := tasks.RunOptions{
+		MaxConcurrent: maxConcurrent,
+		DryRun:        config.DryRun,
+		Exec:          buildModule,
+		Timeout:       config.Timeout,
+	}
+	if !config.NoCache {
+		options.Cache = tasks.NewCache()
+	}
+	run := tasks.Run(plan, options)
+	result.RunID = run.RunID
+	result.Duration = run.Duration().String()
+	result.CriticalPath, _ = tasks.CriticalPath(plan, run)
+	
+	for _, node := range run.Nodes {
+		name := node.Module
+		if node.Task != "build" {
+			name = node.ID
+		}
+		if node.LogFile != "" {
+			result.LogFiles[name] = node.LogFile
+		}
+		if node.Attempts > 0 {
+			result.Attempts[name] = node.Attempts
+		}
+		if node.TimedOut {
+			// Record the phase (task) that ran out of time against its module
+			result.TimedOut[node.Module] = node.Task
+		}
+		
+		switch node.Status {
+		case tasks.StatusSucceeded, tasks.StatusDryRun:
+			result.ModulesBuilt = append(result.ModulesBuilt, name)
+		case tasks.StatusCached:
+			result.ModulesSkipped = append(result.ModulesSkipped, name)
+		case tasks.StatusSkipped:
+			result.ModulesSkipped = append(result.ModulesSkipped, name)
+			result.Errors = append(result.Errors, name+": skipped, "+node.Error)
+		case tasks.StatusFailed:
+			result.Errors = append(result.Errors, name+": "+node.Error)
 		}
 	}
 	
-	// Execute post-build plugins
-	if err := pluginManager.ExecuteHook("post-build"); err != nil {
-		logger.Error("Failed to execute post-build hook", "error", err)
+	if run.TimedOut {
+		result.Errors = append(result.Errors, fmt.Sprintf("build timed out after %s", config.Timeout))
+	}
+	
+	// Export the timing profile and summarize it if requested
+	if config.Profile != "" && !config.DryRun {
+		if err := tasks.WriteTrace(config.Profile, plan, run); err != nil {
+			logger.Error("Failed to write build profile", "file", config.Profile, "error", err)
+			result.Errors = append(result.Errors, "profile: "+err.Error())
+		} else {
+			logger.Info("Build profile saved", "file", config.Profile)
+		}
+		tasks.WriteSummary(os.Stdout, plan, run)
 	}
 	
 	logger.Info("Incremental build completed", 
@@ -84,51 +143,31 @@ func IncrementalBuild(graph *analyzer.RepoGraph, config BuildConfig) *BuildResul
 	return result
 }
 
-// buildModule executes the build command for a specific module
-func buildModule(moduleName string, module analyzer.Module) error {
-	// This is a simplified implementation
-	// In a real system, we would determine the appropriate build command
-	// based on the language and project configuration
-	
-	var cmd *exec.Cmd
-	
+// buildModule executes the build task of a planned node, streaming its output
+func buildModule(ctx context.Context, node *tasks.Node, output io.Writer) error {
 	// Validate the module path to prevent directory traversal and command injection attacks
 	// Clean the path to remove any .. or . components
-	cleanPath := filepath.Clean(module.Path)
+	cleanPath := filepath.Clean(node.Module.Path)
 
 	// Ensure the path is relative and doesn't start with ..
 	if filepath.IsAbs(cleanPath) || strings.HasPrefix(cleanPath, "..") {
-		return fmt.Errorf("invalid module path: %s", module.Path)
+		return fmt.Errorf("invalid module path: %s", node.Module.Path)
 	}
 
 	// Additional validation to prevent command injection
 	if strings.Contains(cleanPath, ";") || strings.Contains(cleanPath, "|") ||
 	   strings.Contains(cleanPath, "&") || strings.Contains(cleanPath, "`") {
-		return fmt.Errorf("module path contains potentially dangerous characters: %s", module.Path)
+		return fmt.Errorf("module path contains potentially dangerous characters: %s", node.Module.Path)
 	}
 	
-	switch module.Language {
-	case "go":
-		// For Go modules, we might run 'go build'
-		cmd = exec.Command("go", "build", "./"+cleanPath) // #nosec G204 -- Path validated above
-	case "javascript", "typescript":
-		// For JS/TS projects, we might run 'npm run build' or 'yarn build'
-		// Check if package.json exists in the module directory
-		cmd = exec.Command("npm", "run", "build")
-		cmd.Dir = cleanPath
-	case "python":
-		// For Python projects, we might run a build script
-		cmd = exec.Command("python", "setup.py", "build")
-		cmd.Dir = cleanPath
-	}
+	logger.Debug("Resolved build task", "task", node.ID, "command", node.Task.Command, "source", node.Task.Source, "dir", node.Task.Dir)
 
 	// Execute the command
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		logger.Error("Build failed", "module", moduleName, "output", string(output), "error", err)
+	if err := node.Task.Execute(ctx, output); err != nil {
+		logger.Error("Build failed", "module", node.Module.Name, "error", err)
 		return err
 	}
 
-	logger.Debug("Build successful", "module", moduleName, "output", string(output))
+	logger.Debug("Build successful", "module", node.Module.Name)
 	return nil
-}
\ No newline at end of file
+}
@@ -15,7 +15,11 @@ type Config struct {
 	LogLevel string `yaml:"log_level"`
 	Analyzer AnalyzerConfig `yaml:"analyzer"`
 	Build BuildConfig `yaml:"build"`
+	Test TestConfig `yaml:"test"`
+	Watch WatchConfig `yaml:"watch"`
 	Release ReleaseConfig `yaml:"release"`
+	Languages map[string]LanguageConfig `yaml:"languages"`
+	Modules map[string]ModuleConfig `yaml:"modules"`
 }
 
 // AnalyzerConfig represents the analyzer configuration
@@ -29,6 +33,29 @@ type BuildConfig struct {
 	DefaultCommand string `yaml:"default_command"`
 	Parallel bool `yaml:"parallel"`
 	MaxConcurrent int `yaml:"max_concurrent"`
+	Timeout string `yaml:"timeout"`
+	TaskTimeout string `yaml:"task_timeout"`
+	Retries int `yaml:"retries"`
+	Hermetic bool `yaml:"hermetic"`
+	EnvAllow []string `yaml:"env_allow"`
+}
+
+// TestConfig represents the test configuration
+type TestConfig struct {
+	Parallel bool `yaml:"parallel"`
+	MaxConcurrent int `yaml:"max_concurrent"`
+	Retries int `yaml:"retries"`
+	Quarantine []string `yaml:"quarantine"`
+	ShardDurations string `yaml:"shard_durations"`
+	MinCoverage float64 `yaml:"min_coverage"`
+}
+
+// WatchConfig represents the watch mode configuration
+type WatchConfig struct {
+	Task string `yaml:"task"`
+	Debounce string `yaml:"debounce"`
+	PollInterval string `yaml:"poll_interval"`
+	Ignore []string `yaml:"ignore"`
 }
 
 // ReleaseConfig represents the release configuration
@@ -37,6 +64,97 @@ type ReleaseConfig struct {
 	ChangelogFormat string `yaml:"changelog_format"`
 }
 
+// TaskConfig describes how to run a single task (build, test, lint, ...) for a module
+type TaskConfig struct {
+	Command string `yaml:"command"`
+	Dir string `yaml:"dir"`
+	Env map[string]string `yaml:"env"`
+	DependsOn []string `yaml:"depends_on"`
+	Cache *bool `yaml:"cache"`
+	Timeout string `yaml:"timeout"`
+	Retries *int `yaml:"retries"`
+	MemoryLimit string `yaml:"memory_limit"`
+	CPULimit string `yaml:"cpu_limit"`
+	Hermetic *bool `yaml:"hermetic"`
+	EnvAllow []string `yaml:"env_allow"`
+	ReportFormat string `yaml:"report_format"`
+	ReportFile string `yaml:"report_file"`
+	CoverageCommand string `yaml:"coverage_command"`
+	CoverageFormat string `yaml:"coverage_format"`
+	CoverageFile string `yaml:"coverage_file"`
+	MinCoverage *float64 `yaml:"min_coverage"`
+	SelectArgs string `yaml:"select_args"`
+}
+
+// LanguageConfig holds the default tasks for every module of a language
+type LanguageConfig struct {
+	Tasks map[string]TaskConfig `yaml:"tasks"`
+}
+
+// ModuleConfig holds the task declarations for a single module. It is used both
+// for the modules section of the root config and for a module's mono.yaml manifest.
+type ModuleConfig struct {
+	Language string `yaml:"language"`
+	Env map[string]string `yaml:"env"`
+	EnvAllow []string `yaml:"env_allow"`
+	Hermetic *bool `yaml:"hermetic"`
+	Tasks map[string]TaskConfig `yaml:"tasks"`
+}
+
+// ModuleManifestName is the name of the per-module manifest file
+const ModuleManifestName = "mono.yaml"
+
+// DefaultLanguages returns the built-in task defaults for each supported
+// language. They are not part of the default config: the task resolver layers
+// them under the languages section of the config.
+func DefaultLanguages() map[string]LanguageConfig {
+	noCache := false
+	return map[string]LanguageConfig{
+		"go": {
+			Tasks: map[string]TaskConfig{
+				"build": {Command: "go build .", DependsOn: []string{"^build"}},
+				"test": {
+					Command: "go test -json ./...", Cache: &noCache, ReportFormat: "go-json",
+					CoverageCommand: "go test -json -coverprofile=\"$MONO_COVERAGE_FILE\" ./...", CoverageFormat: "go-cover",
+					SelectArgs: "-run \"$MONO_TEST_PATTERN\"",
+				},
+				"lint": {Command: "go vet ./..."},
+			},
+		},
+		"javascript": {
+			Tasks: map[string]TaskConfig{
+				"build": {Command: "npm run build", DependsOn: []string{"^build"}},
+				"test": {
+					Command: "npm test -- --json --outputFile=\"$MONO_REPORT_FILE\"", Cache: &noCache, ReportFormat: "jest-json",
+					CoverageCommand: "npm test -- --json --outputFile=\"$MONO_REPORT_FILE\" --coverage", CoverageFormat: "lcov", CoverageFile: "coverage/lcov.info",
+				},
+				"lint": {Command: "npm run lint"},
+			},
+		},
+		"typescript": {
+			Tasks: map[string]TaskConfig{
+				"build": {Command: "npm run build", DependsOn: []string{"^build"}},
+				"test": {
+					Command: "npm test -- --json --outputFile=\"$MONO_REPORT_FILE\"", Cache: &noCache, ReportFormat: "jest-json",
+					CoverageCommand: "npm test -- --json --outputFile=\"$MONO_REPORT_FILE\" --coverage", CoverageFormat: "lcov", CoverageFile: "coverage/lcov.info",
+				},
+				"lint": {Command: "npm run lint"},
+			},
+		},
+		"python": {
+			Tasks: map[string]TaskConfig{
+				"build": {Command: "python setup.py build", DependsOn: []string{"^build"}},
+				"test": {
+					Command: "python -m pytest --junitxml=\"$MONO_REPORT_FILE\"", Cache: &noCache, ReportFormat: "junit",
+					CoverageCommand: "python -m pytest --junitxml=\"$MONO_REPORT_FILE\" --cov=. --cov-report=xml:\"$MONO_COVERAGE_FILE\"",
+					CoverageFormat: "cobertura",
+					SelectArgs: "-k \"$MONO_TEST_EXPRESSION\"",
+				},
+			},
+		},
+	}
+}
+
 // DefaultConfig returns the default configuration
 func DefaultConfig() *Config {
 	return &Config{
@@ -50,10 +168,23 @@ func DefaultConfig() *Config {
 			Parallel: true,
 			MaxConcurrent: 4,
 		},
+		Test: TestConfig{
+			Parallel: true,
+			MaxConcurrent: 4,
+			Quarantine: []string{},
+		},
+		Watch: WatchConfig{
+			Task: "test",
+			Debounce: "300ms",
+			PollInterval: "1s",
+			Ignore: []string{"coverage", "*.swp", "*~", ".#*"},
+		},
 		Release: ReleaseConfig{
 			DefaultBump: "patch",
 			ChangelogFormat: "markdown",
 		},
+		Languages: map[string]LanguageConfig{},
+		Modules: map[string]ModuleConfig{},
 	}
 }
 
@@ -129,4 +260,28 @@ func LoadConfigFromDir(dirPath string) (*Config, error) {
 	}
 	
 	return LoadConfig(configPath)
-}
\ No newline at end of file
+}
+
+// LoadModuleManifest loads the mono.yaml manifest from a module directory.
+// It returns nil without an error if the module has no manifest.
+func LoadModuleManifest(moduleDir string) (*ModuleConfig, error) {
+	manifestPath := filepath.Join(filepath.Clean(moduleDir), ModuleManifestName)
+
+	data, err := os.ReadFile(manifestPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		logger.Error("Failed to read module manifest", "path", manifestPath, "error", err)
+		return nil, err
+	}
+
+	manifest := &ModuleConfig{}
+	if err := yaml.Unmarshal(data, manifest); err != nil {
+		logger.Error("Failed to parse module manifest", "path", manifestPath, "error", err)
+		return nil, fmt.Errorf("invalid module manifest %s: %v", manifestPath, err)
+	}
+
+	logger.Debug("Loaded module manifest", "path", manifestPath)
+	return manifest, nil
+}
@@ -1,6 +1,12 @@
 package impact
 
 import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
 	"mono-mind/internal/analyzer"
 	"mono-mind/internal/logger"
 )
@@ -8,42 +14,186 @@ import (
 // ImpactResult holds the result of an impact analysis
 type ImpactResult struct {
 	ChangedFile      string   `json:"changed_file"`
+	ChangedFiles     []string `json:"changed_files"`
+	ChangedModules   []string `json:"changed_modules"`
 	AffectedModules  []string `json:"affected_modules"`
 	AffectedTests    []string `json:"affected_tests"`
+	SelectedTests    map[string][]string `json:"selected_tests,omitempty"`
+	Reasons          map[string]string `json:"reasons"`
 	Conflicts        []string `json:"conflicts"`
 }
 
 // AnalyzeImpact analyzes the impact of a file change on the repository
 func AnalyzeImpact(graph *analyzer.RepoGraph, changedFile string) *ImpactResult {
 	logger.Info("Analyzing impact for file", "file", changedFile)
-	
+
+	result := AnalyzeChanges(graph, []string{changedFile})
+	result.ChangedFile = changedFile
+
+	logger.Info("Impact analysis completed",
+		"affected_modules", len(result.AffectedModules),
+		"affected_tests", len(result.AffectedTests))
+
+	return result
+}
+
+// AnalyzeChanges analyzes the impact of a set of changed files. The affected
+// modules are the modules containing the files plus all their transitive dependents.
+func AnalyzeChanges(graph *analyzer.RepoGraph, changedFiles []string) *ImpactResult {
+	return analyzeChanges(graph, changedFiles, false)
+}
+
+// AnalyzeTestImpact analyzes which modules' tests must run for a set of changed
+// files: the affected modules and, with includeTestEdges, the modules whose
+// tests import an affected module. The affected tests are listed by module.
+func AnalyzeTestImpact(graph *analyzer.RepoGraph, changedFiles []string, includeTestEdges bool) *ImpactResult {
+	return analyzeChanges(graph, changedFiles, includeTestEdges)
+}
+
+// analyzeChanges walks the reverse dependency edges from the changed modules,
+// recording why each module was selected
+func analyzeChanges(graph *analyzer.RepoGraph, changedFiles []string, includeTestEdges bool) *ImpactResult {
 	result := &ImpactResult{
-		ChangedFile:     changedFile,
+		ChangedFiles:    changedFiles,
+		ChangedModules:  []string{},
 		AffectedModules: []string{},
 		AffectedTests:   []string{},
+		Reasons:         map[string]string{},
 		Conflicts:       []string{},
 	}
-	
-	// Find the module that contains the changed file
-	// In a real implementation, we would parse the file path to determine the module
-	// For now, we'll use a simplified approach
-	changedModule := "example" // Placeholder
-	
-	// Find all modules that depend on the changed module
-	dependents := graph.GetDependentModules(changedModule)
-	result.AffectedModules = append(result.AffectedModules, dependents...)
-	
-	// Add the changed module itself
-	result.AffectedModules = append(result.AffectedModules, changedModule)
-	
-	// In a real implementation, we would also:
-	// 1. Map affected modules to tests
-	// 2. Check for potential conflicts
-	// 3. Analyze the actual content of the changed file
-	
-	logger.Info("Impact analysis completed", 
-		"affected_modules", len(result.AffectedModules),
-		"affected_tests", len(result.AffectedTests))
-	
+
+	// Map each changed file to the module that contains it
+	changed := map[string][]string{}
+	for _, file := range changedFiles {
+		moduleName, ok := graph.ModuleForFile(file)
+		if !ok {
+			logger.Debug("Changed file does not belong to a module", "file", file)
+			continue
+		}
+		if changed[moduleName] == nil {
+			result.ChangedModules = append(result.ChangedModules, moduleName)
+		}
+		changed[moduleName] = append(changed[moduleName], file)
+	}
+	sort.Strings(result.ChangedModules)
+
+	for _, moduleName := range result.ChangedModules {
+		result.Reasons[moduleName] = changedReason(changed[moduleName])
+	}
+
+	// Walk the reverse dependency edges to collect transitive dependents
+	affected := map[string]bool{}
+	queue := append([]string{}, result.ChangedModules...)
+	for len(queue) > 0 {
+		moduleName := queue[0]
+		queue = queue[1:]
+		if affected[moduleName] {
+			continue
+		}
+		affected[moduleName] = true
+		for _, dependent := range graph.GetInternalDependents(moduleName) {
+			if _, ok := result.Reasons[dependent]; !ok {
+				result.Reasons[dependent] = "depends on " + moduleName
+			}
+			queue = append(queue, dependent)
+		}
+	}
+
+	for moduleName := range affected {
+		result.AffectedModules = append(result.AffectedModules, moduleName)
+	}
+	sort.Strings(result.AffectedModules)
+
+	// Tests only import other modules, so test edges are followed a single step
+	tests := map[string]bool{}
+	for _, moduleName := range result.AffectedModules {
+		tests[moduleName] = true
+	}
+	if includeTestEdges {
+		for _, moduleName := range result.AffectedModules {
+			for _, dependent := range graph.GetTestDependents(moduleName) {
+				if _, ok := result.Reasons[dependent]; !ok {
+					result.Reasons[dependent] = "tests depend on " + moduleName
+				}
+				tests[dependent] = true
+			}
+		}
+	}
+
+	for moduleName := range tests {
+		result.AffectedTests = append(result.AffectedTests, moduleName)
+	}
+	sort.Strings(result.AffectedTests)
+
 	return result
-}
\ No newline at end of file
+}
+
+// changedReason describes a module selected because its own files changed
+func changedReason(files []string) string {
+	sort.Strings(files)
+	if len(files) == 1 {
+		return "changed " + filepath.ToSlash(files[0])
+	}
+	return fmt.Sprintf("changed %s and %d more files", filepath.ToSlash(files[0]), len(files)-1)
+}
+
+// gitRefPattern matches the characters allowed in a git revision passed to --since
+var gitRefPattern = regexp.MustCompile(`^[a-zA-Z0-9._/~^@{}\-]+$`)
+
+// ChangedFiles returns the files changed since a git ref, including
+// uncommitted and untracked files in the working tree
+func ChangedFiles(since string) ([]string, error) {
+	// Validate the ref to prevent option injection into git
+	if !gitRefPattern.MatchString(since) || strings.HasPrefix(since, "-") {
+		return nil, fmt.Errorf("invalid git ref: %s", since)
+	}
+
+	return gitFiles([][]string{
+		{"diff", "--name-only", since + "...HEAD"},
+		{"diff", "--name-only", "HEAD"},
+		{"ls-files", "--others", "--exclude-standard"},
+	})
+}
+
+// commitPattern matches a full git commit hash
+var commitPattern = regexp.MustCompile(`^[0-9a-f]{40}([0-9a-f]{24})?$`)
+
+// filesChangedSince returns the files of the working tree that differ from a
+// commit, including untracked files
+func filesChangedSince(commit string) ([]string, error) {
+	// Validate the commit to prevent option injection into git
+	if !commitPattern.MatchString(commit) {
+		return nil, fmt.Errorf("invalid git commit: %q", commit)
+	}
+
+	return gitFiles([][]string{
+		{"diff", "--name-only", commit},
+		{"ls-files", "--others", "--exclude-standard"},
+	})
+}
+
+// gitFiles returns the sorted union of the files listed by git commands
+func gitFiles(commands [][]string) ([]string, error) {
+	seen := map[string]bool{}
+	files := []string{}
+	for _, args := range commands {
+		output, err := exec.Command("git", args...).Output() // #nosec G204 -- Refs validated by the callers
+		if err != nil {
+			return nil, fmt.Errorf("failed to run git %s: %v", strings.Join(args, " "), err)
+		}
+		for _, line := range strings.Split(string(output), "\n") {
+			// Skip mono's own state directory, which is not part of any module
+			file := filepath.FromSlash(strings.TrimSpace(line))
+			if strings.HasPrefix(filepath.ToSlash(file), ".mono/") {
+				continue
+			}
+			if file != "" && !seen[file] {
+				seen[file] = true
+				files = append(files, file)
+			}
+		}
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
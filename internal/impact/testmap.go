@@ -0,0 +1,245 @@
+package impact
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"mono-mind/internal/analyzer"
+	"mono-mind/internal/logger"
+	"mono-mind/internal/tasks"
+)
+
+// TestImpactFile is where the files covered by each test are kept
+var TestImpactFile = filepath.Join(tasks.StateDir, "test-impact.json")
+
+// TestImpactMap records, per module, the source files each test covered in a coverage run
+type TestImpactMap struct {
+	Modules map[string]ModuleTestImpact `json:"modules"`
+}
+
+// ModuleTestImpact holds the files covered by the tests of a single module.
+// Files lists every file instrumented by the module's coverage runs, including
+// files no test covered. Commit is the git HEAD the coverage runs tested.
+type ModuleTestImpact struct {
+	RecordedAt string              `json:"recorded_at"`
+	Commit     string              `json:"commit"`
+	Files      []string            `json:"files"`
+	Tests      map[string][]string `json:"tests"`
+}
+
+// LoadTestImpactMap reads the test impact map, returning an empty map if there is none
+func LoadTestImpactMap(path string) (*TestImpactMap, error) {
+	impactMap := &TestImpactMap{Modules: map[string]ModuleTestImpact{}}
+
+	data, err := os.ReadFile(filepath.Clean(path))
+	if os.IsNotExist(err) {
+		return impactMap, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, impactMap); err != nil {
+		return nil, fmt.Errorf("invalid test impact map %s: %v", path, err)
+	}
+	if impactMap.Modules == nil {
+		impactMap.Modules = map[string]ModuleTestImpact{}
+	}
+	return impactMap, nil
+}
+
+// Record replaces the recorded test impact of a module
+func (m *TestImpactMap) Record(module string, files []string, tests map[string][]string) {
+	files = append([]string{}, files...)
+	sort.Strings(files)
+	m.Modules[module] = ModuleTestImpact{
+		RecordedAt: time.Now().Format(time.RFC3339),
+		Commit:     headCommit(),
+		Files:      files,
+		Tests:      tests,
+	}
+}
+
+// Save writes the test impact map
+func (m *TestImpactMap) Save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// SelectTests narrows the affected tests of an impact result to the individual
+// tests that covered a changed file. A module keeps all its tests when its
+// impact data is missing or stale: when a file of the module or of a module it
+// depends on differs from the recorded commit without being a changed file,
+// one of its test files changed, or a relevant changed file was never
+// instrumented by its coverage runs. Data recorded at the base the changes are
+// compared against therefore stays valid for every branch built on it.
+// Modules none of whose tests cover a changed file are dropped.
+func (m *TestImpactMap) SelectTests(graph *analyzer.RepoGraph, result *ImpactResult) {
+	result.SelectedTests = map[string][]string{}
+	drifts := map[string]*commitDrift{}
+
+	// Map the changed files to their modules once
+	changed := map[string]bool{}
+	changedIn := map[string][]string{}
+	for _, file := range result.ChangedFiles {
+		changed[filepath.ToSlash(filepath.Clean(file))] = true
+		if moduleName, ok := graph.ModuleForFile(file); ok {
+			changedIn[moduleName] = append(changedIn[moduleName], filepath.ToSlash(filepath.Clean(file)))
+		}
+	}
+
+	affected := []string{}
+	for _, moduleName := range result.AffectedTests {
+		entry, ok := m.Modules[moduleName]
+		if !ok {
+			result.Reasons[moduleName] += " (no test impact data)"
+			affected = append(affected, moduleName)
+			continue
+		}
+
+		instrumented := map[string]bool{}
+		for _, file := range entry.Files {
+			instrumented[file] = true
+		}
+
+		// The changed files that can affect the module are its own and those of its upstream modules
+		upstreams := upstreamModules(graph, moduleName)
+		drift, ok := drifts[entry.Commit]
+		if !ok {
+			drift = newCommitDrift(graph, entry.Commit)
+			drifts[entry.Commit] = drift
+		}
+		stale := drift.staleness(upstreams, changed)
+		selected := map[string]bool{}
+		for _, upstream := range upstreams {
+			for _, file := range changedIn[upstream] {
+				switch {
+				case upstream == moduleName && analyzer.IsTestFile(file):
+					stale = "test file " + file + " changed"
+				case !instrumented[file]:
+					stale = file + " was not instrumented"
+				}
+				for test, files := range entry.Tests {
+					for _, covered := range files {
+						if covered == file {
+							selected[test] = true
+						}
+					}
+				}
+			}
+		}
+
+		switch {
+		case stale != "":
+			logger.Debug("Test impact data is stale", "module", moduleName, "reason", stale)
+			result.Reasons[moduleName] += " (test impact data is stale: " + stale + ")"
+			affected = append(affected, moduleName)
+		case len(selected) == 0:
+			logger.Info("No tests cover the changed files", "module", moduleName)
+		default:
+			tests := []string{}
+			for test := range selected {
+				tests = append(tests, test)
+			}
+			sort.Strings(tests)
+			result.SelectedTests[moduleName] = tests
+			result.Reasons[moduleName] += fmt.Sprintf(" (%d of %d tests cover the changes)", len(tests), len(entry.Tests))
+			affected = append(affected, moduleName)
+		}
+	}
+	result.AffectedTests = affected
+}
+
+// commitDrift holds the files of each module that differ between a recorded
+// commit and the working tree
+type commitDrift struct {
+	commit string
+	err    error
+	files  map[string][]string
+}
+
+// newCommitDrift lists the files that differ from a recorded commit by module
+func newCommitDrift(graph *analyzer.RepoGraph, commit string) *commitDrift {
+	drift := &commitDrift{commit: commit, files: map[string][]string{}}
+	if commit == "" {
+		drift.err = fmt.Errorf("no commit was recorded")
+		return drift
+	}
+
+	files, err := filesChangedSince(commit)
+	if err != nil {
+		// The commit may be missing from a shallow clone
+		drift.err = fmt.Errorf("cannot compare with commit %s: %v", commit, err)
+		return drift
+	}
+	for _, file := range files {
+		if moduleName, ok := graph.ModuleForFile(file); ok {
+			drift.files[moduleName] = append(drift.files[moduleName], filepath.ToSlash(filepath.Clean(file)))
+		}
+	}
+	return drift
+}
+
+// staleness returns why impact data recorded at the commit no longer
+// describes a module, or an empty string if it still does: every file of the
+// module and of its upstream modules that differs from the commit must be a
+// changed file, whose covering tests are selected
+func (d *commitDrift) staleness(upstreams []string, changed map[string]bool) string {
+	if d.err != nil {
+		return d.err.Error()
+	}
+	for _, upstream := range upstreams {
+		for _, file := range d.files[upstream] {
+			if !changed[file] {
+				return fmt.Sprintf("%s differs from commit %.12s, where the data was recorded", file, d.commit)
+			}
+		}
+	}
+	return ""
+}
+
+// headCommit returns the commit checked out by git, or an empty string outside a git repository
+func headCommit() string {
+	output, err := exec.Command("git", "rev-parse", "HEAD").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(output))
+}
+
+// upstreamModules returns a module and every module it transitively depends
+// on, including the direct dependencies of its tests
+func upstreamModules(graph *analyzer.RepoGraph, moduleName string) []string {
+	queue := []string{moduleName}
+	for _, dep := range graph.Modules[moduleName].TestDependencies {
+		if depModule, ok := graph.ResolveDependency(dep); ok {
+			queue = append(queue, depModule)
+		}
+	}
+
+	seen := map[string]bool{}
+	modules := []string{}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		if seen[current] {
+			continue
+		}
+		seen[current] = true
+		modules = append(modules, current)
+		queue = append(queue, graph.GetInternalDependencies(current)...)
+	}
+	return modules
+}
@@ -0,0 +1,175 @@
+package refactor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"mono-mind/internal/logger"
+)
+
+// fileChange is the change a refactoring makes to a single file
+type fileChange struct {
+	oldPath string // empty when the file is created
+	newPath string // empty when the file is removed
+	content []byte // content after the change; nil keeps the old content
+}
+
+// changeSet collects the file changes of a refactoring before they are
+// written, keyed by the path of each file before the change
+type changeSet struct {
+	changes map[string]*fileChange
+}
+
+// newChangeSet creates an empty change set
+func newChangeSet() *changeSet {
+	return &changeSet{changes: map[string]*fileChange{}}
+}
+
+// change returns the change of a file given by its path before the change,
+// recording an unchanged file if there is none yet
+func (s *changeSet) change(path string) *fileChange {
+	path = filepath.Clean(path)
+	if change, exists := s.changes[path]; exists {
+		return change
+	}
+	change := &fileChange{oldPath: path, newPath: path}
+	s.changes[path] = change
+	return change
+}
+
+// edit replaces the content of an existing file
+func (s *changeSet) edit(path string, content []byte) {
+	s.change(path).content = content
+}
+
+// move moves an existing file
+func (s *changeSet) move(oldPath, newPath string) {
+	s.change(oldPath).newPath = filepath.Clean(newPath)
+}
+
+// create adds a new file
+func (s *changeSet) create(path string, content []byte) {
+	path = filepath.Clean(path)
+	s.changes["+"+path] = &fileChange{newPath: path, content: content}
+}
+
+// current returns the content of a file with the edits made to it so far
+func (s *changeSet) current(path string) ([]byte, error) {
+	if change, exists := s.changes[filepath.Clean(path)]; exists && change.content != nil {
+		return change.content, nil
+	}
+	return os.ReadFile(filepath.Clean(path))
+}
+
+// remove deletes an existing file
+func (s *changeSet) remove(path string) {
+	s.change(path).newPath = ""
+}
+
+// empty reports whether the change set changes nothing
+func (s *changeSet) empty() bool {
+	return len(s.sorted()) == 0
+}
+
+// sorted returns the changes that change something, ordered by path
+func (s *changeSet) sorted() []*fileChange {
+	changes := []*fileChange{}
+	for _, change := range s.changes {
+		if change.oldPath == change.newPath && change.content == nil {
+			continue
+		}
+		changes = append(changes, change)
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].path() < changes[j].path()
+	})
+	return changes
+}
+
+// path returns the path a change is reported under: the path before the
+// change, or the new path of a created file
+func (c *fileChange) path() string {
+	if c.oldPath != "" {
+		return c.oldPath
+	}
+	return c.newPath
+}
+
+// oldContent returns the content of a file before the change
+func (c *fileChange) oldContent() ([]byte, error) {
+	if c.oldPath == "" {
+		return nil, nil
+	}
+	return os.ReadFile(c.oldPath)
+}
+
+// newContent returns the content of a file after the change
+func (c *fileChange) newContent() ([]byte, error) {
+	if c.newPath == "" {
+		return nil, nil
+	}
+	if c.content != nil {
+		return c.content, nil
+	}
+	return c.oldContent()
+}
+
+// describe returns a short description of a change for logs
+func (c *fileChange) describe() string {
+	switch {
+	case c.oldPath == "":
+		return "create " + c.newPath
+	case c.newPath == "":
+		return "remove " + c.oldPath
+	case c.oldPath != c.newPath:
+		return fmt.Sprintf("move %s to %s", c.oldPath, c.newPath)
+	}
+	return "edit " + c.oldPath
+}
+
+// textEdit replaces the bytes between two offsets of a file
+type textEdit struct {
+	start, end int
+	text       string
+}
+
+// applyEdits returns content with non-overlapping edits applied
+func applyEdits(content []byte, edits []textEdit) []byte {
+	sort.Slice(edits, func(i, j int) bool { return edits[i].start > edits[j].start })
+
+	// Apply from the end so earlier offsets stay valid
+	result := append([]byte{}, content...)
+	for _, edit := range edits {
+		result = append(result[:edit.start:edit.start], append([]byte(edit.text), result[edit.end:]...)...)
+	}
+	return result
+}
+
+// writeChanges applies the changes of a change set as one transaction, or
+// only reports them in a dry run
+func writeChanges(set *changeSet, config RefactorConfig, result *RefactorResult) {
+	changes := set.sorted()
+	for _, change := range changes {
+		result.FilesChanged = append(result.FilesChanged, change.path())
+	}
+
+	// If dry run, just report the changes
+	if config.DryRun {
+		for _, change := range changes {
+			logger.Info("Would refactor file (dry-run)", "file", change.path(), "change", change.describe())
+		}
+		return
+	}
+	if len(changes) == 0 {
+		return
+	}
+
+	if err := commitChanges(set, config.operation()); err != nil {
+		result.Errors = append(result.Errors, fmt.Sprintf("Error applying refactoring: %v", err))
+		return
+	}
+	for _, change := range changes {
+		logger.Info("Refactored file", "file", change.path(), "change", change.describe())
+	}
+}
@@ -0,0 +1,329 @@
+package refactor
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines shown around each change
+const diffContext = 3
+
+// diffOp is a line of an edit script: kept (' '), removed ('-') or added ('+')
+type diffOp struct {
+	kind byte
+	line string
+}
+
+// patch returns the changes of a change set as a git-style unified diff that
+// git apply accepts
+func (s *changeSet) patch() (string, error) {
+	var out strings.Builder
+	for _, change := range s.sorted() {
+		oldContent, err := change.oldContent()
+		if err != nil {
+			return "", err
+		}
+		newContent, err := change.newContent()
+		if err != nil {
+			return "", err
+		}
+
+		mode := "100644"
+		if change.oldPath != "" {
+			if info, err := os.Stat(change.oldPath); err == nil && info.Mode().Perm()&0111 != 0 {
+				mode = "100755"
+			}
+		}
+
+		oldName, newName := "a/"+slash(change.oldPath), "b/"+slash(change.newPath)
+		switch {
+		case change.oldPath == "":
+			fmt.Fprintf(&out, "diff --git a/%s %s\nnew file mode %s\n", slash(change.newPath), newName, mode)
+			oldName = "/dev/null"
+		case change.newPath == "":
+			fmt.Fprintf(&out, "diff --git %s b/%s\ndeleted file mode %s\n", oldName, slash(change.oldPath), mode)
+			newName = "/dev/null"
+		default:
+			fmt.Fprintf(&out, "diff --git %s %s\n", oldName, newName)
+			if change.oldPath != change.newPath {
+				fmt.Fprintf(&out, "similarity index %d%%\nrename from %s\nrename to %s\n",
+					similarity(oldContent, newContent), slash(change.oldPath), slash(change.newPath))
+			}
+		}
+
+		if !bytes.Equal(oldContent, newContent) {
+			fmt.Fprintf(&out, "--- %s\n+++ %s\n", oldName, newName)
+			writeHunks(&out, diffLines(splitLines(oldContent), splitLines(newContent)))
+		}
+	}
+	return out.String(), nil
+}
+
+// WriteDiff prints a unified diff, colored when written to a terminal
+func WriteDiff(w io.Writer, patch string) {
+	color := false
+	if file, ok := w.(*os.File); ok && os.Getenv("NO_COLOR") == "" {
+		if info, err := file.Stat(); err == nil {
+			color = info.Mode()&os.ModeCharDevice != 0
+		}
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(patch))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		code := ""
+		if color {
+			switch {
+			case strings.HasPrefix(line, "diff --git"), strings.HasPrefix(line, "--- "), strings.HasPrefix(line, "+++ "):
+				code = "1"
+			case strings.HasPrefix(line, "@@"):
+				code = "36"
+			case strings.HasPrefix(line, "+"):
+				code = "32"
+			case strings.HasPrefix(line, "-"):
+				code = "31"
+			}
+		}
+		if code != "" {
+			fmt.Fprintf(w, "\x1b[%sm%s\x1b[0m\n", code, line)
+		} else {
+			fmt.Fprintln(w, line)
+		}
+	}
+}
+
+// writeHunks writes the hunks of an edit script with their context lines
+func writeHunks(out *strings.Builder, ops []diffOp) {
+	for start := 0; start < len(ops); {
+		// Find the next change
+		first := start
+		for first < len(ops) && ops[first].kind == ' ' {
+			first++
+		}
+		if first == len(ops) {
+			return
+		}
+
+		// Extend the hunk while changes are close enough to share context
+		end := first
+		for i := first; i < len(ops); i++ {
+			if ops[i].kind != ' ' {
+				end = i + 1
+			} else if i-end >= 2*diffContext {
+				break
+			}
+		}
+
+		from := first - diffContext
+		if from < start {
+			from = start
+		}
+		to := end + diffContext
+		if to > len(ops) {
+			to = len(ops)
+		}
+
+		// Line numbers are counted from the beginning of the script
+		oldLine, newLine := 1, 1
+		for _, op := range ops[:from] {
+			if op.kind != '+' {
+				oldLine++
+			}
+			if op.kind != '-' {
+				newLine++
+			}
+		}
+		oldCount, newCount := 0, 0
+		for _, op := range ops[from:to] {
+			if op.kind != '+' {
+				oldCount++
+			}
+			if op.kind != '-' {
+				newCount++
+			}
+		}
+		if oldCount == 0 {
+			oldLine--
+		}
+		if newCount == 0 {
+			newLine--
+		}
+
+		fmt.Fprintf(out, "@@ -%s +%s @@\n", hunkRange(oldLine, oldCount), hunkRange(newLine, newCount))
+		for _, op := range ops[from:to] {
+			out.WriteByte(op.kind)
+			if strings.HasSuffix(op.line, "\n") {
+				out.WriteString(op.line)
+			} else {
+				out.WriteString(op.line + "\n\\ No newline at end of file\n")
+			}
+		}
+		start = to
+	}
+}
+
+// hunkRange formats the start and length of a hunk's lines
+func hunkRange(start, count int) string {
+	if count == 1 {
+		return fmt.Sprintf("%d", start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+// diffLines returns the shortest edit script turning one list of lines into
+// another, using Myers' algorithm on what lies between their common prefix
+// and suffix
+func diffLines(a, b []string) []diffOp {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	ops := make([]diffOp, 0, len(a)+len(b))
+	for _, line := range a[:prefix] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	ops = append(ops, myers(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	return ops
+}
+
+// myers computes an edit script with Myers' O(ND) algorithm, keeping for each
+// edit distance d the furthest reaching x of every diagonal k in [-d, d]
+func myers(a, b []string) []diffOp {
+	n, m := len(a), len(b)
+	if n == 0 || m == 0 {
+		ops := []diffOp{}
+		for _, line := range a {
+			ops = append(ops, diffOp{'-', line})
+		}
+		for _, line := range b {
+			ops = append(ops, diffOp{'+', line})
+		}
+		return ops
+	}
+
+	max := n + m
+	offset := max + 1
+	v := make([]int, 2*max+3)
+	trace := [][]int{}
+	at := func(snapshot []int, d, k int) int {
+		if k < -d || k > d {
+			return 0
+		}
+		return snapshot[k+d]
+	}
+
+	for d := 0; d <= max; d++ {
+		trace = append(trace, append([]int{}, v[offset-d:offset+d+1]...))
+		for k := -d; k <= d; k += 2 {
+			x := 0
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return backtrack(trace, a, b, at)
+			}
+		}
+	}
+	return nil
+}
+
+// backtrack follows the trace of Myers' algorithm back from the end of both
+// lists to build the edit script
+func backtrack(trace [][]int, a, b []string, at func([]int, int, int) int) []diffOp {
+	x, y := len(a), len(b)
+	reversed := []diffOp{}
+	for d := len(trace) - 1; d > 0; d-- {
+		snapshot := trace[d]
+		k := x - y
+		prevK := k - 1
+		if k == -d || (k != d && at(snapshot, d, k-1) < at(snapshot, d, k+1)) {
+			prevK = k + 1
+		}
+		prevX := at(snapshot, d, prevK)
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			reversed = append(reversed, diffOp{' ', a[x-1]})
+			x--
+			y--
+		}
+		if x == prevX {
+			reversed = append(reversed, diffOp{'+', b[y-1]})
+			y--
+		} else {
+			reversed = append(reversed, diffOp{'-', a[x-1]})
+			x--
+		}
+	}
+	for x > 0 && y > 0 {
+		reversed = append(reversed, diffOp{' ', a[x-1]})
+		x--
+		y--
+	}
+
+	ops := make([]diffOp, len(reversed))
+	for i, op := range reversed {
+		ops[len(reversed)-1-i] = op
+	}
+	return ops
+}
+
+// splitLines splits content into lines, each keeping its newline
+func splitLines(content []byte) []string {
+	lines := []string{}
+	for len(content) > 0 {
+		end := bytes.IndexByte(content, '\n')
+		if end < 0 {
+			end = len(content) - 1
+		}
+		lines = append(lines, string(content[:end+1]))
+		content = content[end+1:]
+	}
+	return lines
+}
+
+// similarity returns the percentage of lines two versions of a file share
+func similarity(oldContent, newContent []byte) int {
+	oldLines, newLines := splitLines(oldContent), splitLines(newContent)
+	total := len(oldLines)
+	if len(newLines) > total {
+		total = len(newLines)
+	}
+	if total == 0 {
+		return 100
+	}
+
+	kept := 0
+	for _, op := range diffLines(oldLines, newLines) {
+		if op.kind == ' ' {
+			kept++
+		}
+	}
+	return kept * 100 / total
+}
+
+// slash returns a path with forward slashes, as patches use
+func slash(path string) string {
+	return strings.ReplaceAll(path, "\\", "/")
+}
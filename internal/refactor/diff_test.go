@@ -0,0 +1,165 @@
+package refactor
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// numberedLines returns the lines "line 1" to "line n"
+func numberedLines(n int) []string {
+	lines := make([]string, n)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("line %d\n", i+1)
+	}
+	return lines
+}
+
+// readTree reads every file below a directory except those of git and mono
+func readTree(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	files := map[string]string{}
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if entry.Name() == ".git" || entry.Name() == ".mono" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = string(content)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestPatchAppliesWithGit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	long := strings.Join(numberedLines(40), "")
+	mixed := numberedLines(40)
+	mixed[2] = "changed 3\n"
+	mixed = append(mixed[:10], append([]string{"inserted a\n", "inserted b\n"}, mixed[10:]...)...)
+	mixed = append(mixed[:20], mixed[24:]...)
+	mixed[len(mixed)-1] = "last line without newline"
+
+	tests := []struct {
+		name   string
+		before map[string]string
+		change func(set *changeSet)
+		after  map[string]string
+	}{
+		{
+			name:   "edit in the middle of a file",
+			before: map[string]string{"a.txt": long},
+			change: func(set *changeSet) {
+				set.edit("a.txt", []byte(strings.Replace(long, "line 20\n", "line twenty\n", 1)))
+			},
+			after: map[string]string{"a.txt": strings.Replace(long, "line 20\n", "line twenty\n", 1)},
+		},
+		{
+			name:   "distant edits in separate hunks",
+			before: map[string]string{"a.txt": long},
+			change: func(set *changeSet) {
+				edited := strings.Replace(long, "line 2\n", "", 1)
+				edited = strings.Replace(edited, "line 38\n", "line 38\nextra\n", 1)
+				set.edit("a.txt", []byte(edited))
+			},
+			after: map[string]string{"a.txt": strings.Replace(strings.Replace(long, "line 2\n", "", 1), "line 38\n", "line 38\nextra\n", 1)},
+		},
+		{
+			name:   "nearby edits sharing context",
+			before: map[string]string{"a.txt": long},
+			change: func(set *changeSet) { set.edit("a.txt", []byte(strings.Join(mixed, ""))) },
+			after:  map[string]string{"a.txt": strings.Join(mixed, "")},
+		},
+		{
+			name:   "newline removed at end of file",
+			before: map[string]string{"a.txt": "one\ntwo\n"},
+			change: func(set *changeSet) { set.edit("a.txt", []byte("one\ntwo")) },
+			after:  map[string]string{"a.txt": "one\ntwo"},
+		},
+		{
+			name:   "line appended to a file without a final newline",
+			before: map[string]string{"a.txt": "one\ntwo"},
+			change: func(set *changeSet) { set.edit("a.txt", []byte("one\ntwo\nthree\n")) },
+			after:  map[string]string{"a.txt": "one\ntwo\nthree\n"},
+		},
+		{
+			name:   "content of an empty file",
+			before: map[string]string{"a.txt": ""},
+			change: func(set *changeSet) { set.edit("a.txt", []byte("one\n")) },
+			after:  map[string]string{"a.txt": "one\n"},
+		},
+		{
+			name:   "file created",
+			before: map[string]string{"a.txt": "one\n"},
+			change: func(set *changeSet) { set.create("dir/b.txt", []byte("new\nfile\n")) },
+			after:  map[string]string{"a.txt": "one\n", "dir/b.txt": "new\nfile\n"},
+		},
+		{
+			name:   "file removed",
+			before: map[string]string{"a.txt": "one\n", "b.txt": "two\nlines\n"},
+			change: func(set *changeSet) { set.remove("b.txt") },
+			after:  map[string]string{"a.txt": "one\n"},
+		},
+		{
+			name:   "file moved",
+			before: map[string]string{"a.txt": long},
+			change: func(set *changeSet) { set.move("a.txt", "sub/b.txt") },
+			after:  map[string]string{"sub/b.txt": long},
+		},
+		{
+			name:   "file moved and edited",
+			before: map[string]string{"a.txt": long, "c.txt": "keep\n"},
+			change: func(set *changeSet) {
+				set.move("a.txt", "b.txt")
+				set.edit("a.txt", []byte(strings.Replace(long, "line 1\n", "first\n", 1)))
+			},
+			after: map[string]string{"b.txt": strings.Replace(long, "line 1\n", "first\n", 1), "c.txt": "keep\n"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeModule(t, tt.before)
+			t.Chdir(dir)
+
+			set := newChangeSet()
+			tt.change(set)
+			patch, err := set.patch()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cmd := exec.Command("git", "apply", "-")
+			cmd.Stdin = strings.NewReader(patch)
+			if output, err := cmd.CombinedOutput(); err != nil {
+				t.Fatalf("git apply: %v\n%s\npatch:\n%s", err, output, patch)
+			}
+			if got := readTree(t, dir); !reflect.DeepEqual(got, tt.after) {
+				t.Errorf("applying the patch gave %q, want %q\npatch:\n%s", got, tt.after, patch)
+			}
+		})
+	}
+}
@@ -0,0 +1,655 @@
+package refactor
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+	"golang.org/x/tools/go/packages"
+	"mono-mind/internal/analyzer"
+	"mono-mind/internal/config"
+	"mono-mind/internal/logger"
+)
+
+// IssueExtract is the kind of the problems found by the checks run before an extraction
+const IssueExtract = "extract"
+
+// goExtraction moves Go files out of their package into the package of a new
+// Go module, and requalifies the references to what they declare
+type goExtraction struct {
+	program   *goProgram
+	files     map[string]bool // absolute paths of the extracted files
+	srcDir    string
+	srcImport string
+	newDir    string
+	newName   string
+	newImport string
+	objects   map[string]types.Object // objects declared in the extracted files, by key
+	importers map[string]bool         // files that import the new package after the extraction
+	issues    []RenameIssue
+	seen      map[string]bool
+}
+
+// Extract moves Go files of one package into a new Go module: the files get
+// the package clause of the new directory, a go.mod and a mono.yaml manifest
+// are created next to them, and the references to their declarations in the
+// original package and in every other package are qualified with the new
+// package, whose import is added. The modules of those packages require the
+// new module through a local replace directive, and a go.work is updated if
+// the repository has one. The extraction is refused if the extracted files use
+// declarations that stay behind, if unexported declarations would be used
+// across packages, if it would create an import cycle, or if Go packages with
+// errors could not be checked, unless it is forced.
+func Extract(config RefactorConfig) *RefactorResult {
+	newPath := filepath.Clean(config.NewPath)
+	logger.Info("Extracting files into a new module",
+		"files", strings.Join(config.Files, ","),
+		"to", newPath,
+		"dry_run", config.DryRun)
+
+	result := &RefactorResult{
+		FilesChanged: []string{},
+		Errors:       []string{},
+	}
+
+	if err := checkWorktree(config); err != nil {
+		result.Errors = append(result.Errors, fmt.Sprintf("Refusing to extract files to %s: %v", newPath, err))
+		return result
+	}
+
+	var err error
+	if config.Graph == nil {
+		if config.Graph, err = analyzer.AnalyzeRepo("."); err != nil {
+			logger.Warn("Failed to analyze repository, not registering the new module", "error", err)
+		}
+	}
+
+	set := newChangeSet()
+	result.Issues, err = extractGo(config, set, result)
+	if err != nil {
+		result.Errors = append(result.Errors, fmt.Sprintf("Error extracting files to %s: %v", newPath, err))
+		return result
+	}
+
+	// Refuse the extraction if any check failed
+	blocking := 0
+	for _, issue := range result.Issues {
+		if issue.Blocking {
+			blocking++
+			logger.Error("Extract check failed", "kind", issue.Kind, "position", issue.Position, "issue", issue.Message)
+		} else {
+			logger.Warn("Extract check", "kind", issue.Kind, "position", issue.Position, "issue", issue.Message)
+		}
+	}
+	// A dry run still shows the changes, but fails like the extraction would
+	refusal := ""
+	if blocking > 0 && !config.Force {
+		refusal = fmt.Sprintf("Refusing to extract files to %s: %d checks failed (use --force to extract anyway)", newPath, blocking)
+	}
+	if refusal != "" && !config.DryRun {
+		result.Errors = append(result.Errors, refusal)
+		return result
+	}
+
+	applyChangeSet(set, config, result)
+	if refusal != "" {
+		result.Errors = append(result.Errors, refusal)
+	}
+	if config.DryRun || len(result.Errors) > 0 {
+		return result
+	}
+
+	// Register the new module with the graph, along with the modules that now depend on it
+	if config.Graph != nil {
+		changed := config.Graph.UpdateFiles(append(result.FilesChanged, newPath))
+		if _, ok := config.Graph.Modules[filepath.Base(newPath)]; !ok {
+			logger.Warn("New module not found by the repository analysis", "path", newPath)
+		}
+		logger.Info("Registered new module", "module", filepath.Base(newPath), "modules_changed", strings.Join(changed, ","))
+	}
+	logger.Info("Extracted files into a new module", "to", newPath, "files", len(config.Files))
+
+	return result
+}
+
+// extractGo checks an extraction and records its changes in a change set
+func extractGo(config RefactorConfig, set *changeSet, result *RefactorResult) ([]RenameIssue, error) {
+	newPath := filepath.Clean(config.NewPath)
+	if len(config.Files) == 0 {
+		return nil, fmt.Errorf("no files to extract")
+	}
+
+	srcDir := ""
+	for _, file := range config.Files {
+		file = filepath.Clean(file)
+		if filepath.Ext(file) != ".go" {
+			return nil, fmt.Errorf("%s is not a Go file, only Go files can be extracted", file)
+		}
+		if !isRegularFile(file) {
+			return nil, fmt.Errorf("%s does not exist", file)
+		}
+		if srcDir != "" && filepath.Dir(file) != srcDir {
+			return nil, fmt.Errorf("%s is not in %s, the extracted files must belong to one package", file, srcDir)
+		}
+		srcDir = filepath.Dir(file)
+	}
+	if _, err := os.Stat(newPath); err == nil {
+		return nil, fmt.Errorf("%s already exists", newPath)
+	}
+
+	// Modules are named after their directory in the graph, so the name must be free
+	newName := filepath.Base(newPath)
+	if !token.IsIdentifier(newName) {
+		return nil, fmt.Errorf("%s is not a valid Go package name", newName)
+	}
+	if config.Graph != nil {
+		if existing, exists := config.Graph.Modules[newName]; exists {
+			return nil, fmt.Errorf("module %s already exists in %s, modules are named after their directory", newName, existing.Path)
+		}
+	}
+
+	srcModDir, srcModPath, ok := goModuleOf(srcDir)
+	if !ok {
+		return nil, fmt.Errorf("%s is not in a Go module", srcDir)
+	}
+	newImport := config.ModulePath
+	if newImport == "" {
+		newImport = filepath.ToSlash(newPath)
+		if modDir, modPath, ok := goModuleOf(filepath.Dir(newPath)); ok {
+			newImport = importPath(modPath, modDir, newPath)
+		}
+	}
+	if err := module.CheckImportPath(newImport); err != nil {
+		return nil, fmt.Errorf("invalid module path: %v", err)
+	}
+
+	program, err := loadGoProgram(".")
+	if err != nil {
+		return nil, fmt.Errorf("loading Go packages: %v", err)
+	}
+	result.SkippedPackages = program.skippedPackages()
+
+	e := &goExtraction{
+		program:   program,
+		files:     map[string]bool{},
+		srcDir:    srcDir,
+		srcImport: importPath(srcModPath, srcModDir, srcDir),
+		newDir:    newPath,
+		newName:   newName,
+		newImport: newImport,
+		objects:   map[string]types.Object{},
+		importers: map[string]bool{},
+		issues:    program.skippedIssues(),
+		seen:      map[string]bool{},
+	}
+	for _, file := range config.Files {
+		absFile, err := filepath.Abs(file)
+		if err != nil {
+			return nil, err
+		}
+		e.files[absFile] = true
+	}
+
+	found := map[string]bool{}
+	for _, pkg := range program.packages {
+		for _, file := range pkg.Syntax {
+			if filename := e.filename(file); e.files[filename] {
+				found[filename] = true
+			}
+		}
+	}
+	for file := range e.files {
+		if !found[file] {
+			return nil, fmt.Errorf("%s is not part of a loaded Go package", program.relPath(file))
+		}
+	}
+
+	e.collectObjects()
+	e.check()
+	if err := e.rewrite(set); err != nil {
+		return nil, err
+	}
+	if err := e.createModule(srcModDir, set); err != nil {
+		return nil, err
+	}
+	if err := e.requireModule(set); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(e.issues, func(i, j int) bool { return e.issues[i].Position < e.issues[j].Position })
+	return e.issues, nil
+}
+
+// filename returns the absolute name of a parsed file
+func (e *goExtraction) filename(file *ast.File) string {
+	return e.program.fset.File(file.Pos()).Name()
+}
+
+// inSourcePackage reports whether a file belongs to the package the files are
+// extracted from, rather than to its external test package
+func (e *goExtraction) inSourcePackage(file *ast.File) bool {
+	return filepath.Dir(e.program.relPath(e.filename(file))) == e.srcDir && !strings.HasSuffix(file.Name.Name, "_test")
+}
+
+// collectObjects records the package-level declarations, methods and fields
+// of the extracted files
+func (e *goExtraction) collectObjects() {
+	for _, pkg := range e.program.packages {
+		for ident, obj := range pkg.TypesInfo.Defs {
+			if obj == nil || obj.Pkg() == nil || !e.files[e.program.fset.Position(ident.Pos()).Filename] {
+				continue
+			}
+			if obj.Parent() == obj.Pkg().Scope() || isMember(obj) {
+				e.objects[e.program.objectKey(obj)] = obj
+			}
+		}
+	}
+}
+
+// report records an issue once
+func (e *goExtraction) report(pos token.Pos, format string, args ...interface{}) {
+	position := e.program.fset.Position(pos)
+	issue := RenameIssue{
+		Kind:     IssueExtract,
+		Position: fmt.Sprintf("%s:%d:%d", e.program.relPath(position.Filename), position.Line, position.Column),
+		Message:  fmt.Sprintf(format, args...),
+		Blocking: true,
+	}
+
+	id := issue.Position + "|" + issue.Message
+	if !e.seen[id] {
+		e.seen[id] = true
+		e.issues = append(e.issues, issue)
+	}
+}
+
+// check reports what would break the build once the files are extracted:
+// declarations of the source package the extracted files use, unexported
+// declarations of the extracted files used elsewhere, methods separated from
+// their type, and import cycles
+func (e *goExtraction) check() {
+	srcImportsNew := false
+	for _, pkg := range e.program.packages {
+		for ident, obj := range pkg.TypesInfo.Uses {
+			if obj == nil || obj.Pkg() == nil {
+				continue
+			}
+			filename := e.program.fset.Position(ident.Pos()).Filename
+			_, moved := e.objects[e.program.objectKey(obj)]
+			declared := e.program.fset.Position(obj.Pos()).Filename
+
+			switch {
+			case e.files[filename] && !moved && obj.Pkg() == pkg.Types && obj.Parent() == obj.Pkg().Scope():
+				e.report(ident.Pos(), "%s is declared in %s, which is not extracted", obj.Name(), e.program.relPath(declared))
+			case !e.files[filename] && moved && !obj.Exported():
+				e.report(ident.Pos(), "%s is unexported and used outside the extracted files, export it first", obj.Name())
+			case !e.files[filename] && moved && obj.Pkg() == pkg.Types && !strings.HasSuffix(pkg.Name, "_test"):
+				srcImportsNew = true
+			}
+		}
+
+		// Methods must be declared with their type
+		for ident, obj := range pkg.TypesInfo.Defs {
+			fn, ok := obj.(*types.Func)
+			if !ok || fn.Type().(*types.Signature).Recv() == nil {
+				continue
+			}
+			named := namedType(fn.Type().(*types.Signature).Recv().Type())
+			if named == nil {
+				continue
+			}
+			_, typeMoved := e.objects[e.program.objectKey(named.Obj())]
+			if e.files[e.program.fset.Position(ident.Pos()).Filename] != typeMoved {
+				e.report(ident.Pos(), "method %s must be extracted along with its type %s", fn.Name(), named.Obj().Name())
+			}
+		}
+	}
+
+	// The source package importing the new one must not be imported by it
+	if !srcImportsNew {
+		return
+	}
+	for _, pkg := range e.program.packages {
+		for _, file := range pkg.Syntax {
+			if !e.files[e.filename(file)] {
+				continue
+			}
+			for _, spec := range file.Imports {
+				path, _ := strconv.Unquote(spec.Path.Value)
+				if imported := pkg.Imports[path]; imported != nil && importsPackage(imported, e.srcImport) {
+					e.report(spec.Pos(), "%s imports %s, which would then import %s: extracting would create an import cycle", path, e.srcImport, e.newImport)
+				}
+			}
+		}
+	}
+}
+
+// importsPackage reports whether a package is or transitively imports a package path
+func importsPackage(pkg *packages.Package, path string) bool {
+	found := false
+	packages.Visit([]*packages.Package{pkg}, func(dep *packages.Package) bool {
+		if dep.PkgPath == path {
+			found = true
+		}
+		return !found
+	}, nil)
+	return found
+}
+
+// rewrite moves the extracted files and requalifies the references to their
+// declarations in every other file
+func (e *goExtraction) rewrite(set *changeSet) error {
+	done := map[string]bool{}
+	for _, pkg := range e.program.packages {
+		for _, file := range pkg.Syntax {
+			filename := e.filename(file)
+			if done[filename] || !e.program.inRepo(filename) {
+				continue
+			}
+			done[filename] = true
+			if err := e.rewriteFile(pkg, file, set); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// rewriteFile requalifies the references to extracted declarations in a file,
+// fixing its imports, and moves the file if it is extracted
+func (e *goExtraction) rewriteFile(pkg *packages.Package, file *ast.File, set *changeSet) error {
+	filename := e.filename(file)
+	rel := e.program.relPath(filename)
+	offset := func(pos token.Pos) int {
+		return e.program.fset.Position(pos).Offset
+	}
+
+	edits := []textEdit{}
+	if e.files[filename] {
+		set.move(rel, filepath.Join(e.newDir, filepath.Base(rel)))
+		name := e.newName
+		if strings.HasSuffix(file.Name.Name, "_test") {
+			name += "_test"
+		}
+		edits = append(edits, textEdit{offset(file.Name.Pos()), offset(file.Name.End()), name})
+	}
+
+	// Find the package a selector's qualifier names
+	qualifiers := map[*ast.Ident]*ast.Ident{}
+	ast.Inspect(file, func(node ast.Node) bool {
+		if sel, ok := node.(*ast.SelectorExpr); ok {
+			if x, ok := sel.X.(*ast.Ident); ok {
+				qualifiers[sel.Sel] = x
+			}
+		}
+		return true
+	})
+
+	alias := e.newName
+	for i := 2; usesName(file, alias); i++ {
+		alias = fmt.Sprintf("%s%d", e.newName, i)
+	}
+
+	requalified := map[*types.PkgName]int{}
+	uses := map[*types.PkgName]int{}
+	for ident, obj := range pkg.TypesInfo.Uses {
+		if e.program.fset.Position(ident.Pos()).Filename != filename {
+			continue
+		}
+		if pkgName, ok := obj.(*types.PkgName); ok {
+			uses[pkgName]++
+			continue
+		}
+		if _, moved := e.objects[e.program.objectKey(obj)]; !moved || obj.Parent() != obj.Pkg().Scope() {
+			continue
+		}
+
+		if x, ok := qualifiers[ident]; ok {
+			pkgName, ok := pkg.TypesInfo.Uses[x].(*types.PkgName)
+			if !ok {
+				continue
+			}
+			requalified[pkgName]++
+			edits = append(edits, textEdit{offset(x.Pos()), offset(x.End()), alias})
+		} else if e.files[filename] && e.inSourcePackage(file) {
+			continue
+		} else {
+			edits = append(edits, textEdit{offset(ident.Pos()), offset(ident.Pos()), alias + "."})
+		}
+		e.importers[filename] = true
+	}
+
+	if e.importers[filename] {
+		spec := strconv.Quote(e.newImport)
+		if alias != e.newName {
+			spec = alias + " " + spec
+		}
+
+		// Replace the import of a package that is no longer used, or add one
+		var unused *ast.ImportSpec
+		for pkgName, count := range requalified {
+			if count == uses[pkgName] {
+				unused = importSpec(file, pkg.TypesInfo, pkgName)
+			}
+		}
+		if unused != nil {
+			edits = append(edits, textEdit{offset(unused.Pos()), offset(unused.End()), spec})
+		} else {
+			edits = append(edits, addImport(file, spec, offset)...)
+		}
+	}
+
+	if len(edits) == 0 {
+		return nil
+	}
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	set.edit(rel, applyEdits(content, edits))
+	return nil
+}
+
+// importSpec returns the import of a file that declares a package name
+func importSpec(file *ast.File, info *types.Info, pkgName *types.PkgName) *ast.ImportSpec {
+	for _, spec := range file.Imports {
+		if obj := info.PkgNameOf(spec); obj == pkgName {
+			return spec
+		}
+	}
+	return nil
+}
+
+// addImport returns the edits that add an import to a file: at the end of
+// its last import declaration, made a block if needed, or after its package clause
+func addImport(file *ast.File, spec string, offset func(token.Pos) int) []textEdit {
+	var last *ast.GenDecl
+	for _, decl := range file.Decls {
+		if gen, ok := decl.(*ast.GenDecl); ok && gen.Tok == token.IMPORT {
+			last = gen
+		}
+	}
+
+	switch {
+	case last == nil:
+		return []textEdit{{offset(file.Name.End()), offset(file.Name.End()), "\n\nimport " + spec}}
+	case last.Lparen.IsValid():
+		return []textEdit{{offset(last.Rparen), offset(last.Rparen), "\t" + spec + "\n"}}
+	}
+	return []textEdit{
+		{offset(last.Specs[0].Pos()), offset(last.Specs[0].Pos()), "(\n\t"},
+		{offset(last.End()), offset(last.End()), "\n\t" + spec + "\n)"},
+	}
+}
+
+// createModule writes the go.mod and mono.yaml manifest of the new module. It
+// requires the modules the extracted files import, with local modules of the
+// repository replaced by their directory and other modules at the version
+// the source module uses.
+func (e *goExtraction) createModule(srcModDir string, set *changeSet) error {
+	srcGoMod := filepath.Join(srcModDir, "go.mod")
+	content, err := set.current(srcGoMod)
+	if err != nil {
+		return err
+	}
+	srcFile, err := modfile.Parse(srcGoMod, content, nil)
+	if err != nil {
+		return err
+	}
+
+	file := &modfile.File{}
+	if err := file.AddModuleStmt(e.newImport); err != nil {
+		return err
+	}
+	if srcFile.Go != nil {
+		if err := file.AddGoStmt(srcFile.Go.Version); err != nil {
+			return err
+		}
+	}
+
+	external := false
+	required := map[string]bool{}
+	for _, pkg := range e.program.packages {
+		for _, syntax := range pkg.Syntax {
+			if !e.files[e.filename(syntax)] {
+				continue
+			}
+			for _, spec := range syntax.Imports {
+				path, _ := strconv.Unquote(spec.Path.Value)
+				imported := pkg.Imports[path]
+				if imported == nil || imported.Module == nil || imported.Module.Path == e.newImport || required[imported.Module.Path] {
+					continue
+				}
+				mod := imported.Module
+				required[mod.Path] = true
+
+				dir := mod.Dir
+				if mod.Replace != nil {
+					dir = mod.Replace.Dir
+				}
+				if mod.Version == "" && e.program.inRepo(dir) {
+					if err := file.AddRequire(mod.Path, "v0.0.0"); err != nil {
+						return err
+					}
+					if err := file.AddReplace(mod.Path, "", e.relativeDir(dir, e.newDir), ""); err != nil {
+						return err
+					}
+					continue
+				}
+
+				external = true
+				if err := file.AddRequire(mod.Path, mod.Version); err != nil {
+					return err
+				}
+				if mod.Replace != nil {
+					if err := file.AddReplace(mod.Path, "", mod.Replace.Path, mod.Replace.Version); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+
+	file.Cleanup()
+	formatted, err := file.Format()
+	if err != nil {
+		return err
+	}
+	set.create(filepath.Join(e.newDir, "go.mod"), formatted)
+
+	// The checksums of the source module cover the requirements it shares
+	if external {
+		if sums, err := os.ReadFile(filepath.Join(srcModDir, "go.sum")); err == nil {
+			set.create(filepath.Join(e.newDir, "go.sum"), sums)
+		}
+		logger.Warn("New module has external requirements, run go mod tidy in it to complete them", "dir", e.newDir)
+	}
+
+	set.create(filepath.Join(e.newDir, config.ModuleManifestName), []byte("language: go\n"))
+	return nil
+}
+
+// requireModule makes the modules of the files that now import the new
+// package require the new module from its directory, and adds the new module
+// to the go.work of the repository if it has one
+func (e *goExtraction) requireModule(set *changeSet) error {
+	modDirs := []string{}
+	for filename := range e.importers {
+		if e.files[filename] {
+			continue
+		}
+		modDir, modPath, ok := goModuleOf(filepath.Dir(e.program.relPath(filename)))
+		if ok && modPath != e.newImport && !slices.Contains(modDirs, modDir) {
+			modDirs = append(modDirs, modDir)
+		}
+	}
+	sort.Strings(modDirs)
+
+	for _, modDir := range modDirs {
+		gomod := filepath.Join(modDir, "go.mod")
+		content, err := set.current(gomod)
+		if err != nil {
+			return err
+		}
+		file, err := modfile.Parse(gomod, content, nil)
+		if err != nil {
+			return err
+		}
+		if err := file.AddRequire(e.newImport, "v0.0.0"); err != nil {
+			return err
+		}
+		if err := file.AddReplace(e.newImport, "", e.relativeDir(e.newDir, modDir), ""); err != nil {
+			return err
+		}
+		file.Cleanup()
+		formatted, err := file.Format()
+		if err != nil {
+			return err
+		}
+		set.edit(gomod, formatted)
+	}
+
+	if !isRegularFile("go.work") {
+		return nil
+	}
+	content, err := set.current("go.work")
+	if err != nil {
+		return err
+	}
+	work, err := modfile.ParseWork("go.work", content, nil)
+	if err != nil {
+		logger.Warn("Failed to parse go.work, not adding the new module to it", "error", err)
+		return nil
+	}
+	if err := work.AddUse("./"+filepath.ToSlash(e.newDir), ""); err != nil {
+		return err
+	}
+	work.Cleanup()
+	set.edit("go.work", modfile.Format(work.Syntax))
+	return nil
+}
+
+// relativeDir returns a directory relative to another as a replace directive
+// or go.work use writes it
+func (e *goExtraction) relativeDir(dir, from string) string {
+	if filepath.IsAbs(dir) {
+		dir = e.program.relPath(dir)
+	}
+	rel, err := filepath.Rel(from, dir)
+	if err != nil {
+		return filepath.ToSlash(dir)
+	}
+	rel = filepath.ToSlash(rel)
+	if rel != ".." && !strings.HasPrefix(rel, "../") {
+		rel = "./" + rel
+	}
+	return rel
+}
@@ -0,0 +1,412 @@
+package refactor
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"sort"
+	"strings"
+	"golang.org/x/tools/go/packages"
+	"mono-mind/internal/analyzer"
+)
+
+// Kinds of problems found by the checks run before a rename
+const (
+	IssueConflict  = "conflict"
+	IssueShadowing = "shadowing"
+	IssueExport    = "exportedness"
+	IssueInterface = "interface"
+	IssueModules   = "external-modules"
+	IssuePackages  = "skipped-packages"
+)
+
+// RenameIssue is a problem found by the checks run before a rename. Blocking
+// issues make the rename refuse to apply unless it is forced.
+type RenameIssue struct {
+	Kind     string `json:"kind"`
+	Position string `json:"position,omitempty"`
+	Message  string `json:"message"`
+	Blocking bool   `json:"blocking"`
+}
+
+// renameChecker checks whether renaming an object keeps the program valid and
+// its meaning unchanged
+type renameChecker struct {
+	program *goProgram
+	obj     types.Object
+	key     string
+	newName string
+	refs    map[string]map[int]*ast.Ident
+	issues  []RenameIssue
+	seen    map[string]bool
+}
+
+// checkRename reports the conflicts, shadowing, lost exportedness and broken
+// interface implementations a rename would cause, and the modules besides the
+// declaring one whose code it changes
+func checkRename(program *goProgram, graph *analyzer.RepoGraph, obj types.Object, newName string, refs map[string]map[int]*ast.Ident) []RenameIssue {
+	c := &renameChecker{
+		program: program,
+		obj:     obj,
+		key:     program.objectKey(obj),
+		newName: newName,
+		refs:    refs,
+		issues:  []RenameIssue{},
+		seen:    map[string]bool{},
+	}
+
+	c.checkConflicts()
+	if !isMember(obj) {
+		c.checkShadowing()
+		c.checkCaptures()
+	}
+	c.checkExport()
+	c.checkInterfaces()
+	if graph != nil {
+		c.checkModules(graph)
+	}
+
+	// Keep the issues of each check together, in source order
+	rank := map[string]int{IssueConflict: 0, IssueShadowing: 1, IssueExport: 2, IssueInterface: 3, IssueModules: 4}
+	sort.SliceStable(c.issues, func(i, j int) bool {
+		a, b := c.issues[i], c.issues[j]
+		if a.Kind != b.Kind {
+			return rank[a.Kind] < rank[b.Kind]
+		}
+		return a.Position < b.Position
+	})
+	return c.issues
+}
+
+// report records an issue once
+func (c *renameChecker) report(kind string, pos token.Pos, blocking bool, format string, args ...interface{}) {
+	issue := RenameIssue{Kind: kind, Message: fmt.Sprintf(format, args...), Blocking: blocking}
+	if pos.IsValid() {
+		position := c.program.fset.Position(pos)
+		issue.Position = fmt.Sprintf("%s:%d:%d", c.program.relPath(position.Filename), position.Line, position.Column)
+	}
+
+	id := issue.Kind + "|" + issue.Position + "|" + issue.Message
+	if !c.seen[id] {
+		c.seen[id] = true
+		c.issues = append(c.issues, issue)
+	}
+}
+
+// checkConflicts reports declarations that already use the new name where the
+// object is declared
+func (c *renameChecker) checkConflicts() {
+	switch {
+	case isMember(c.obj):
+		owner := memberOwner(c.obj)
+		if owner == nil {
+			return
+		}
+		if other, _, _ := types.LookupFieldOrMethod(owner, true, c.obj.Pkg(), c.newName); other != nil {
+			c.report(IssueConflict, other.Pos(), true, "%s already has a field or method %s", types.TypeString(owner, nil), c.newName)
+		}
+	case c.obj.Parent() == c.obj.Pkg().Scope():
+		// Each variant of the package declares the names of its own files,
+		// such as the test files. Imports live in the file scopes, which are
+		// inside the package scope.
+		for _, pkg := range c.packagesOf(c.obj.Pkg().Path()) {
+			if other := pkg.Types.Scope().Lookup(c.newName); other != nil {
+				c.report(IssueConflict, other.Pos(), true, "package %s already declares %s", c.obj.Pkg().Path(), c.newName)
+			}
+			for _, file := range pkg.Syntax {
+				if other := pkg.TypesInfo.Scopes[file].Lookup(c.newName); other != nil {
+					c.report(IssueConflict, other.Pos(), true, "%s conflicts with an import in %s", c.newName, c.program.relPath(c.program.fset.Position(file.Pos()).Filename))
+				}
+			}
+		}
+	case c.obj.Parent() != nil:
+		if other := c.obj.Parent().Lookup(c.newName); other != nil {
+			c.report(IssueConflict, other.Pos(), true, "%s is already declared in the same scope", c.newName)
+		}
+	}
+}
+
+// checkShadowing reports references to the object that a declaration of the
+// new name in an inner scope would capture after the rename
+func (c *renameChecker) checkShadowing() {
+	for _, pkg := range c.program.packages {
+		selectors := selectorIdents(pkg)
+		declared := c.declaringScope(pkg)
+		for ident, obj := range pkg.TypesInfo.Uses {
+			if selectors[ident] || c.program.objectKey(obj) != c.key {
+				continue
+			}
+			for scope := innermostScope(pkg, ident.Pos()); scope != nil && scope != declared; scope = scope.Parent() {
+				other := scope.Lookup(c.newName)
+				if other == nil || (other.Pos() > ident.Pos() && !isFileScope(pkg, scope)) {
+					continue
+				}
+				c.report(IssueShadowing, ident.Pos(), true, "this reference to %s would refer to %s declared at %s",
+					c.obj.Name(), types.ObjectString(other, types.RelativeTo(other.Pkg())), c.position(other.Pos()))
+				break
+			}
+		}
+	}
+}
+
+// checkCaptures reports references to other objects named like the new name
+// that the renamed object would shadow
+func (c *renameChecker) checkCaptures() {
+	for _, pkg := range c.packagesOf(c.obj.Pkg().Path()) {
+		selectors := selectorIdents(pkg)
+		declared := c.declaringScope(pkg)
+		for ident, obj := range pkg.TypesInfo.Uses {
+			if selectors[ident] || obj.Name() != c.newName {
+				continue
+			}
+			// Local objects are only in scope after their declaration
+			if c.obj.Parent() != c.obj.Pkg().Scope() && ident.Pos() < c.obj.Pos() {
+				continue
+			}
+
+			// The reference is captured when the object's scope is reached
+			// before the scope declaring what it refers to now
+			for scope := innermostScope(pkg, ident.Pos()); scope != nil && scope != obj.Parent(); scope = scope.Parent() {
+				if scope == declared {
+					c.report(IssueShadowing, ident.Pos(), true, "renamed %s would shadow %s used here",
+						c.obj.Name(), types.ObjectString(obj, types.RelativeTo(obj.Pkg())))
+					break
+				}
+			}
+		}
+	}
+}
+
+// checkExport reports references from other packages to an exported object
+// that the new, unexported name would break
+func (c *renameChecker) checkExport() {
+	oldExported, newExported := token.IsExported(c.obj.Name()), token.IsExported(c.newName)
+	if oldExported == newExported {
+		return
+	}
+	if newExported {
+		c.report(IssueExport, c.obj.Pos(), false, "%s becomes exported", c.obj.Name())
+		return
+	}
+
+	users := map[string]bool{}
+	for _, pkg := range c.program.packages {
+		if pkg.PkgPath == c.obj.Pkg().Path() {
+			continue
+		}
+		for ident, obj := range pkg.TypesInfo.Uses {
+			if c.program.objectKey(obj) == c.key {
+				users[pkg.PkgPath] = true
+				c.report(IssueExport, ident.Pos(), true, "%s would no longer be exported to package %s", c.obj.Name(), pkg.PkgPath)
+			}
+		}
+	}
+	if len(users) == 0 {
+		c.report(IssueExport, c.obj.Pos(), false, "%s is no longer exported", c.obj.Name())
+	}
+}
+
+// checkInterfaces reports interface implementations a method rename would
+// break: implementations of the interface whose method is renamed, and
+// interfaces the renamed method's type implements
+func (c *renameChecker) checkInterfaces() {
+	fn, ok := c.obj.(*types.Func)
+	if !ok {
+		return
+	}
+	recv := fn.Type().(*types.Signature).Recv()
+	if recv == nil {
+		return
+	}
+	named := namedType(recv.Type())
+	if named == nil {
+		return
+	}
+
+	if iface, isInterface := named.Underlying().(*types.Interface); isInterface {
+		for _, typeName := range c.typeNames() {
+			if _, other := typeName.Type().Underlying().(*types.Interface); other {
+				continue
+			}
+			if implements(typeName.Type(), iface) {
+				c.report(IssueInterface, typeName.Pos(), true, "%s implements %s; its method %s would not be renamed",
+					typeName.Name(), named.Obj().Name(), c.obj.Name())
+			}
+		}
+		return
+	}
+
+	for _, typeName := range c.typeNames() {
+		iface, isInterface := typeName.Type().Underlying().(*types.Interface)
+		if !isInterface || typeName == named.Obj() {
+			continue
+		}
+		if method, _, _ := types.LookupFieldOrMethod(iface, false, nil, c.obj.Name()); method == nil {
+			continue
+		}
+		if implements(named, iface) {
+			c.report(IssueInterface, c.obj.Pos(), true, "%s implements %s; renaming %s breaks the implementation",
+				named.Obj().Name(), typeName.Name(), c.obj.Name())
+		}
+	}
+}
+
+// checkModules reports the repository modules other than the declaring one
+// whose code the rename changes
+func (c *renameChecker) checkModules(graph *analyzer.RepoGraph) {
+	declaring, _ := graph.ModuleForFile(c.program.relPath(c.program.fset.Position(c.obj.Pos()).Filename))
+
+	modules := map[string]bool{}
+	for filename := range c.refs {
+		if module, ok := graph.ModuleForFile(c.program.relPath(filename)); ok && module != declaring {
+			modules[module] = true
+		}
+	}
+	if len(modules) == 0 {
+		return
+	}
+
+	names := make([]string, 0, len(modules))
+	for module := range modules {
+		names = append(names, module)
+	}
+	sort.Strings(names)
+	c.report(IssueModules, c.obj.Pos(), false, "the rename also changes the code of modules %s", strings.Join(names, ", "))
+}
+
+// packagesOf returns the loaded variants of a package
+func (c *renameChecker) packagesOf(path string) []*packages.Package {
+	pkgs := []*packages.Package{}
+	for _, pkg := range c.program.packages {
+		if pkg.PkgPath == path {
+			pkgs = append(pkgs, pkg)
+		}
+	}
+	return pkgs
+}
+
+// declaringScope returns the scope declaring the object in a package. Each
+// variant of a package, such as the one compiled with its tests, has its own
+// scopes and objects. In packages importing the object, the package scope is
+// returned, which lies outside the scopes of their files.
+func (c *renameChecker) declaringScope(pkg *packages.Package) *types.Scope {
+	for _, obj := range pkg.TypesInfo.Defs {
+		if obj != nil && obj.Name() == c.obj.Name() && c.program.objectKey(obj) == c.key {
+			return obj.Parent()
+		}
+	}
+	return pkg.Types.Scope()
+}
+
+// typeNames returns the package-level types declared in the repository
+func (c *renameChecker) typeNames() []*types.TypeName {
+	typeNames := []*types.TypeName{}
+	for _, pkg := range c.program.packages {
+		scope := pkg.Types.Scope()
+		for _, name := range scope.Names() {
+			if typeName, ok := scope.Lookup(name).(*types.TypeName); ok {
+				typeNames = append(typeNames, typeName)
+			}
+		}
+	}
+	return typeNames
+}
+
+// position formats a position relative to the repository root
+func (c *renameChecker) position(pos token.Pos) string {
+	if !pos.IsValid() {
+		return "universe scope"
+	}
+	position := c.program.fset.Position(pos)
+	return fmt.Sprintf("%s:%d:%d", c.program.relPath(position.Filename), position.Line, position.Column)
+}
+
+// isMember reports whether an object is a struct field or a method
+func isMember(obj types.Object) bool {
+	switch obj := obj.(type) {
+	case *types.Var:
+		return obj.IsField()
+	case *types.Func:
+		return obj.Type().(*types.Signature).Recv() != nil
+	}
+	return false
+}
+
+// memberOwner returns the type declaring a field or method, or nil for fields
+// of anonymous structs
+func memberOwner(obj types.Object) types.Type {
+	if fn, ok := obj.(*types.Func); ok {
+		return fn.Type().(*types.Signature).Recv().Type()
+	}
+
+	scope := obj.Pkg().Scope()
+	for _, name := range scope.Names() {
+		typeName, ok := scope.Lookup(name).(*types.TypeName)
+		if !ok {
+			continue
+		}
+		if st, ok := typeName.Type().Underlying().(*types.Struct); ok {
+			for i := 0; i < st.NumFields(); i++ {
+				if st.Field(i) == obj {
+					return typeName.Type()
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// namedType returns the named type of a receiver, dereferencing pointers
+func namedType(t types.Type) *types.Named {
+	if ptr, ok := t.(*types.Pointer); ok {
+		t = ptr.Elem()
+	}
+	named, _ := t.(*types.Named)
+	return named
+}
+
+// implements reports whether a type or a pointer to it implements an interface
+func implements(t types.Type, iface *types.Interface) bool {
+	if iface.NumMethods() == 0 {
+		return false
+	}
+	return types.Implements(t, iface) || types.Implements(types.NewPointer(t), iface)
+}
+
+// selectorIdents returns the identifiers selected in selector expressions,
+// which are not resolved through the lexical scopes
+func selectorIdents(pkg *packages.Package) map[*ast.Ident]bool {
+	selectors := map[*ast.Ident]bool{}
+	for _, file := range pkg.Syntax {
+		ast.Inspect(file, func(node ast.Node) bool {
+			if sel, ok := node.(*ast.SelectorExpr); ok {
+				selectors[sel.Sel] = true
+			}
+			return true
+		})
+	}
+	return selectors
+}
+
+// innermostScope returns the innermost scope containing a position of a package's files
+func innermostScope(pkg *packages.Package, pos token.Pos) *types.Scope {
+	for _, file := range pkg.Syntax {
+		if file.FileStart <= pos && pos <= file.FileEnd {
+			if scope := pkg.TypesInfo.Scopes[file]; scope != nil {
+				return scope.Innermost(pos)
+			}
+		}
+	}
+	return nil
+}
+
+// isFileScope reports whether a scope is the scope of one of a package's files
+func isFileScope(pkg *packages.Package, scope *types.Scope) bool {
+	for _, file := range pkg.Syntax {
+		if pkg.TypesInfo.Scopes[file] == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,180 @@
+package refactor
+
+import (
+	"go/types"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeModule writes the files of a Go module into a temporary directory
+func writeModule(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// variantObjects returns the object declared at a position in every loaded
+// variant of its package
+func variantObjects(t *testing.T, program *goProgram, file string, line, col int) []types.Object {
+	t.Helper()
+	objects := []types.Object{}
+	for _, pkg := range program.packages {
+		for ident, obj := range pkg.TypesInfo.Defs {
+			pos := program.fset.Position(ident.Pos())
+			if obj != nil && pos.Filename == file && pos.Line == line && pos.Column == col {
+				objects = append(objects, obj)
+			}
+		}
+	}
+	if len(objects) < 2 {
+		t.Fatalf("found %d variants of the object at %s:%d:%d, want the package and its test variant", len(objects), file, line, col)
+	}
+	return objects
+}
+
+func TestCheckRenameInPackageWithTests(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"go.mod": "module example.com/m\n\ngo 1.22\n",
+		"lib/lib.go": `package lib
+
+var y = 1
+
+func Helper() int {
+	x := 2
+	return x
+}
+`,
+		"lib/lib_test.go": `package lib
+
+import "testing"
+
+func TestHelper(t *testing.T) {
+	if Helper() != 2 {
+		t.Fatal("unexpected result")
+	}
+}
+`,
+	})
+	program, err := loadGoProgram(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	libFile := filepath.Join(program.root, "lib", "lib.go")
+
+	tests := []struct {
+		name    string
+		line    int
+		col     int
+		newName string
+	}{
+		{"local beside package-level name", 6, 2, "y"},
+		{"package-level name of a builtin", 5, 6, "max"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The checks must not depend on the variant the object is taken from
+			for _, obj := range variantObjects(t, program, libFile, tt.line, tt.col) {
+				for _, issue := range checkRename(program, nil, obj, tt.newName, nil) {
+					if issue.Blocking {
+						t.Errorf("renaming %s to %s in %s: unexpected %s issue at %s: %s",
+							obj.Name(), tt.newName, obj.Pkg().Path(), issue.Kind, issue.Position, issue.Message)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestCheckRenameReportsShadowingInTests(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"go.mod": "module example.com/m\n\ngo 1.22\n",
+		"lib/lib.go": `package lib
+
+func Helper() int {
+	return 2
+}
+`,
+		"lib/lib_test.go": `package lib
+
+import "testing"
+
+func TestHelper(t *testing.T) {
+	want := 2
+	if Helper() != want {
+		t.Fatal("unexpected result")
+	}
+}
+`,
+	})
+	program, err := loadGoProgram(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	libFile := filepath.Join(program.root, "lib", "lib.go")
+
+	for _, obj := range variantObjects(t, program, libFile, 3, 6) {
+		found := false
+		for _, issue := range checkRename(program, nil, obj, "want", nil) {
+			if issue.Kind == IssueShadowing && issue.Position == "lib/lib_test.go:7:5" {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("renaming Helper to want in %s: the reference captured by the local want in the test was not reported", obj.Pkg().Path())
+		}
+	}
+}
+
+func TestCheckRenameReportsConflictsInTests(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"go.mod": "module example.com/m\n\ngo 1.22\n",
+		"lib/lib.go": `package lib
+
+func Helper() int {
+	return 2
+}
+`,
+		"lib/lib_test.go": `package lib
+
+import "testing"
+
+func expected() int {
+	return 2
+}
+
+func TestHelper(t *testing.T) {
+	if Helper() != expected() {
+		t.Fatal("unexpected result")
+	}
+}
+`,
+	})
+	program, err := loadGoProgram(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	libFile := filepath.Join(program.root, "lib", "lib.go")
+
+	// The package variant without its tests must report the conflict too
+	for _, obj := range variantObjects(t, program, libFile, 3, 6) {
+		found := false
+		for _, issue := range checkRename(program, nil, obj, "expected", nil) {
+			if issue.Kind == IssueConflict && issue.Blocking && issue.Position == "lib/lib_test.go:5:6" {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("renaming Helper to expected in %s: the conflict with the declaration in the test file was not reported", obj.Pkg().Path())
+		}
+	}
+}
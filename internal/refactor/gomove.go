@@ -0,0 +1,416 @@
+package refactor
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"golang.org/x/mod/modfile"
+	"mono-mind/internal/analyzer"
+	"mono-mind/internal/logger"
+)
+
+// goPackageMove is a Go package directory moved by a refactoring
+type goPackageMove struct {
+	oldDir    string
+	newDir    string
+	oldImport string
+	newImport string
+	oldName   string
+	newName   string
+}
+
+// moveGo rewrites the Go code affected by moving a file or directory: the
+// import paths of the moved packages in every file that imports them, the
+// package clause of packages whose directory name changes, and the local
+// replace directives of go.mod files
+func moveGo(graph *analyzer.RepoGraph, oldPath, newPath string, set *changeSet) error {
+	moves, err := goPackageMoves(oldPath, newPath)
+	if err != nil {
+		return err
+	}
+
+	if len(moves) > 0 {
+		files, err := goReferrers(graph, moves)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			if err := rewriteGoFile(file, moves, set); err != nil {
+				return err
+			}
+		}
+	}
+
+	return fixReplaceDirectives(oldPath, newPath, set)
+}
+
+// goPackageMoves returns the Go packages under a moved directory whose import
+// path changes. Packages of a Go module moved as a whole keep their import path.
+func goPackageMoves(oldPath, newPath string) ([]goPackageMove, error) {
+	moves := []goPackageMove{}
+	err := filepath.Walk(oldPath, func(dir string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return err
+		}
+		if dir != oldPath && skipGoDir(info.Name()) {
+			return filepath.SkipDir
+		}
+
+		name, ok := goPackageName(dir)
+		if !ok {
+			return nil
+		}
+		modDir, modPath, ok := goModuleOf(dir)
+		if !ok || withinPath(modDir, oldPath) {
+			return nil
+		}
+
+		newDir := movedPath(dir, oldPath, newPath)
+		newModDir, newModPath, ok := goModuleOf(newDir)
+		if !ok {
+			logger.Warn("Moved Go package is outside of any Go module, not rewriting its imports", "dir", newDir)
+			return nil
+		}
+		if newModPath != modPath {
+			logger.Warn("Moved Go package to another Go module, check that module's requirements", "dir", newDir, "module", newModPath)
+		}
+
+		move := goPackageMove{
+			oldDir:    dir,
+			newDir:    newDir,
+			oldImport: importPath(modPath, modDir, dir),
+			newImport: importPath(newModPath, newModDir, newDir),
+			oldName:   name,
+			newName:   name,
+		}
+
+		// A package named after its directory follows the directory's new name
+		newBase := filepath.Base(newDir)
+		if name == filepath.Base(dir) && token.IsIdentifier(newBase) {
+			move.newName = newBase
+		}
+
+		if move.oldImport != move.newImport || move.oldName != move.newName {
+			moves = append(moves, move)
+		}
+		return nil
+	})
+	return moves, err
+}
+
+// goPackageName returns the package name of the Go files in a directory,
+// ignoring external test packages
+func goPackageName(dir string) (string, bool) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", false
+	}
+
+	fset := token.NewFileSet()
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".go" {
+			continue
+		}
+		file, err := parser.ParseFile(fset, filepath.Join(dir, entry.Name()), nil, parser.PackageClauseOnly)
+		if err != nil || strings.HasSuffix(file.Name.Name, "_test") {
+			continue
+		}
+		return file.Name.Name, true
+	}
+	return "", false
+}
+
+// goModuleOf returns the directory and module path of the Go module that
+// contains a directory, found from the nearest go.mod above it
+func goModuleOf(dir string) (string, string, bool) {
+	for current := filepath.Clean(dir); ; current = filepath.Dir(current) {
+		content, err := os.ReadFile(filepath.Join(current, "go.mod"))
+		if err == nil {
+			if modPath := modfile.ModulePath(content); modPath != "" {
+				return current, modPath, true
+			}
+		}
+		if filepath.Dir(current) == current {
+			return "", "", false
+		}
+	}
+}
+
+// importPath returns the import path of a package directory in a Go module
+func importPath(modPath, modDir, dir string) string {
+	rel, err := filepath.Rel(modDir, dir)
+	if err != nil || rel == "." {
+		return modPath
+	}
+	return path.Join(modPath, filepath.ToSlash(rel))
+}
+
+// goReferrers returns the Go files that import the moved packages or belong to
+// them. Every Go file of the Go modules containing the moved packages is
+// scanned, along with the modules the graph records as their dependents; the
+// graph skips directories such as build and dist, so it cannot be relied on
+// alone. If the graph cannot resolve a moved package, every Go file in the
+// repository is scanned.
+func goReferrers(graph *analyzer.RepoGraph, moves []goPackageMove) ([]string, error) {
+	roots := map[string]bool{}
+	for _, move := range moves {
+		if modDir, _, ok := goModuleOf(move.oldDir); ok {
+			roots[modDir] = true
+		}
+
+		name, ok := "", false
+		if graph != nil {
+			name, ok = graph.ResolveDependency(move.oldImport)
+		}
+		if !ok || filepath.Clean(graph.Modules[name].Path) != move.oldDir {
+			logger.Debug("Module graph does not resolve moved package, scanning all Go files", "package", move.oldImport)
+			roots = map[string]bool{".": true}
+			break
+		}
+		for _, dependent := range append(graph.GetInternalDependents(name), graph.GetTestDependents(name)...) {
+			roots[filepath.Clean(graph.Modules[dependent].Path)] = true
+		}
+	}
+
+	seen := map[string]bool{}
+	files := []string{}
+	for root := range roots {
+		rootFiles, err := goFiles(root)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range rootFiles {
+			if seen[file] {
+				continue
+			}
+			seen[file] = true
+			if referencesMove(file, moves) {
+				files = append(files, file)
+			}
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// referencesMove reports whether a Go file belongs to a moved package or
+// mentions the import path of one
+func referencesMove(filename string, moves []goPackageMove) bool {
+	content, err := os.ReadFile(filepath.Clean(filename))
+	if err != nil {
+		return false
+	}
+	for _, move := range moves {
+		if filepath.Dir(filename) == move.oldDir || bytes.Contains(content, []byte(strconv.Quote(move.oldImport))) {
+			return true
+		}
+	}
+	return false
+}
+
+// goFiles returns the Go files under a directory, skipping the directories
+// the go command ignores
+func goFiles(root string) ([]string, error) {
+	files := []string{}
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path != root && skipGoDir(info.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Ext(path) == ".go" {
+			files = append(files, filepath.Clean(path))
+		}
+		return nil
+	})
+	return files, err
+}
+
+// skipGoDir reports whether a directory holds no Go packages of the
+// repository: the go command ignores directories starting with . or _ and
+// testdata, and vendor and node_modules hold third-party code
+func skipGoDir(name string) bool {
+	switch name {
+	case "testdata", "vendor", "node_modules":
+		return true
+	}
+	return strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")
+}
+
+// rewriteGoFile rewrites the imports of moved packages in a Go file, and its
+// package clause if the file belongs to a package whose name changes. Files
+// that refer to a renamed package by its implicit name have those references
+// renamed, or the import aliased to the old name when the new one is taken.
+func rewriteGoFile(filename string, moves []goPackageMove, set *changeSet) error {
+	content, err := set.current(filename)
+	if err != nil {
+		return err
+	}
+
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, filename, content, parser.ParseComments)
+	if err != nil {
+		logger.Warn("Failed to parse Go file, not rewriting its imports", "file", filename, "error", err)
+		return nil
+	}
+	offset := func(pos token.Pos) int {
+		return fset.Position(pos).Offset
+	}
+
+	edits := []textEdit{}
+	for _, move := range moves {
+		if filepath.Dir(filename) == move.oldDir && move.oldName != move.newName {
+			switch file.Name.Name {
+			case move.oldName:
+				edits = append(edits, textEdit{offset(file.Name.Pos()), offset(file.Name.End()), move.newName})
+			case move.oldName + "_test":
+				edits = append(edits, textEdit{offset(file.Name.Pos()), offset(file.Name.End()), move.newName + "_test"})
+			}
+		}
+	}
+
+	for _, spec := range file.Imports {
+		imported, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			continue
+		}
+		for _, move := range moves {
+			if imported != move.oldImport {
+				continue
+			}
+			edits = append(edits, textEdit{offset(spec.Path.Pos()), offset(spec.Path.End()), strconv.Quote(move.newImport)})
+
+			if spec.Name != nil || move.oldName == move.newName {
+				continue
+			}
+			if usesName(file, move.newName) {
+				edits = append(edits, textEdit{offset(spec.Path.Pos()), offset(spec.Path.Pos()), move.oldName + " "})
+				continue
+			}
+			for _, ident := range packageRefs(file, move.oldName) {
+				edits = append(edits, textEdit{offset(ident.Pos()), offset(ident.End()), move.newName})
+			}
+		}
+	}
+
+	if len(edits) > 0 {
+		set.edit(filename, applyEdits(content, edits))
+	}
+	return nil
+}
+
+// usesName reports whether any identifier of a file has the given name
+func usesName(file *ast.File, name string) bool {
+	used := false
+	ast.Inspect(file, func(node ast.Node) bool {
+		if ident, ok := node.(*ast.Ident); ok && ident.Name == name && ident != file.Name {
+			used = true
+		}
+		return !used
+	})
+	return used
+}
+
+// packageRefs returns the identifiers of a file that refer to an imported
+// package by its name: qualifiers of selectors the parser did not resolve to
+// a local declaration
+func packageRefs(file *ast.File, name string) []*ast.Ident {
+	refs := []*ast.Ident{}
+	ast.Inspect(file, func(node ast.Node) bool {
+		if selector, ok := node.(*ast.SelectorExpr); ok {
+			if ident, ok := selector.X.(*ast.Ident); ok && ident.Name == name && ident.Obj == nil {
+				refs = append(refs, ident)
+			}
+		}
+		return true
+	})
+	return refs
+}
+
+// fixReplaceDirectives updates the local replace directives of every go.mod
+// in the repository whose target, or whose own directory, moves
+func fixReplaceDirectives(oldPath, newPath string, set *changeSet) error {
+	dirs, err := goModuleDirs(".")
+	if err != nil {
+		return err
+	}
+
+	for _, dir := range dirs {
+		gomod := filepath.Join(dir, "go.mod")
+		content, err := set.current(gomod)
+		if err != nil {
+			return err
+		}
+		file, err := modfile.Parse(gomod, content, nil)
+		if err != nil {
+			logger.Warn("Failed to parse go.mod, not fixing its replace directives", "file", gomod, "error", err)
+			continue
+		}
+
+		newDir := movedPath(dir, oldPath, newPath)
+		changed := false
+		for _, replace := range append([]*modfile.Replace{}, file.Replace...) {
+			if !modfile.IsDirectoryPath(replace.New.Path) || filepath.IsAbs(replace.New.Path) {
+				continue
+			}
+			target := movedPath(filepath.Join(dir, filepath.FromSlash(replace.New.Path)), oldPath, newPath)
+			rel, err := filepath.Rel(newDir, target)
+			if err != nil {
+				continue
+			}
+			rel = filepath.ToSlash(rel)
+			if rel != ".." && !strings.HasPrefix(rel, "../") {
+				rel = "./" + rel
+			}
+			if path.Clean(rel) == path.Clean(replace.New.Path) {
+				continue
+			}
+
+			if err := file.AddReplace(replace.Old.Path, replace.Old.Version, rel, ""); err != nil {
+				return err
+			}
+			changed = true
+		}
+		if !changed {
+			continue
+		}
+
+		file.Cleanup()
+		formatted, err := file.Format()
+		if err != nil {
+			return err
+		}
+		set.edit(gomod, formatted)
+	}
+	return nil
+}
+
+// movedPath returns where a path ends up when oldPath is moved to newPath
+func movedPath(path, oldPath, newPath string) string {
+	path = filepath.Clean(path)
+	if !withinPath(path, oldPath) {
+		return path
+	}
+	rel, err := filepath.Rel(oldPath, path)
+	if err != nil {
+		return path
+	}
+	return filepath.Join(newPath, rel)
+}
+
+// withinPath reports whether a path is a directory or one of its descendants
+func withinPath(path, dir string) bool {
+	path, dir = filepath.Clean(path), filepath.Clean(dir)
+	return path == dir || dir == "." || strings.HasPrefix(path, dir+string(filepath.Separator))
+}
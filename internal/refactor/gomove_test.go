@@ -0,0 +1,191 @@
+package refactor
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestGoPackageMoves(t *testing.T) {
+	tests := []struct {
+		name    string
+		oldPath string
+		newPath string
+		want    []goPackageMove
+	}{
+		{
+			name:    "package renamed with its directory",
+			oldPath: "lib",
+			newPath: "util",
+			want:    []goPackageMove{{"lib", "util", "example.com/m/lib", "example.com/m/util", "lib", "util"}},
+		},
+		{
+			name:    "package moved into a subdirectory",
+			oldPath: "lib",
+			newPath: filepath.Join("pkg", "lib"),
+			want:    []goPackageMove{{"lib", filepath.Join("pkg", "lib"), "example.com/m/lib", "example.com/m/pkg/lib", "lib", "lib"}},
+		},
+		{
+			name:    "package not named after its directory",
+			oldPath: "cmd",
+			newPath: "tool",
+			want:    []goPackageMove{{"cmd", "tool", "example.com/m/cmd", "example.com/m/tool", "main", "main"}},
+		},
+		{
+			name:    "nested packages, including one in a build directory",
+			oldPath: "internal",
+			newPath: "private",
+			want: []goPackageMove{
+				{filepath.Join("internal", "build"), filepath.Join("private", "build"), "example.com/m/internal/build", "example.com/m/private/build", "build", "build"},
+				{filepath.Join("internal", "tasks"), filepath.Join("private", "tasks"), "example.com/m/internal/tasks", "example.com/m/private/tasks", "tasks", "tasks"},
+			},
+		},
+		{
+			name:    "module moved as a whole",
+			oldPath: "other",
+			newPath: "another",
+			want:    []goPackageMove{},
+		},
+	}
+
+	dir := writeModule(t, map[string]string{
+		"go.mod":                  "module example.com/m\n\ngo 1.22\n",
+		"lib/lib.go":              "package lib\n",
+		"lib/testdata/x.go":       "package x\n",
+		"cmd/main.go":             "package main\n",
+		"internal/build/build.go": "package build\n",
+		"internal/tasks/tasks.go": "package tasks\n",
+		"other/go.mod":            "module example.com/other\n\ngo 1.22\n",
+		"other/other.go":          "package other\n",
+	})
+	t.Chdir(dir)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := goPackageMoves(tt.oldPath, tt.newPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("goPackageMoves(%q, %q) = %+v, want %+v", tt.oldPath, tt.newPath, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRewriteGoFile(t *testing.T) {
+	renamed := goPackageMove{"lib", "util", "example.com/m/lib", "example.com/m/util", "lib", "util"}
+	relocated := goPackageMove{"lib", filepath.Join("pkg", "lib"), "example.com/m/lib", "example.com/m/pkg/lib", "lib", "lib"}
+
+	tests := []struct {
+		name string
+		file string
+		move goPackageMove
+		src  string
+		want string
+	}{
+		{
+			name: "import path",
+			file: "app/app.go",
+			move: relocated,
+			src:  "package app\n\nimport \"example.com/m/lib\"\n\nvar X = lib.Helper()\n",
+			want: "package app\n\nimport \"example.com/m/pkg/lib\"\n\nvar X = lib.Helper()\n",
+		},
+		{
+			name: "implicit package name",
+			file: "app/app.go",
+			move: renamed,
+			src:  "package app\n\nimport (\n\t\"fmt\"\n\t\"example.com/m/lib\"\n)\n\nvar X = fmt.Sprint(lib.Helper())\n",
+			want: "package app\n\nimport (\n\t\"fmt\"\n\t\"example.com/m/util\"\n)\n\nvar X = fmt.Sprint(util.Helper())\n",
+		},
+		{
+			name: "new name taken",
+			file: "app/app.go",
+			move: renamed,
+			src:  "package app\n\nimport \"example.com/m/lib\"\n\nvar util = lib.Helper()\n",
+			want: "package app\n\nimport lib \"example.com/m/util\"\n\nvar util = lib.Helper()\n",
+		},
+		{
+			name: "named import",
+			file: "app/app.go",
+			move: renamed,
+			src:  "package app\n\nimport l \"example.com/m/lib\"\n\nvar X = l.Helper()\n",
+			want: "package app\n\nimport l \"example.com/m/util\"\n\nvar X = l.Helper()\n",
+		},
+		{
+			name: "local variable named like the package",
+			file: "app/app.go",
+			move: renamed,
+			src:  "package app\n\nimport \"example.com/m/lib\"\n\nfunc F() int {\n\tx := lib.Helper()\n\treturn x\n}\n",
+			want: "package app\n\nimport \"example.com/m/util\"\n\nfunc F() int {\n\tx := util.Helper()\n\treturn x\n}\n",
+		},
+		{
+			name: "package clause",
+			file: "lib/lib.go",
+			move: renamed,
+			src:  "// Package lib helps.\npackage lib\n",
+			want: "// Package lib helps.\npackage util\n",
+		},
+		{
+			name: "external test package clause",
+			file: "lib/lib_test.go",
+			move: renamed,
+			src:  "package lib_test\n\nimport \"example.com/m/lib\"\n\nvar _ = lib.Helper\n",
+			want: "package util_test\n\nimport \"example.com/m/util\"\n\nvar _ = util.Helper\n",
+		},
+		{
+			name: "unrelated file",
+			file: "app/app.go",
+			move: renamed,
+			src:  "package app\n\nimport \"example.com/m/library\"\n\nvar X = library.Helper()\n",
+			want: "package app\n\nimport \"example.com/m/library\"\n\nvar X = library.Helper()\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Chdir(writeModule(t, map[string]string{tt.file: tt.src}))
+
+			set := newChangeSet()
+			if err := rewriteGoFile(tt.file, []goPackageMove{tt.move}, set); err != nil {
+				t.Fatal(err)
+			}
+			got, err := set.current(tt.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("rewriteGoFile(%s) =\n%s\nwant\n%s", tt.file, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGoReferrersScansIgnoredDirectories(t *testing.T) {
+	t.Chdir(writeModule(t, map[string]string{
+		"go.mod":                  "module example.com/m\n\ngo 1.22\n",
+		"internal/tasks/tasks.go": "package tasks\n",
+		"internal/build/build.go": "package build\n\nimport \"example.com/m/internal/tasks\"\n\nvar _ = tasks.X\n",
+		"dist/gen.go":             "package dist\n\nimport _ \"example.com/m/internal/tasks\"\n",
+		"app/app.go":              "package app\n",
+		"testdata/data.go":        "package data\n\nimport _ \"example.com/m/internal/tasks\"\n",
+	}))
+
+	moves, err := goPackageMoves(filepath.Join("internal", "tasks"), filepath.Join("internal", "taskrun"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := goReferrers(nil, moves)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		filepath.Join("dist", "gen.go"),
+		filepath.Join("internal", "build", "build.go"),
+		filepath.Join("internal", "tasks", "tasks.go"),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("goReferrers() = %v, want %v", got, want)
+	}
+}
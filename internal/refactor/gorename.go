@@ -0,0 +1,402 @@
+package refactor
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"golang.org/x/tools/go/packages"
+	"mono-mind/internal/analyzer"
+	"mono-mind/internal/logger"
+)
+
+// goLoadMode is the information the Go refactorings need about each package
+const goLoadMode = packages.NeedName | packages.NeedFiles | packages.NeedSyntax |
+	packages.NeedTypes | packages.NeedTypesInfo | packages.NeedImports | packages.NeedDeps | packages.NeedModule
+
+// goProgram holds the type-checked Go packages of every Go module in the repository
+type goProgram struct {
+	root     string
+	fset     *token.FileSet
+	packages []*packages.Package
+	skipped  map[string]string // first error of each package left out, by package ID
+}
+
+// loadGoProgram type-checks the packages of every Go module under the root,
+// including their tests. Type information of packages with errors, or whose
+// dependencies have errors, is incomplete, so they are left out and recorded:
+// their references would not be refactored.
+func loadGoProgram(root string) (*goProgram, error) {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+
+	modules, err := goModuleDirs(absRoot)
+	if err != nil {
+		return nil, err
+	}
+	if len(modules) == 0 {
+		return nil, fmt.Errorf("no go.mod found under %s", root)
+	}
+
+	program := &goProgram{root: absRoot, fset: token.NewFileSet(), skipped: map[string]string{}}
+	for _, dir := range modules {
+		logger.Debug("Loading Go packages", "module", dir)
+		pkgs, err := packages.Load(&packages.Config{
+			Mode:  goLoadMode,
+			Dir:   dir,
+			Fset:  program.fset,
+			Tests: true,
+		}, "./...")
+		if err != nil {
+			return nil, fmt.Errorf("failed to load Go packages in %s: %v", dir, err)
+		}
+
+		for _, pkg := range pkgs {
+			if errors := packageErrors(pkg); len(errors) > 0 {
+				logger.Warn("Skipping Go package with errors, its references are not refactored",
+					"package", pkg.ID, "error", errors[0], "errors", len(errors))
+				program.skipped[pkg.ID] = errors[0]
+				continue
+			}
+			program.packages = append(program.packages, pkg)
+		}
+	}
+
+	logger.Debug("Loaded Go packages", "modules", len(modules), "packages", len(program.packages))
+	return program, nil
+}
+
+// skippedPackages returns the IDs of the packages left out because of errors
+func (p *goProgram) skippedPackages() []string {
+	ids := make([]string, 0, len(p.skipped))
+	for id := range p.skipped {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// skippedIssues reports each package left out because of errors as a
+// blocking issue, since a refactoring would leave its code unchanged
+func (p *goProgram) skippedIssues() []RenameIssue {
+	issues := []RenameIssue{}
+	for _, id := range p.skippedPackages() {
+		issues = append(issues, RenameIssue{
+			Kind:     IssuePackages,
+			Message:  fmt.Sprintf("package %s has errors and was not type-checked, its code would not be changed: %s", id, p.skipped[id]),
+			Blocking: true,
+		})
+	}
+	return issues
+}
+
+// packageErrors returns the errors of a package and its dependencies
+func packageErrors(pkg *packages.Package) []string {
+	errors := []string{}
+	packages.Visit([]*packages.Package{pkg}, nil, func(dep *packages.Package) {
+		for _, depErr := range dep.Errors {
+			errors = append(errors, depErr.Error())
+		}
+	})
+	return errors
+}
+
+// goModuleDirs returns the directories of the Go modules under the root
+func goModuleDirs(root string) ([]string, error) {
+	dirs := []string{}
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && path != root && (analyzer.IsIgnoredPath(info.Name()) || strings.HasPrefix(info.Name(), ".")) {
+			return filepath.SkipDir
+		}
+		if !info.IsDir() && info.Name() == "go.mod" {
+			dirs = append(dirs, filepath.Dir(path))
+		}
+		return nil
+	})
+	return dirs, err
+}
+
+// relPath returns a file's path relative to the repository root
+func (p *goProgram) relPath(filename string) string {
+	if rel, err := filepath.Rel(p.root, filename); err == nil {
+		return rel
+	}
+	return filename
+}
+
+// inRepo reports whether a file lies in the repository
+func (p *goProgram) inRepo(filename string) bool {
+	rel, err := filepath.Rel(p.root, filename)
+	return err == nil && !strings.HasPrefix(rel, "..") && !filepath.IsAbs(rel)
+}
+
+// objectKey identifies an object by its declaration, so the same object is
+// recognized in the packages of every Go module, which are type-checked apart
+func (p *goProgram) objectKey(obj types.Object) string {
+	pos := p.fset.Position(obj.Pos())
+	return fmt.Sprintf("%s:%d:%d:%s", pos.Filename, pos.Line, pos.Column, obj.Name())
+}
+
+// findObject resolves a rename target: a position given as file:line:col, a
+// package member given as pkg.Name, pkg.Type.Method or pkg.Type.Field, where
+// pkg is an import path or a directory of the repository, or a bare name
+// declared at package level. A bare name is looked up in the package of
+// inFile when given, and must otherwise be unique in the repository.
+func (p *goProgram) findObject(target, inFile string) (types.Object, error) {
+	if file, line, col, ok := parsePosition(target); ok {
+		return p.objectAt(file, line, col)
+	}
+
+	// Find the longest package path the target starts with
+	var pkg *packages.Package
+	matched, member := "", target
+	for _, candidate := range p.packages {
+		for _, path := range p.packagePaths(candidate) {
+			if strings.HasPrefix(target, path+".") && len(path) > len(matched) {
+				pkg, matched = candidate, path
+				member = strings.TrimPrefix(target, path+".")
+			}
+		}
+	}
+
+	names := strings.Split(member, ".")
+	if len(names) > 2 {
+		return nil, fmt.Errorf("invalid rename target %q: expected pkg.Name or pkg.Type.Member", target)
+	}
+
+	if pkg == nil {
+		candidates := p.packageLevel(names[0], inFile)
+		switch len(candidates) {
+		case 0:
+			return nil, fmt.Errorf("no package-level declaration of %s found", names[0])
+		case 1:
+			for _, obj := range candidates {
+				if len(names) == 2 {
+					return lookupMember(obj, names[1])
+				}
+				return obj, nil
+			}
+		default:
+			declared := []string{}
+			for _, obj := range candidates {
+				declared = append(declared, obj.Pkg().Path()+"."+obj.Name())
+			}
+			sort.Strings(declared)
+			return nil, fmt.Errorf("%s is declared in several packages, qualify it: %s", names[0], strings.Join(declared, ", "))
+		}
+	}
+
+	obj := pkg.Types.Scope().Lookup(names[0])
+	if obj == nil {
+		return nil, fmt.Errorf("%s is not declared in package %s", names[0], pkg.PkgPath)
+	}
+	if len(names) == 2 {
+		return lookupMember(obj, names[1])
+	}
+	return obj, nil
+}
+
+// packagePaths returns the names a package can be referred to by in a rename
+// target: its import path and its directory relative to the repository root
+func (p *goProgram) packagePaths(pkg *packages.Package) []string {
+	paths := []string{pkg.PkgPath}
+	if len(pkg.GoFiles) > 0 {
+		dir := filepath.ToSlash(p.relPath(filepath.Dir(pkg.GoFiles[0])))
+		paths = append(paths, dir, "./"+dir)
+	}
+	return paths
+}
+
+// packageLevel returns the package-level objects with a name, keyed by their
+// declaration, in the package of inFile or in every package when it is empty
+func (p *goProgram) packageLevel(name, inFile string) map[string]types.Object {
+	absFile := ""
+	if inFile != "" {
+		absFile, _ = filepath.Abs(inFile)
+	}
+
+	objects := map[string]types.Object{}
+	for _, pkg := range p.packages {
+		if absFile != "" && !containsFile(pkg, absFile) {
+			continue
+		}
+		if obj := pkg.Types.Scope().Lookup(name); obj != nil {
+			objects[p.objectKey(obj)] = obj
+		}
+	}
+	return objects
+}
+
+// objectAt returns the object declared or referred to by the identifier at a position
+func (p *goProgram) objectAt(file string, line, col int) (types.Object, error) {
+	absFile, err := filepath.Abs(file)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, pkg := range p.packages {
+		if !containsFile(pkg, absFile) {
+			continue
+		}
+		for ident, obj := range identObjects(pkg.TypesInfo) {
+			pos := p.fset.Position(ident.Pos())
+			if pos.Filename == absFile && pos.Line == line && col >= pos.Column && col < pos.Column+len(ident.Name) {
+				if obj == nil {
+					return nil, fmt.Errorf("%s at %s:%d:%d does not denote a renamable object", ident.Name, file, line, col)
+				}
+				return obj, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no identifier at %s:%d:%d", file, line, col)
+}
+
+// references returns the identifiers denoting an object in every package,
+// keyed by file name and offset. Renaming a type also renames the fields that
+// embed it, since they are named after the type.
+func (p *goProgram) references(target types.Object) map[string]map[int]*ast.Ident {
+	keys := map[string]bool{p.objectKey(target): true}
+	if _, isType := target.(*types.TypeName); isType {
+		for _, pkg := range p.packages {
+			for ident, obj := range pkg.TypesInfo.Defs {
+				field, ok := obj.(*types.Var)
+				if !ok || !field.Embedded() {
+					continue
+				}
+				if used := pkg.TypesInfo.Uses[ident]; used != nil && p.objectKey(used) == p.objectKey(target) {
+					keys[p.objectKey(field)] = true
+				}
+			}
+		}
+	}
+
+	refs := map[string]map[int]*ast.Ident{}
+	for _, pkg := range p.packages {
+		for ident, obj := range identObjects(pkg.TypesInfo) {
+			if obj == nil || !keys[p.objectKey(obj)] {
+				continue
+			}
+			pos := p.fset.Position(ident.Pos())
+			if refs[pos.Filename] == nil {
+				refs[pos.Filename] = map[int]*ast.Ident{}
+			}
+			refs[pos.Filename][pos.Offset] = ident
+		}
+	}
+	return refs
+}
+
+// identObjects returns the object each identifier of a package defines or uses
+func identObjects(info *types.Info) map[*ast.Ident]types.Object {
+	objects := make(map[*ast.Ident]types.Object, len(info.Defs)+len(info.Uses))
+	for ident, obj := range info.Defs {
+		objects[ident] = obj
+	}
+	for ident, obj := range info.Uses {
+		// An embedded field denotes both the field and its type; the field wins
+		if _, defined := objects[ident]; !defined || objects[ident] == nil {
+			objects[ident] = obj
+		}
+	}
+	return objects
+}
+
+// lookupMember returns the method or field of a named type
+func lookupMember(obj types.Object, name string) (types.Object, error) {
+	typeName, ok := obj.(*types.TypeName)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a type", obj.Name())
+	}
+
+	member, _, _ := types.LookupFieldOrMethod(typeName.Type(), true, typeName.Pkg(), name)
+	if member == nil {
+		return nil, fmt.Errorf("type %s has no field or method %s", typeName.Name(), name)
+	}
+	return member, nil
+}
+
+// containsFile reports whether a file belongs to a package
+func containsFile(pkg *packages.Package, absFile string) bool {
+	for _, file := range pkg.GoFiles {
+		if file == absFile {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePosition parses a target given as file:line:col
+func parsePosition(target string) (string, int, int, bool) {
+	parts := strings.Split(target, ":")
+	if len(parts) < 3 {
+		return "", 0, 0, false
+	}
+
+	line, lineErr := strconv.Atoi(parts[len(parts)-2])
+	col, colErr := strconv.Atoi(parts[len(parts)-1])
+	if lineErr != nil || colErr != nil {
+		return "", 0, 0, false
+	}
+	return strings.Join(parts[:len(parts)-2], ":"), line, col, true
+}
+
+// resolveRename finds the Go object a rename target denotes and checks it can be renamed
+func resolveRename(program *goProgram, target, inFile, newName string) (types.Object, error) {
+	if !token.IsIdentifier(newName) {
+		return nil, fmt.Errorf("%q is not a valid Go identifier", newName)
+	}
+
+	obj, err := program.findObject(target, inFile)
+	if err != nil {
+		return nil, err
+	}
+	if obj.Pkg() == nil || !obj.Pos().IsValid() {
+		return nil, fmt.Errorf("cannot rename predeclared %s", obj.Name())
+	}
+	if declared := program.fset.Position(obj.Pos()).Filename; !program.inRepo(declared) {
+		return nil, fmt.Errorf("cannot rename %s: it is declared outside the repository in %s", obj.Name(), declared)
+	}
+	if obj.Name() == newName {
+		return nil, fmt.Errorf("%s already has that name", obj.Name())
+	}
+	return obj, nil
+}
+
+// renameGo replaces the given references to an object with its new name in a change set
+func renameGo(program *goProgram, refs map[string]map[int]*ast.Ident, newName string, set *changeSet) error {
+	for filename, idents := range refs {
+		if !program.inRepo(filename) || !strings.HasSuffix(filename, ".go") {
+			continue
+		}
+		content, err := os.ReadFile(filename)
+		if err != nil {
+			return err
+		}
+
+		// Replace from the end so earlier offsets stay valid
+		offsets := make([]int, 0, len(idents))
+		for offset := range idents {
+			offsets = append(offsets, offset)
+		}
+		sort.Sort(sort.Reverse(sort.IntSlice(offsets)))
+		for _, offset := range offsets {
+			oldName := idents[offset].Name
+			if offset+len(oldName) > len(content) || string(content[offset:offset+len(oldName)]) != oldName {
+				return fmt.Errorf("%s changed since it was loaded", program.relPath(filename))
+			}
+			content = append(content[:offset:offset], append([]byte(newName), content[offset+len(oldName):]...)...)
+		}
+		set.edit(program.relPath(filename), content)
+	}
+	return nil
+}
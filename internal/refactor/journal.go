@@ -0,0 +1,449 @@
+package refactor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"go/format"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"golang.org/x/mod/modfile"
+	"mono-mind/internal/logger"
+)
+
+// JournalDir is where the last applied refactoring is recorded so it can be undone
+var JournalDir = filepath.Join(".mono", "refactor")
+
+// journal records the files a refactoring changes and a backup of their old
+// content. It is written before any file is touched, so an interrupted
+// refactoring can be rolled back as well as a completed one.
+type journal struct {
+	Operation string         `json:"operation"`
+	StartedAt string         `json:"started_at"`
+	Applied   bool           `json:"applied"`
+	Entries   []journalEntry `json:"entries"`
+	Dirs      []string       `json:"dirs,omitempty"`
+}
+
+// journalEntry is the journal record of a single file change
+type journalEntry struct {
+	OldPath string      `json:"old_path,omitempty"`
+	NewPath string      `json:"new_path,omitempty"`
+	Backup  string      `json:"backup,omitempty"`
+	Staged  string      `json:"staged,omitempty"`
+	Mode    os.FileMode `json:"mode"`
+	Hash    string      `json:"hash,omitempty"`
+}
+
+// journalFile returns the path of the journal
+func journalFile() string {
+	return filepath.Join(JournalDir, "journal.json")
+}
+
+// loadJournal reads the journal, returning nil if there is none
+func loadJournal() (*journal, error) {
+	data, err := os.ReadFile(journalFile())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	j := &journal{}
+	if err := json.Unmarshal(data, j); err != nil {
+		return nil, fmt.Errorf("invalid refactor journal %s: %v", journalFile(), err)
+	}
+	return j, nil
+}
+
+// save writes the journal, replacing the previous one atomically
+func (j *journal) save() error {
+	data, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := journalFile() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, journalFile())
+}
+
+// commitChanges applies a change set as a single transaction. Every new file
+// is verified and staged next to its destination first; the old content of
+// every changed file is backed up in the journal; only then are the staged
+// files renamed into place. If any step fails, the files already changed are
+// restored, so either the whole change set is applied or none of it.
+func commitChanges(set *changeSet, operation string) error {
+	previous, err := loadJournal()
+	if err != nil {
+		return err
+	}
+	if previous != nil && !previous.Applied {
+		return fmt.Errorf("the refactoring %q was interrupted, run mono refactor --undo first", previous.Operation)
+	}
+
+	changes := set.sorted()
+	if err := verifyChanges(set, changes); err != nil {
+		return err
+	}
+
+	if err := os.RemoveAll(JournalDir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(JournalDir, "backup"), 0750); err != nil {
+		return err
+	}
+
+	j := &journal{Operation: operation, StartedAt: time.Now().Format(time.RFC3339)}
+	if err := j.stage(changes); err != nil {
+		j.rollback()
+		return err
+	}
+	if err := j.save(); err != nil {
+		j.rollback()
+		return err
+	}
+
+	if err := j.commit(); err != nil {
+		if rollbackErr := j.rollback(); rollbackErr != nil {
+			return fmt.Errorf("%v (rollback failed: %v, run mono refactor --undo)", err, rollbackErr)
+		}
+		os.RemoveAll(JournalDir)
+		return fmt.Errorf("%v (all changes were rolled back)", err)
+	}
+
+	j.Applied = true
+	return j.save()
+}
+
+// verifyChanges checks that every changed Go file still parses and formats,
+// that changed go.mod and go.work files still parse, and that no file would
+// overwrite one the change set does not move away. JS/TS, Python and other
+// files are not checked.
+func verifyChanges(set *changeSet, changes []*fileChange) error {
+	vacated := map[string]bool{}
+	for _, change := range changes {
+		if change.oldPath != "" && change.oldPath != change.newPath {
+			vacated[change.oldPath] = true
+		}
+	}
+
+	failures := []string{}
+	for _, change := range changes {
+		if change.newPath == "" {
+			continue
+		}
+		if change.newPath != change.oldPath && !vacated[change.newPath] {
+			if _, err := os.Lstat(change.newPath); err == nil {
+				failures = append(failures, fmt.Sprintf("%s already exists", change.newPath))
+				continue
+			}
+		}
+		base := filepath.Base(change.newPath)
+		if filepath.Ext(base) != ".go" && base != "go.mod" && base != "go.work" {
+			continue
+		}
+
+		content, err := change.newContent()
+		if err != nil {
+			return err
+		}
+		switch base {
+		case "go.mod":
+			_, err = modfile.Parse(change.newPath, content, nil)
+		case "go.work":
+			_, err = modfile.ParseWork(change.newPath, content, nil)
+		default:
+			_, err = format.Source(content)
+		}
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", change.newPath, err))
+		}
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("refactored files failed verification: %s", strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// stage backs up the old content of every changed file and writes every new
+// file to a hidden file next to its destination
+func (j *journal) stage(changes []*fileChange) error {
+	for i, change := range changes {
+		entry := journalEntry{OldPath: change.oldPath, NewPath: change.newPath, Mode: 0644}
+
+		if change.oldPath != "" {
+			info, err := os.Stat(change.oldPath)
+			if err != nil {
+				return err
+			}
+			entry.Mode = info.Mode().Perm()
+
+			content, err := os.ReadFile(change.oldPath)
+			if err != nil {
+				return err
+			}
+			entry.Backup = filepath.Join(JournalDir, "backup", fmt.Sprintf("%d", i))
+			if err := os.WriteFile(entry.Backup, content, 0600); err != nil {
+				return err
+			}
+		}
+
+		if change.newPath != "" {
+			content, err := change.newContent()
+			if err != nil {
+				return err
+			}
+			if err := j.makeDirs(filepath.Dir(change.newPath)); err != nil {
+				return err
+			}
+			entry.Staged = filepath.Join(filepath.Dir(change.newPath), "."+filepath.Base(change.newPath)+".mono-staged")
+			if err := os.WriteFile(entry.Staged, content, entry.Mode); err != nil {
+				return err
+			}
+			entry.Hash = contentHash(content)
+		}
+
+		j.Entries = append(j.Entries, entry)
+	}
+	return nil
+}
+
+// makeDirs creates a directory and its missing parents, recording the ones
+// created so they can be removed again
+func (j *journal) makeDirs(dir string) error {
+	missing := []string{}
+	for current := dir; ; current = filepath.Dir(current) {
+		if _, err := os.Stat(current); err == nil {
+			break
+		}
+		missing = append(missing, current)
+		if filepath.Dir(current) == current {
+			break
+		}
+	}
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return err
+	}
+	j.Dirs = append(j.Dirs, missing...)
+	return nil
+}
+
+// commit removes the files moved or removed by the refactoring and renames
+// the staged files into place
+func (j *journal) commit() error {
+	for _, entry := range j.Entries {
+		if entry.OldPath != "" && entry.OldPath != entry.NewPath {
+			if err := os.Remove(entry.OldPath); err != nil {
+				return err
+			}
+		}
+	}
+	for _, entry := range j.Entries {
+		if entry.Staged != "" {
+			if err := os.Rename(entry.Staged, entry.NewPath); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// rollback restores every file recorded in the journal to its old content and
+// removes the files and directories the refactoring created
+func (j *journal) rollback() error {
+	failures := []string{}
+	for _, entry := range j.Entries {
+		if entry.Staged != "" {
+			os.Remove(entry.Staged)
+		}
+		if entry.NewPath != "" && entry.NewPath != entry.OldPath {
+			if err := os.Remove(entry.NewPath); err != nil && !os.IsNotExist(err) {
+				failures = append(failures, err.Error())
+			}
+		}
+	}
+
+	for _, entry := range j.Entries {
+		if entry.Backup == "" {
+			continue
+		}
+		content, err := os.ReadFile(entry.Backup)
+		if err == nil {
+			err = os.MkdirAll(filepath.Dir(entry.OldPath), 0750)
+		}
+		if err == nil {
+			err = os.WriteFile(entry.OldPath, content, entry.Mode)
+		}
+		if err != nil {
+			failures = append(failures, err.Error())
+		}
+	}
+
+	// Remove the deepest directories first; directories still in use are kept
+	dirs := append([]string{}, j.Dirs...)
+	sort.Slice(dirs, func(a, b int) bool { return len(dirs[a]) > len(dirs[b]) })
+	for _, dir := range dirs {
+		os.Remove(dir)
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("%s", strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// modified returns the files changed again since the journaled refactoring was applied
+func (j *journal) modified() []string {
+	modified := []string{}
+	for _, entry := range j.Entries {
+		if entry.NewPath == "" {
+			if _, err := os.Lstat(entry.OldPath); err == nil {
+				modified = append(modified, entry.OldPath)
+			}
+			continue
+		}
+		content, err := os.ReadFile(entry.NewPath)
+		if err != nil || contentHash(content) != entry.Hash {
+			modified = append(modified, entry.NewPath)
+		}
+	}
+	return modified
+}
+
+// contentHash returns the SHA-256 of a file's content
+func contentHash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// Undo restores the files changed by the last applied refactoring. It is
+// refused if any of them changed since, unless it is forced. An interrupted
+// refactoring is always rolled back.
+func Undo(config RefactorConfig) *RefactorResult {
+	logger.Info("Undoing last refactoring", "dry_run", config.DryRun)
+
+	result := &RefactorResult{
+		FilesChanged: []string{},
+		Errors:       []string{},
+	}
+
+	j, err := loadJournal()
+	if err != nil {
+		result.Errors = append(result.Errors, fmt.Sprintf("Error reading refactor journal: %v", err))
+		return result
+	}
+	if j == nil {
+		result.Errors = append(result.Errors, "No refactoring to undo")
+		return result
+	}
+	logger.Info("Found refactoring", "operation", j.Operation, "started_at", j.StartedAt, "applied", j.Applied)
+
+	if j.Applied {
+		if modified := j.modified(); len(modified) > 0 && !config.Force {
+			result.Errors = append(result.Errors, fmt.Sprintf("Refusing to undo %q: %s changed since (use --force to undo anyway)", j.Operation, strings.Join(modified, ", ")))
+			return result
+		}
+		if err := j.undoPatch(result); err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("Error computing diff: %v", err))
+			return result
+		}
+	}
+
+	for _, entry := range j.Entries {
+		path := entry.OldPath
+		if path == "" {
+			path = entry.NewPath
+		}
+		result.FilesChanged = append(result.FilesChanged, path)
+		if config.DryRun {
+			logger.Info("Would restore file (dry-run)", "file", path)
+		}
+	}
+	if config.DryRun {
+		return result
+	}
+
+	if err := j.rollback(); err != nil {
+		result.Errors = append(result.Errors, fmt.Sprintf("Error restoring files: %v", err))
+		return result
+	}
+	if err := os.RemoveAll(JournalDir); err != nil {
+		result.Errors = append(result.Errors, fmt.Sprintf("Error removing refactor journal: %v", err))
+	}
+	logger.Info("Undid refactoring", "operation", j.Operation, "files", len(result.FilesChanged))
+
+	return result
+}
+
+// undoPatch records in the result the diff that undoing an applied refactoring makes
+func (j *journal) undoPatch(result *RefactorResult) error {
+	set := newChangeSet()
+	for _, entry := range j.Entries {
+		if entry.NewPath == "" {
+			content, err := os.ReadFile(entry.Backup)
+			if err != nil {
+				return err
+			}
+			set.create(entry.OldPath, content)
+			continue
+		}
+		if entry.OldPath == "" {
+			set.remove(entry.NewPath)
+			continue
+		}
+
+		content, err := os.ReadFile(entry.Backup)
+		if err != nil {
+			return err
+		}
+		set.move(entry.NewPath, entry.OldPath)
+		set.edit(entry.NewPath, content)
+	}
+
+	patch, err := set.patch()
+	if err != nil {
+		return err
+	}
+	result.Patch = patch
+	return nil
+}
+
+// checkWorktree refuses to refactor a git worktree with uncommitted changes,
+// which could not be told apart from the refactoring's. Mono's own state is
+// ignored, and so are directories outside of a git repository.
+func checkWorktree(config RefactorConfig) error {
+	if config.DryRun || config.AllowDirty {
+		return nil
+	}
+
+	output, err := exec.Command("git", "status", "--porcelain").Output()
+	if err != nil {
+		logger.Debug("Not checking for uncommitted changes", "error", err)
+		return nil
+	}
+
+	dirty := []string{}
+	for _, line := range strings.Split(string(output), "\n") {
+		if len(line) < 4 {
+			continue
+		}
+		path := strings.Trim(line[3:], `"`)
+		if strings.HasPrefix(path, ".mono/") || strings.Contains(path, "/.mono/") {
+			continue
+		}
+		dirty = append(dirty, path)
+	}
+	if len(dirty) > 0 {
+		return fmt.Errorf("the worktree has uncommitted changes (%s), commit or stash them or use --allow-dirty", strings.Join(dirty, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,232 @@
+package refactor
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCommitChangesAndUndo(t *testing.T) {
+	before := map[string]string{
+		"go.mod":     "module example.com/m\n\ngo 1.22\n",
+		"a.go":       "package m\n\nvar A = 1\n",
+		"b.txt":      "b\n",
+		"c.txt":      "c\n",
+		"lib/lib.go": "package lib\n",
+	}
+
+	tests := []struct {
+		name    string
+		change  func(set *changeSet)
+		after   map[string]string
+		wantErr string
+	}{
+		{
+			name:   "file edited",
+			change: func(set *changeSet) { set.edit("a.go", []byte("package m\n\nvar A = 2\n")) },
+			after: map[string]string{
+				"go.mod":     before["go.mod"],
+				"a.go":       "package m\n\nvar A = 2\n",
+				"b.txt":      "b\n",
+				"c.txt":      "c\n",
+				"lib/lib.go": "package lib\n",
+			},
+		},
+		{
+			name: "files created, moved and removed",
+			change: func(set *changeSet) {
+				set.create("new/dir/d.txt", []byte("d\n"))
+				set.move("lib/lib.go", "pkg/lib/lib.go")
+				set.remove("c.txt")
+			},
+			after: map[string]string{
+				"go.mod":         before["go.mod"],
+				"a.go":           "package m\n\nvar A = 1\n",
+				"b.txt":          "b\n",
+				"new/dir/d.txt":  "d\n",
+				"pkg/lib/lib.go": "package lib\n",
+			},
+		},
+		{
+			name: "files swapped",
+			change: func(set *changeSet) {
+				set.move("b.txt", "c.txt")
+				set.move("c.txt", "b.txt")
+			},
+			after: map[string]string{
+				"go.mod":     before["go.mod"],
+				"a.go":       "package m\n\nvar A = 1\n",
+				"b.txt":      "c\n",
+				"c.txt":      "b\n",
+				"lib/lib.go": "package lib\n",
+			},
+		},
+		{
+			name:    "Go file that does not parse",
+			change:  func(set *changeSet) { set.edit("a.go", []byte("package m\n\nvar A =\n")) },
+			wantErr: "a.go:",
+		},
+		{
+			name:    "go.mod that does not parse",
+			change:  func(set *changeSet) { set.edit("go.mod", []byte("module\n")) },
+			wantErr: "go.mod:",
+		},
+		{
+			name:    "destination that exists",
+			change:  func(set *changeSet) { set.move("b.txt", "c.txt") },
+			wantErr: "c.txt already exists",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeModule(t, before)
+			t.Chdir(dir)
+
+			set := newChangeSet()
+			tt.change(set)
+			err := commitChanges(set, tt.name)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("commitChanges() = %v, want an error containing %q", err, tt.wantErr)
+				}
+				if got := readTree(t, dir); !reflect.DeepEqual(got, before) {
+					t.Errorf("files after a failed commit = %q, want %q", got, before)
+				}
+				if _, err := os.Stat(JournalDir); !os.IsNotExist(err) {
+					t.Errorf("a failed commit left a journal: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := readTree(t, dir); !reflect.DeepEqual(got, tt.after) {
+				t.Errorf("files after commit = %q, want %q", got, tt.after)
+			}
+
+			result := Undo(RefactorConfig{})
+			if len(result.Errors) > 0 {
+				t.Fatalf("Undo() errors: %v", result.Errors)
+			}
+			if got := readTree(t, dir); !reflect.DeepEqual(got, before) {
+				t.Errorf("files after undo = %q, want %q", got, before)
+			}
+			if _, err := os.Stat("new"); !os.IsNotExist(err) {
+				t.Errorf("undo left a created directory: %v", err)
+			}
+			if _, err := os.Stat(JournalDir); !os.IsNotExist(err) {
+				t.Errorf("undo left the journal: %v", err)
+			}
+		})
+	}
+}
+
+func TestRollbackOfPartialCommit(t *testing.T) {
+	before := map[string]string{"a.txt": "a\n", "b.txt": "b\n"}
+	dir := writeModule(t, before)
+	t.Chdir(dir)
+	if err := os.MkdirAll(filepath.Join(JournalDir, "backup"), 0750); err != nil {
+		t.Fatal(err)
+	}
+
+	set := newChangeSet()
+	set.edit("a.txt", []byte("a2\n"))
+	set.move("b.txt", "sub/b.txt")
+	j := &journal{Operation: "partial"}
+	if err := j.stage(set.sorted()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The second file fails to be renamed into place after the first one was
+	if err := os.Remove(j.Entries[1].Staged); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.commit(); err == nil {
+		t.Fatal("commit() succeeded without the staged file")
+	}
+	if got := readTree(t, dir); reflect.DeepEqual(got, before) {
+		t.Fatalf("commit() changed nothing before failing: %q", got)
+	}
+
+	if err := j.rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTree(t, dir); !reflect.DeepEqual(got, before) {
+		t.Errorf("files after rollback = %q, want %q", got, before)
+	}
+	if _, err := os.Stat("sub"); !os.IsNotExist(err) {
+		t.Errorf("rollback left a created directory: %v", err)
+	}
+}
+
+func TestUndo(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  bool
+		config  RefactorConfig
+		want    string
+		wantErr string
+	}{
+		{name: "unmodified", want: "a\n"},
+		{name: "dry run", config: RefactorConfig{DryRun: true}, want: "a2\n"},
+		{name: "modified since", modify: true, want: "a3\n", wantErr: "Refusing to undo"},
+		{name: "modified since and forced", modify: true, config: RefactorConfig{Force: true}, want: "a\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Chdir(writeModule(t, map[string]string{"a.txt": "a\n"}))
+
+			set := newChangeSet()
+			set.edit("a.txt", []byte("a2\n"))
+			if err := commitChanges(set, "edit"); err != nil {
+				t.Fatal(err)
+			}
+			if tt.modify {
+				if err := os.WriteFile("a.txt", []byte("a3\n"), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			result := Undo(tt.config)
+			errors := strings.Join(result.Errors, "; ")
+			if (tt.wantErr == "") != (errors == "") || !strings.Contains(errors, tt.wantErr) {
+				t.Errorf("Undo() errors = %q, want %q", errors, tt.wantErr)
+			}
+			content, err := os.ReadFile("a.txt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != tt.want {
+				t.Errorf("a.txt after Undo() = %q, want %q", content, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommitChangesRefusesAfterInterruption(t *testing.T) {
+	t.Chdir(writeModule(t, map[string]string{"a.txt": "a\n"}))
+	if err := os.MkdirAll(JournalDir, 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&journal{Operation: "interrupted"}).save(); err != nil {
+		t.Fatal(err)
+	}
+
+	set := newChangeSet()
+	set.edit("a.txt", []byte("a2\n"))
+	if err := commitChanges(set, "edit"); err == nil || !strings.Contains(err.Error(), "interrupted") {
+		t.Errorf("commitChanges() = %v, want an error about the interrupted refactoring", err)
+	}
+
+	// Undo rolls an interrupted refactoring back whatever changed since
+	if result := Undo(RefactorConfig{}); len(result.Errors) > 0 {
+		t.Errorf("Undo() errors: %v", result.Errors)
+	}
+	if _, err := os.Stat(JournalDir); !os.IsNotExist(err) {
+		t.Errorf("undo left the journal: %v", err)
+	}
+}
@@ -0,0 +1,539 @@
+package refactor
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"mono-mind/internal/analyzer"
+	"mono-mind/internal/logger"
+)
+
+// Kinds of JS/TS module specifiers
+const (
+	jsRelative  = "relative"
+	jsAlias     = "alias"
+	jsWorkspace = "workspace"
+)
+
+// jsExtensions are tried, in order, to resolve a module specifier without an extension
+var jsExtensions = []string{".ts", ".tsx", ".d.ts", ".js", ".jsx", ".mjs", ".cjs", ".mts", ".cts"}
+
+// jsSpecifierPattern matches the module specifiers of import and export
+// declarations, dynamic imports and require calls
+var jsSpecifierPattern = regexp.MustCompile(`\b(?:from|import|require)\s*\(?\s*['"]([^'"\n]+)['"]`)
+
+// jsProject holds what is needed to resolve module specifiers of the JS/TS
+// files of the repository: the files, the workspace packages and the tsconfig
+// path aliases
+type jsProject struct {
+	files     []string
+	packages  map[string]string
+	tsconfigs map[string]*tsconfig
+}
+
+// tsconfig holds the path aliases of a tsconfig.json, merged with the
+// configurations it extends
+type tsconfig struct {
+	baseURL   string
+	paths     map[string][]string
+	pathsDir  string
+	pathsFile string
+}
+
+// jsSpecifier is a module specifier written in a JS/TS file
+type jsSpecifier struct {
+	start int
+	end   int
+	value string
+}
+
+// jsResolved is the file a module specifier resolves to and how it was written
+type jsResolved struct {
+	target    string
+	kind      string
+	pattern   string
+	extension string
+	index     bool
+	bare      bool
+}
+
+// isJSFile reports whether a file is JavaScript or TypeScript source
+func isJSFile(path string) bool {
+	switch filepath.Ext(path) {
+	case ".ts", ".tsx", ".js", ".jsx", ".mjs", ".cjs", ".mts", ".cts":
+		return true
+	}
+	return false
+}
+
+// loadJSProject finds the JS/TS files and workspace packages under a directory
+func loadJSProject(root string) (*jsProject, error) {
+	project := &jsProject{packages: map[string]string{}, tsconfigs: map[string]*tsconfig{}}
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path != root && (analyzer.IsIgnoredPath(info.Name()) || strings.HasPrefix(info.Name(), ".")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if isJSFile(path) {
+			project.files = append(project.files, path)
+		}
+		if info.Name() == "package.json" {
+			var manifest struct {
+				Name string `json:"name"`
+			}
+			content, err := os.ReadFile(filepath.Clean(path))
+			if err == nil && json.Unmarshal(content, &manifest) == nil && manifest.Name != "" {
+				project.packages[manifest.Name] = filepath.Dir(path)
+			}
+		}
+		return nil
+	})
+	return project, err
+}
+
+// jsSpecifiers returns the module specifiers of a file, skipping comments and strings
+func jsSpecifiers(content []byte) []jsSpecifier {
+	code := jsCodeMask(content)
+	specifiers := []jsSpecifier{}
+	for _, match := range jsSpecifierPattern.FindAllSubmatchIndex(content, -1) {
+		if !code[match[0]] {
+			continue
+		}
+		specifiers = append(specifiers, jsSpecifier{match[2], match[3], string(content[match[2]:match[3]])})
+	}
+	return specifiers
+}
+
+// jsCodeMask reports for every byte of a file whether it is code, as opposed
+// to a comment or a string. Expressions in template literals are code.
+func jsCodeMask(content []byte) []bool {
+	code := make([]bool, len(content))
+	templates := []int{}
+	inTemplate := false
+
+	for i := 0; i < len(content); {
+		c := content[i]
+		next := byte(0)
+		if i+1 < len(content) {
+			next = content[i+1]
+		}
+
+		if inTemplate {
+			switch {
+			case c == '\\':
+				i += 2
+			case c == '`':
+				inTemplate = false
+				i++
+			case c == '$' && next == '{':
+				templates = append(templates, 0)
+				inTemplate = false
+				i += 2
+			default:
+				i++
+			}
+			continue
+		}
+
+		switch {
+		case c == '/' && next == '/':
+			for i < len(content) && content[i] != '\n' {
+				i++
+			}
+		case c == '/' && next == '*':
+			end := strings.Index(string(content[i+2:]), "*/")
+			if end < 0 {
+				i = len(content)
+			} else {
+				i += end + 4
+			}
+		case c == '\'' || c == '"':
+			for i++; i < len(content) && content[i] != c && content[i] != '\n'; i++ {
+				if content[i] == '\\' {
+					i++
+				}
+			}
+			i++
+		case c == '`':
+			inTemplate = true
+			i++
+		case c == '}' && len(templates) > 0 && templates[len(templates)-1] == 0:
+			templates = templates[:len(templates)-1]
+			inTemplate = true
+			i++
+		default:
+			if len(templates) > 0 {
+				if c == '{' {
+					templates[len(templates)-1]++
+				} else if c == '}' {
+					templates[len(templates)-1]--
+				}
+			}
+			code[i] = true
+			i++
+		}
+	}
+	return code
+}
+
+// resolve returns the file a module specifier of a file refers to. Relative
+// specifiers, tsconfig path aliases and workspace package names are resolved;
+// other packages are not.
+func (p *jsProject) resolve(from, value string) (jsResolved, bool) {
+	if value == "." || value == ".." || strings.HasPrefix(value, "./") || strings.HasPrefix(value, "../") {
+		resolved, ok := resolveJSPath(filepath.Join(filepath.Dir(from), filepath.FromSlash(value)))
+		resolved.kind = jsRelative
+		return resolved, ok
+	}
+
+	if config := p.tsconfigFor(filepath.Dir(from)); config != nil {
+		for _, pattern := range sortedKeys(config.paths) {
+			star, ok := matchPattern(pattern, value)
+			if !ok {
+				continue
+			}
+			for _, target := range config.paths[pattern] {
+				resolved, ok := resolveJSPath(filepath.Join(config.pathsDir, filepath.FromSlash(strings.Replace(target, "*", star, 1))))
+				if ok {
+					resolved.kind, resolved.pattern = jsAlias, pattern
+					return resolved, true
+				}
+			}
+		}
+		if config.baseURL != "" {
+			if resolved, ok := resolveJSPath(filepath.Join(config.baseURL, filepath.FromSlash(value))); ok {
+				resolved.kind = jsAlias
+				return resolved, true
+			}
+		}
+	}
+
+	name, dir, ok := p.workspacePackage(value)
+	if !ok {
+		return jsResolved{}, false
+	}
+	if value == name {
+		entry, ok := packageEntry(dir)
+		return jsResolved{target: entry, kind: jsWorkspace, bare: true}, ok
+	}
+	resolved, ok := resolveJSPath(filepath.Join(dir, filepath.FromSlash(strings.TrimPrefix(value, name+"/"))))
+	resolved.kind = jsWorkspace
+	return resolved, ok
+}
+
+// workspacePackage returns the workspace package a specifier imports from
+func (p *jsProject) workspacePackage(value string) (string, string, bool) {
+	for name, dir := range p.packages {
+		if value == name || strings.HasPrefix(value, name+"/") {
+			return name, dir, true
+		}
+	}
+	return "", "", false
+}
+
+// packageEntry returns the file a workspace package's name resolves to
+func packageEntry(dir string) (string, bool) {
+	var manifest struct {
+		Types  string `json:"types"`
+		Module string `json:"module"`
+		Main   string `json:"main"`
+	}
+	if content, err := os.ReadFile(filepath.Join(dir, "package.json")); err == nil {
+		json.Unmarshal(content, &manifest)
+	}
+	for _, entry := range []string{manifest.Types, manifest.Module, manifest.Main} {
+		if entry == "" {
+			continue
+		}
+		if resolved, ok := resolveJSPath(filepath.Join(dir, filepath.FromSlash(entry))); ok {
+			return resolved.target, true
+		}
+	}
+	resolved, ok := resolveJSPath(filepath.Join(dir, "index"))
+	if !ok {
+		resolved, ok = resolveJSPath(filepath.Join(dir, "src", "index"))
+	}
+	return resolved.target, ok
+}
+
+// resolveJSPath resolves a path written in a module specifier to a file, the
+// way TypeScript does: as is, with an extension added, with a .js extension
+// standing for TypeScript source, or as a directory with an index file
+func resolveJSPath(base string) (jsResolved, bool) {
+	if isRegularFile(base) {
+		return jsResolved{target: base, extension: filepath.Ext(base)}, true
+	}
+	for _, ext := range jsExtensions {
+		if isRegularFile(base + ext) {
+			return jsResolved{target: base + ext}, true
+		}
+	}
+	switch ext := filepath.Ext(base); ext {
+	case ".js", ".jsx", ".mjs", ".cjs":
+		stem := strings.TrimSuffix(base, ext)
+		for _, source := range []string{".ts", ".tsx", ".mts", ".cts"} {
+			if isRegularFile(stem + source) {
+				return jsResolved{target: stem + source, extension: ext}, true
+			}
+		}
+	}
+	for _, ext := range jsExtensions {
+		if index := filepath.Join(base, "index"+ext); isRegularFile(index) {
+			return jsResolved{target: index, index: true}, true
+		}
+	}
+	return jsResolved{}, false
+}
+
+// isRegularFile reports whether a path is an existing regular file
+func isRegularFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// matchPattern matches a specifier against a tsconfig paths pattern, which
+// may contain one "*", returning what the "*" matched
+func matchPattern(pattern, value string) (string, bool) {
+	star := strings.Index(pattern, "*")
+	if star < 0 {
+		return "", pattern == value
+	}
+	prefix, suffix := pattern[:star], pattern[star+1:]
+	if len(value) < len(prefix)+len(suffix) || !strings.HasPrefix(value, prefix) || !strings.HasSuffix(value, suffix) {
+		return "", false
+	}
+	return value[len(prefix) : len(value)-len(suffix)], true
+}
+
+// stylePath returns the path a specifier written like this one names for a
+// target file: without extension, with the same extension, or as the
+// directory of an index file
+func (r jsResolved) stylePath(target string) string {
+	ext := filepath.Ext(target)
+	if strings.HasSuffix(target, ".d.ts") {
+		ext = ".d.ts"
+	}
+	stem := strings.TrimSuffix(target, ext)
+	switch {
+	case r.bare:
+		return target
+	case r.index && filepath.Base(stem) == "index":
+		return filepath.Dir(target)
+	case r.extension == "":
+		return stem
+	case r.extension != ext:
+		return stem + r.extension
+	}
+	return target
+}
+
+// specifier returns the specifier a file moved from oldFile to newFile uses
+// for a target moved to newTarget, written in the same way as the resolved
+// one where possible and as a relative path otherwise
+func (p *jsProject) specifier(oldFile, newFile string, resolved jsResolved, newTarget string, moved func(string) string) string {
+	path := resolved.stylePath(newTarget)
+
+	switch resolved.kind {
+	case jsAlias:
+		if config := p.tsconfigFor(filepath.Dir(oldFile)); config != nil {
+			if baseURL := moved(config.baseURL); resolved.pattern == "" && config.baseURL != "" && withinPath(path, baseURL) && path != baseURL {
+				if rel, err := filepath.Rel(baseURL, path); err == nil {
+					return filepath.ToSlash(rel)
+				}
+			}
+			patterns := append([]string{resolved.pattern}, sortedKeys(config.paths)...)
+			for _, pattern := range patterns {
+				for _, target := range config.paths[pattern] {
+					if value, ok := aliasSpecifier(pattern, moved(filepath.Join(config.pathsDir, filepath.FromSlash(target))), path); ok {
+						return value
+					}
+				}
+			}
+		}
+	case jsWorkspace:
+		best, bestDir := "", ""
+		for name, dir := range p.packages {
+			dir = moved(dir)
+			if withinPath(path, dir) && len(dir) >= len(bestDir) && dir != "." {
+				best, bestDir = name, dir
+			}
+		}
+		if best != "" {
+			if entry, ok := packageEntry(p.packages[best]); ok && moved(entry) == newTarget && resolved.bare {
+				return best
+			}
+			rel, err := filepath.Rel(bestDir, path)
+			if err == nil {
+				return best + "/" + filepath.ToSlash(rel)
+			}
+		}
+	}
+
+	rel, err := filepath.Rel(filepath.Dir(newFile), path)
+	if err != nil {
+		return resolved.target
+	}
+	rel = filepath.ToSlash(rel)
+	if rel != "." && rel != ".." && !strings.HasPrefix(rel, "../") {
+		rel = "./" + rel
+	}
+	return rel
+}
+
+// aliasSpecifier writes a path with a tsconfig paths pattern whose target,
+// with "*" in place of the matched part, is given
+func aliasSpecifier(pattern, target, path string) (string, bool) {
+	star := strings.Index(pattern, "*")
+	if star < 0 {
+		return pattern, target == path || strings.TrimSuffix(target, filepath.Ext(target)) == path
+	}
+
+	targetStar := strings.Index(target, "*")
+	if targetStar < 0 || target[targetStar+1:] != "" {
+		return "", false
+	}
+	prefix := filepath.Clean(target[:targetStar])
+	if !withinPath(path, prefix) || path == prefix {
+		return "", false
+	}
+	rel, err := filepath.Rel(prefix, path)
+	if err != nil {
+		return "", false
+	}
+	return pattern[:star] + filepath.ToSlash(rel) + pattern[star+1:], true
+}
+
+// tsconfigFor returns the path aliases of the tsconfig.json nearest above a
+// directory, or nil if there is none
+func (p *jsProject) tsconfigFor(dir string) *tsconfig {
+	dir = filepath.Clean(dir)
+	if config, cached := p.tsconfigs[dir]; cached {
+		return config
+	}
+
+	var config *tsconfig
+	if path := filepath.Join(dir, "tsconfig.json"); isRegularFile(path) {
+		loaded, err := loadTSConfig(path, 0)
+		if err != nil {
+			logger.Warn("Failed to read tsconfig, ignoring its path aliases", "file", path, "error", err)
+		} else {
+			config = loaded
+		}
+	} else if parent := filepath.Dir(dir); parent != dir {
+		config = p.tsconfigFor(parent)
+	}
+
+	p.tsconfigs[dir] = config
+	return config
+}
+
+// loadTSConfig reads the path aliases of a tsconfig file and of the files it
+// extends by relative path
+func loadTSConfig(path string, depth int) (*tsconfig, error) {
+	content, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return nil, err
+	}
+	var raw struct {
+		Extends         json.RawMessage `json:"extends"`
+		CompilerOptions struct {
+			BaseURL *string             `json:"baseUrl"`
+			Paths   map[string][]string `json:"paths"`
+		} `json:"compilerOptions"`
+	}
+	if err := json.Unmarshal(stripJSONC(content), &raw); err != nil {
+		return nil, err
+	}
+
+	config := &tsconfig{}
+	var extends string
+	if json.Unmarshal(raw.Extends, &extends) == nil && strings.HasPrefix(extends, ".") && depth < 8 {
+		parentPath := filepath.Join(filepath.Dir(path), filepath.FromSlash(extends))
+		if filepath.Ext(parentPath) != ".json" {
+			parentPath += ".json"
+		}
+		parent, err := loadTSConfig(parentPath, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		*config = *parent
+	}
+
+	dir := filepath.Dir(path)
+	if raw.CompilerOptions.BaseURL != nil {
+		config.baseURL = filepath.Join(dir, filepath.FromSlash(*raw.CompilerOptions.BaseURL))
+		config.pathsDir = config.baseURL
+	}
+	if raw.CompilerOptions.Paths != nil {
+		config.paths = raw.CompilerOptions.Paths
+		config.pathsFile = path
+		if config.baseURL == "" {
+			config.pathsDir = dir
+		}
+	}
+	return config, nil
+}
+
+// stripJSONC removes the comments and trailing commas tsconfig files allow
+func stripJSONC(content []byte) []byte {
+	out := make([]byte, 0, len(content))
+	for i := 0; i < len(content); i++ {
+		c := content[i]
+		switch {
+		case c == '"':
+			start := i
+			for i++; i < len(content) && content[i] != '"'; i++ {
+				if content[i] == '\\' {
+					i++
+				}
+			}
+			end := i + 1
+			if end > len(content) {
+				end = len(content)
+			}
+			out = append(out, content[start:end]...)
+		case c == '/' && i+1 < len(content) && content[i+1] == '/':
+			for i < len(content) && content[i] != '\n' {
+				i++
+			}
+			out = append(out, '\n')
+		case c == '/' && i+1 < len(content) && content[i+1] == '*':
+			end := strings.Index(string(content[i+2:]), "*/")
+			if end < 0 {
+				return out
+			}
+			i += end + 3
+		case c == ',':
+			j := i + 1
+			for j < len(content) && strings.IndexByte(" \t\r\n", content[j]) >= 0 {
+				j++
+			}
+			if j < len(content) && (content[j] == '}' || content[j] == ']') {
+				continue
+			}
+			out = append(out, c)
+		default:
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// sortedKeys returns the keys of a map in order
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
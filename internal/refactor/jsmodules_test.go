@@ -0,0 +1,80 @@
+package refactor
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveJSPath(t *testing.T) {
+	t.Chdir(writeModule(t, map[string]string{
+		"src/a.ts":             "",
+		"src/a.js":             "",
+		"src/types.d.ts":       "",
+		"src/esm.ts":           "",
+		"src/both.js":          "",
+		"src/both.ts":          "",
+		"src/comp.tsx":         "",
+		"src/lib.ts":           "",
+		"src/lib/index.ts":     "",
+		"src/dir/index.ts":     "",
+		"src/legacy/index.js":  "",
+		"src/styles/theme.css": "",
+	}))
+
+	tests := []struct {
+		name string
+		base string
+		want jsResolved
+		ok   bool
+	}{
+		{"path with extension", "src/a.js", jsResolved{target: "src/a.js", extension: ".js"}, true},
+		{"extension tried in order", "src/a", jsResolved{target: "src/a.ts"}, true},
+		{"tsx file", "src/comp", jsResolved{target: "src/comp.tsx"}, true},
+		{"declaration file", "src/types", jsResolved{target: "src/types.d.ts"}, true},
+		{"js extension naming a ts source", "src/esm.js", jsResolved{target: "src/esm.ts", extension: ".js"}, true},
+		{"existing js file preferred to its ts source", "src/both.js", jsResolved{target: "src/both.js", extension: ".js"}, true},
+		{"file preferred to directory", "src/lib", jsResolved{target: "src/lib.ts"}, true},
+		{"directory index", "src/dir", jsResolved{target: filepath.Join("src", "dir", "index.ts"), index: true}, true},
+		{"directory index in js", "src/legacy", jsResolved{target: filepath.Join("src", "legacy", "index.js"), index: true}, true},
+		{"other file type", "src/styles/theme.css", jsResolved{target: "src/styles/theme.css", extension: ".css"}, true},
+		{"directory without index", "src/styles", jsResolved{}, false},
+		{"missing file", "src/missing", jsResolved{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := resolveJSPath(tt.base)
+			if ok != tt.ok || got != tt.want {
+				t.Errorf("resolveJSPath(%q) = %+v, %v, want %+v, %v", tt.base, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestMatchPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		value   string
+		want    string
+		ok      bool
+	}{
+		{"@app/*", "@app/utils/date", "utils/date", true},
+		{"@app/*", "@app/", "", true},
+		{"@app/*", "@application/x", "", false},
+		{"*.css", "theme.css", "theme", true},
+		{"@ui/*/index", "@ui/button/index", "button", true},
+		{"@ui/*/index", "@ui/button", "", false},
+		{"a*a", "a", "", false},
+		{"a*a", "aa", "", true},
+		{"*", "lodash", "lodash", true},
+		{"config", "config", "", true},
+		{"config", "config/dev", "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := matchPattern(tt.pattern, tt.value)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("matchPattern(%q, %q) = %q, %v, want %q, %v", tt.pattern, tt.value, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,499 @@
+package refactor
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"mono-mind/internal/logger"
+)
+
+// Patterns of the JS/TS syntax a refactoring rewrites
+var (
+	jsIdentPattern      = regexp.MustCompile(`[A-Za-z_$][\w$]*`)
+	jsClausePattern     = regexp.MustCompile(`\b(import|export)\s+(?:type\s+)?((?:[A-Za-z_$][\w$]*\s*,?\s*)?(?:\{[^}]*\}|\*\s*(?:as\s+[A-Za-z_$][\w$]*)?)?)\s*from\s*['"]([^'"\n]+)['"]`)
+	jsRequirePattern    = regexp.MustCompile(`\b(?:const|let|var)\s+(\{[^}]*\}|[A-Za-z_$][\w$]*)\s*=\s*require\s*\(\s*['"]([^'"\n]+)['"]\s*\)`)
+	jsExportListPattern = regexp.MustCompile(`\bexport\s+(?:type\s+)?\{[^}]*\}`)
+	jsElementPattern    = regexp.MustCompile(`(?:\btype\s+)?([A-Za-z_$][\w$]*)(?:\s+as\s+([A-Za-z_$][\w$]*)|\s*:\s*([A-Za-z_$][\w$]*))?`)
+	jsNamespacePattern  = regexp.MustCompile(`\*\s*as\s+([A-Za-z_$][\w$]*)`)
+	jsFromPattern       = regexp.MustCompile(`^\s*from\b`)
+	jsValidName         = regexp.MustCompile(`^[A-Za-z_$][\w$]*$`)
+)
+
+// jsReserved are the words that cannot name a JS/TS binding
+var jsReserved = map[string]bool{
+	"break": true, "case": true, "catch": true, "class": true, "const": true, "continue": true,
+	"debugger": true, "default": true, "delete": true, "do": true, "else": true, "enum": true,
+	"export": true, "extends": true, "false": true, "finally": true, "for": true, "function": true,
+	"if": true, "import": true, "in": true, "instanceof": true, "new": true, "null": true,
+	"return": true, "super": true, "switch": true, "this": true, "throw": true, "true": true,
+	"try": true, "typeof": true, "var": true, "void": true, "while": true, "with": true,
+	"let": true, "static": true, "yield": true, "await": true,
+}
+
+// jsClause is an import, re-export or require of another module
+type jsClause struct {
+	export    bool
+	star      bool
+	specifier string
+	namespace string
+	elements  []jsElement
+}
+
+// jsElement is a name listed in braces by an import, export or require
+type jsElement struct {
+	name    string
+	start   int
+	aliased bool
+}
+
+// moveJS rewrites the module specifiers that refer to moved JS/TS files, in
+// every JS/TS file of the repository, and the tsconfig path aliases that
+// point into the moved directory
+func moveJS(oldPath, newPath string, set *changeSet) error {
+	project, err := loadJSProject(".")
+	if err != nil {
+		return err
+	}
+	moved := func(path string) string {
+		return movedPath(path, oldPath, newPath)
+	}
+
+	for _, file := range project.files {
+		content, err := set.current(file)
+		if err != nil {
+			return err
+		}
+
+		newFile := moved(file)
+		edits := []textEdit{}
+		for _, specifier := range jsSpecifiers(content) {
+			resolved, ok := project.resolve(file, specifier.value)
+			if !ok {
+				continue
+			}
+			newTarget := moved(resolved.target)
+			if newTarget == resolved.target && (newFile == file || resolved.kind != jsRelative) {
+				continue
+			}
+			if value := project.specifier(file, newFile, resolved, newTarget, moved); value != specifier.value {
+				edits = append(edits, textEdit{specifier.start, specifier.end, value})
+			}
+		}
+		if len(edits) > 0 {
+			set.edit(file, applyEdits(content, edits))
+		}
+	}
+
+	return project.moveAliases(moved, set)
+}
+
+// moveAliases updates the targets of tsconfig path aliases that point into a
+// moved directory
+func (p *jsProject) moveAliases(moved func(string) string, set *changeSet) error {
+	files := map[string]*tsconfig{}
+	for _, config := range p.tsconfigs {
+		if config != nil && config.pathsFile != "" {
+			files[config.pathsFile] = config
+		}
+	}
+
+	for file, config := range files {
+		content, err := set.current(file)
+		if err != nil {
+			return err
+		}
+
+		updated := content
+		for _, pattern := range sortedKeys(config.paths) {
+			for _, target := range config.paths[pattern] {
+				full := filepath.Join(config.pathsDir, filepath.FromSlash(target))
+				if moved(full) == full {
+					continue
+				}
+				rel, err := filepath.Rel(moved(config.pathsDir), moved(full))
+				if err != nil {
+					continue
+				}
+				value := filepath.ToSlash(rel)
+				if strings.HasPrefix(target, "./") && !strings.HasPrefix(value, "../") {
+					value = "./" + value
+				}
+				updated = bytes.ReplaceAll(updated, []byte(`"`+target+`"`), []byte(`"`+value+`"`))
+			}
+		}
+		if !bytes.Equal(updated, content) {
+			set.edit(file, updated)
+		}
+	}
+	return nil
+}
+
+// jsRenameTarget returns the JS/TS file and the name of the export a rename
+// targets, given as file:name or as a name with the file passed separately
+func jsRenameTarget(config RefactorConfig) (string, string, bool) {
+	if isJSFile(config.FilePath) {
+		return filepath.Clean(config.FilePath), config.OldName, true
+	}
+	if separator := strings.LastIndex(config.OldName, ":"); separator > 0 && isJSFile(config.OldName[:separator]) {
+		return filepath.Clean(config.OldName[:separator]), config.OldName[separator+1:], true
+	}
+	return "", "", false
+}
+
+// jsRenamer renames a named export of a JS/TS module at its declaration and
+// in the modules that import it, directly or through re-exports
+type jsRenamer struct {
+	project   *jsProject
+	name      string
+	newName   string
+	set       *changeSet
+	exporters map[string]bool
+	frontier  map[string]bool
+	issues    []RenameIssue
+}
+
+// renameJS renames a named export declared in a JS/TS file and every import
+// of it, reporting the files where the new name is already taken
+func renameJS(file, name, newName string, set *changeSet) ([]RenameIssue, error) {
+	if !jsValidName.MatchString(newName) || jsReserved[newName] {
+		return nil, fmt.Errorf("%q is not a valid JavaScript identifier", newName)
+	}
+	if name == newName {
+		return nil, fmt.Errorf("%s already has that name", name)
+	}
+	if !isRegularFile(file) {
+		return nil, fmt.Errorf("%s does not exist", file)
+	}
+
+	project, err := loadJSProject(".")
+	if err != nil {
+		return nil, err
+	}
+	r := &jsRenamer{
+		project:   project,
+		name:      name,
+		newName:   newName,
+		set:       set,
+		exporters: map[string]bool{},
+		frontier:  map[string]bool{},
+		issues:    []RenameIssue{},
+	}
+	if err := r.renameDeclaration(file); err != nil {
+		return nil, err
+	}
+
+	// Each round renames the imports of the modules that started exporting
+	// the new name in the previous one
+	for len(r.frontier) > 0 {
+		frontier := r.frontier
+		r.frontier = map[string]bool{}
+		for _, importer := range project.files {
+			if err := r.renameImports(importer, frontier); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	sort.SliceStable(r.issues, func(i, j int) bool { return r.issues[i].Position < r.issues[j].Position })
+	return r.issues, nil
+}
+
+// addExporter records a module that exports the renamed name
+func (r *jsRenamer) addExporter(file string) {
+	if !r.exporters[file] {
+		r.exporters[file] = true
+		r.frontier[file] = true
+	}
+}
+
+// renameDeclaration renames the export in the file that declares it: every
+// reference to its local binding, or only the exported name if the binding
+// is exported under another name
+func (r *jsRenamer) renameDeclaration(file string) error {
+	content, err := r.set.current(file)
+	if err != nil {
+		return err
+	}
+	code := jsCodeMask(content)
+	name := regexp.QuoteMeta(r.name)
+
+	declaration := regexp.MustCompile(`\bexport\s+(?:declare\s+)?(?:async\s+)?(?:abstract\s+)?(?:function\s*\*?|class|const|let|var|interface|type|enum|namespace)\s+` + name + `\b`)
+	local := false
+	for _, match := range declaration.FindAllIndex(content, -1) {
+		if code[match[0]] {
+			local = true
+		}
+	}
+
+	edits := map[int]textEdit{}
+	aliased := false
+	for _, element := range jsLocalExports(content, code) {
+		if element.exported == r.name && element.name != r.name {
+			edits[element.exportedAt] = textEdit{element.exportedAt, element.exportedAt + len(r.name), r.newName}
+			aliased = true
+		} else if element.exported == r.name {
+			local = true
+		}
+	}
+	if !local && !aliased {
+		return fmt.Errorf("%s does not export %s", file, r.name)
+	}
+
+	if local {
+		r.checkConflict(file, content, code)
+		excluded := map[int]bool{}
+		for _, clause := range jsClauses(content, code) {
+			for _, element := range clause.elements {
+				excluded[element.start] = true
+			}
+		}
+		for _, offset := range jsIdentifiers(content, code, r.name) {
+			if !excluded[offset] {
+				edits[offset] = textEdit{offset, offset + len(r.name), r.newName}
+			}
+		}
+	}
+
+	r.edit(file, content, edits)
+	r.addExporter(file)
+	return nil
+}
+
+// renameImports renames the imports of the name from the given modules in a
+// file. Names imported without an alias are renamed throughout the file; the
+// file itself becomes an exporter if it re-exports the name.
+func (r *jsRenamer) renameImports(file string, exporters map[string]bool) error {
+	content, err := r.set.current(file)
+	if err != nil {
+		return err
+	}
+	code := jsCodeMask(content)
+
+	edits := map[int]textEdit{}
+	excluded := map[int]bool{}
+	renameLocal := false
+	for _, clause := range jsClauses(content, code) {
+		resolved, ok := r.project.resolve(file, clause.specifier)
+		if !ok || !exporters[resolved.target] {
+			for _, element := range clause.elements {
+				excluded[element.start] = true
+			}
+			continue
+		}
+
+		switch {
+		case clause.star && clause.namespace == "":
+			r.addExporter(file)
+		case clause.export && clause.namespace != "":
+			logger.Warn("Not renaming uses of a re-exported namespace", "file", file, "specifier", clause.specifier)
+		case clause.namespace != "":
+			for _, offset := range jsMembers(content, code, clause.namespace, r.name) {
+				edits[offset] = textEdit{offset, offset + len(r.name), r.newName}
+			}
+		}
+
+		for _, element := range clause.elements {
+			if element.name != r.name {
+				continue
+			}
+			edits[element.start] = textEdit{element.start, element.start + len(r.name), r.newName}
+			if element.aliased {
+				continue
+			}
+			if clause.export {
+				r.addExporter(file)
+			} else {
+				renameLocal = true
+			}
+		}
+	}
+
+	if renameLocal {
+		r.checkConflict(file, content, code)
+		for _, offset := range jsIdentifiers(content, code, r.name) {
+			if !excluded[offset] {
+				edits[offset] = textEdit{offset, offset + len(r.name), r.newName}
+			}
+		}
+		for _, element := range jsLocalExports(content, code) {
+			if element.exported == r.name && element.name == r.name {
+				r.addExporter(file)
+			}
+		}
+	}
+
+	r.edit(file, content, edits)
+	return nil
+}
+
+// edit records the edits of a file in the change set
+func (r *jsRenamer) edit(file string, content []byte, edits map[int]textEdit) {
+	if len(edits) == 0 {
+		return
+	}
+	list := make([]textEdit, 0, len(edits))
+	for _, edit := range edits {
+		list = append(list, edit)
+	}
+	r.set.edit(file, applyEdits(content, list))
+}
+
+// checkConflict reports a file that already uses the new name, other than as
+// a name imported under an alias
+func (r *jsRenamer) checkConflict(file string, content []byte, code []bool) {
+	aliased := map[int]bool{}
+	for _, clause := range jsClauses(content, code) {
+		for _, element := range clause.elements {
+			aliased[element.start] = element.aliased
+		}
+	}
+
+	offsets := []int{}
+	for _, offset := range jsIdentifiers(content, code, r.newName) {
+		if !aliased[offset] {
+			offsets = append(offsets, offset)
+		}
+	}
+	if len(offsets) == 0 {
+		return
+	}
+	line := bytes.Count(content[:offsets[0]], []byte("\n")) + 1
+	column := offsets[0] - bytes.LastIndexByte(content[:offsets[0]], '\n')
+	r.issues = append(r.issues, RenameIssue{
+		Kind:     IssueConflict,
+		Position: fmt.Sprintf("%s:%d:%d", file, line, column),
+		Message:  fmt.Sprintf("%s is already used in %s", r.newName, file),
+		Blocking: true,
+	})
+}
+
+// jsIdentifiers returns the offsets of the identifiers with a given name in
+// the code of a file, leaving out property accesses such as obj.name
+func jsIdentifiers(content []byte, code []bool, name string) []int {
+	offsets := []int{}
+	for _, match := range jsIdentPattern.FindAllIndex(content, -1) {
+		if !code[match[0]] || string(content[match[0]:match[1]]) != name || isMemberAccess(content, match[0]) {
+			continue
+		}
+		offsets = append(offsets, match[0])
+	}
+	return offsets
+}
+
+// isMemberAccess reports whether the identifier at an offset follows a "."
+// that is not part of a spread
+func isMemberAccess(content []byte, start int) bool {
+	i := start - 1
+	for i >= 0 && strings.IndexByte(" \t\r\n", content[i]) >= 0 {
+		i--
+	}
+	if i < 0 || content[i] != '.' {
+		return false
+	}
+	return i < 2 || content[i-1] != '.' || content[i-2] != '.'
+}
+
+// jsMembers returns the offsets of the accesses to a member of a namespace object
+func jsMembers(content []byte, code []bool, namespace, name string) []int {
+	pattern := regexp.MustCompile(`(?:^|[^\w$.])` + regexp.QuoteMeta(namespace) + `\s*\??\.\s*(` + regexp.QuoteMeta(name) + `)\b`)
+	offsets := []int{}
+	for _, match := range pattern.FindAllSubmatchIndex(content, -1) {
+		if code[match[2]] {
+			offsets = append(offsets, match[2])
+		}
+	}
+	return offsets
+}
+
+// jsClauses returns the imports, re-exports and requires of other modules in a file
+func jsClauses(content []byte, code []bool) []jsClause {
+	clauses := []jsClause{}
+	for _, match := range jsClausePattern.FindAllSubmatchIndex(content, -1) {
+		if !code[match[0]] {
+			continue
+		}
+		clause := jsClause{
+			export:    string(content[match[2]:match[3]]) == "export",
+			specifier: string(content[match[6]:match[7]]),
+		}
+		body := content[match[4]:match[5]]
+		if namespace := jsNamespacePattern.FindSubmatch(body); namespace != nil {
+			clause.namespace = string(namespace[1])
+		}
+		clause.star = bytes.HasPrefix(bytes.TrimSpace(body), []byte("*"))
+		clause.elements = jsElements(content, match[4], match[5])
+		clauses = append(clauses, clause)
+	}
+
+	for _, match := range jsRequirePattern.FindAllSubmatchIndex(content, -1) {
+		if !code[match[0]] {
+			continue
+		}
+		clause := jsClause{specifier: string(content[match[4]:match[5]])}
+		if content[match[2]] == '{' {
+			clause.elements = jsElements(content, match[2], match[3])
+		} else {
+			clause.namespace = string(content[match[2]:match[3]])
+		}
+		clauses = append(clauses, clause)
+	}
+	return clauses
+}
+
+// jsElements returns the names listed in the braces found between two offsets
+func jsElements(content []byte, start, end int) []jsElement {
+	open := bytes.IndexByte(content[start:end], '{')
+	if open < 0 {
+		return nil
+	}
+	open += start + 1
+	close := bytes.IndexByte(content[open:end], '}')
+	if close < 0 {
+		return nil
+	}
+
+	elements := []jsElement{}
+	for _, match := range jsElementPattern.FindAllSubmatchIndex(content[open:open+close], -1) {
+		elements = append(elements, jsElement{
+			name:    string(content[open+match[2] : open+match[3]]),
+			start:   open + match[2],
+			aliased: match[4] >= 0 || match[6] >= 0,
+		})
+	}
+	return elements
+}
+
+// jsLocalExport is a local binding exported by an export list
+type jsLocalExport struct {
+	name       string
+	exported   string
+	exportedAt int
+}
+
+// jsLocalExports returns the bindings exported by the export lists of a file
+// that do not re-export from another module
+func jsLocalExports(content []byte, code []bool) []jsLocalExport {
+	exports := []jsLocalExport{}
+	for _, match := range jsExportListPattern.FindAllIndex(content, -1) {
+		if !code[match[0]] || jsFromPattern.Match(content[match[1]:]) {
+			continue
+		}
+		open := match[0] + bytes.IndexByte(content[match[0]:match[1]], '{') + 1
+		for _, element := range jsElementPattern.FindAllSubmatchIndex(content[open:match[1]-1], -1) {
+			export := jsLocalExport{
+				name:       string(content[open+element[2] : open+element[3]]),
+				exportedAt: open + element[2],
+			}
+			export.exported = export.name
+			if element[4] >= 0 {
+				export.exported = string(content[open+element[4] : open+element[5]])
+				export.exportedAt = open + element[4]
+			}
+			exports = append(exports, export)
+		}
+	}
+	return exports
+}
@@ -0,0 +1,600 @@
+package refactor
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"mono-mind/internal/analyzer"
+)
+
+// pyModuleMove is a Python module or package whose dotted name changes in a move
+type pyModuleMove struct {
+	oldName string
+	newName string
+}
+
+// pyImport is an import statement of a Python file
+type pyImport struct {
+	start  int
+	end    int
+	indent string
+	from   bool
+	level  int
+	module pyToken
+	names  []pyName
+	paren  bool
+}
+
+// pyToken is a dotted name written in a Python file
+type pyToken struct {
+	start int
+	end   int
+	text  string
+}
+
+// pyName is a name imported by an import statement, with its alias if any
+type pyName struct {
+	pyToken
+	alias string
+}
+
+// pyMover rewrites the imports of Python modules moved from one path to another
+type pyMover struct {
+	oldPath string
+	newPath string
+	created map[string]bool
+	moves   []pyModuleMove
+}
+
+// movePython rewrites the imports of moved Python modules and packages in
+// every Python file of the repository: absolute imports, from-imports of the
+// moved module's package and relative imports, including those of the moved
+// files themselves. New directories the moved modules need as packages get an
+// __init__.py.
+func movePython(oldPath, newPath string, set *changeSet) error {
+	m := &pyMover{oldPath: oldPath, newPath: newPath, created: map[string]bool{}}
+
+	sources, err := pythonFiles(oldPath)
+	if err != nil || len(sources) == 0 {
+		return err
+	}
+	m.createPackages(set)
+
+	for _, source := range sources {
+		oldName, ok := pyModuleName(source, isPackageDir)
+		if !ok {
+			continue
+		}
+		newName, ok := pyModuleName(m.moved(source), m.isPackageAfter)
+		if ok && newName != oldName {
+			m.moves = append(m.moves, pyModuleMove{oldName, newName})
+		}
+	}
+	sort.Slice(m.moves, func(i, j int) bool { return len(m.moves[i].oldName) > len(m.moves[j].oldName) })
+
+	files, err := pythonFiles(".")
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := m.rewrite(file, set); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// moved returns where a path ends up after the move
+func (m *pyMover) moved(path string) string {
+	return movedPath(path, m.oldPath, m.newPath)
+}
+
+// createPackages adds an __init__.py to the directories a move creates when
+// the moved code must stay in a package: when the directories are created
+// inside a package, or when what moves comes from one
+func (m *pyMover) createPackages(set *changeSet) {
+	missing := []string{}
+	dir := filepath.Dir(m.newPath)
+	for ; dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+		if _, err := os.Stat(dir); err == nil {
+			break
+		}
+		missing = append(missing, dir)
+	}
+	if len(missing) == 0 {
+		return
+	}
+
+	if !isPackageDir(dir) && !isPackageDir(filepath.Dir(m.oldPath)) {
+		return
+	}
+	for _, dir := range missing {
+		m.created[dir] = true
+		set.create(filepath.Join(dir, "__init__.py"), []byte{})
+	}
+}
+
+// isPackageAfter reports whether a directory is a regular package once the
+// move is done
+func (m *pyMover) isPackageAfter(dir string) bool {
+	if m.created[dir] {
+		return true
+	}
+	if withinPath(dir, m.newPath) {
+		rel, err := filepath.Rel(m.newPath, dir)
+		return err == nil && isPackageDir(filepath.Join(m.oldPath, rel))
+	}
+	return !withinPath(dir, m.oldPath) && isPackageDir(dir)
+}
+
+// isPackageDir reports whether a directory is a regular Python package
+func isPackageDir(dir string) bool {
+	return isRegularFile(filepath.Join(dir, "__init__.py"))
+}
+
+// pyModuleName returns the dotted name of a Python file, made of the
+// packages above it up to the first directory that is not a package
+func pyModuleName(path string, isPackage func(string) bool) (string, bool) {
+	parts := []string{}
+	if stem := strings.TrimSuffix(filepath.Base(path), ".py"); stem != "__init__" {
+		parts = append(parts, stem)
+	}
+	for dir := filepath.Dir(path); dir != filepath.Dir(dir) && isPackage(dir); dir = filepath.Dir(dir) {
+		parts = append([]string{filepath.Base(dir)}, parts...)
+	}
+	return strings.Join(parts, "."), len(parts) > 0
+}
+
+// pyPackageOf returns the package relative imports of a module are resolved from
+func pyPackageOf(path, name string) string {
+	if filepath.Base(path) == "__init__.py" {
+		return name
+	}
+	if dot := strings.LastIndex(name, "."); dot >= 0 {
+		return name[:dot]
+	}
+	return ""
+}
+
+// pythonFiles returns the Python files under a path, skipping virtual environments
+func pythonFiles(root string) ([]string, error) {
+	files := []string{}
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path != root && (analyzer.IsIgnoredPath(info.Name()) || strings.HasPrefix(info.Name(), ".") ||
+				info.Name() == "__pycache__" || isRegularFile(filepath.Join(path, "pyvenv.cfg"))) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Ext(path) == ".py" {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// mapName returns the new dotted name of a module if it or its package moved
+func (m *pyMover) mapName(name string) (string, bool) {
+	for _, move := range m.moves {
+		if name == move.oldName {
+			return move.newName, true
+		}
+		if strings.HasPrefix(name, move.oldName+".") {
+			return move.newName + name[len(move.oldName):], true
+		}
+	}
+	return name, false
+}
+
+// rewrite rewrites the imports of moved modules in a Python file
+func (m *pyMover) rewrite(file string, set *changeSet) error {
+	content, err := set.current(file)
+	if err != nil {
+		return err
+	}
+	code := pyCodeMask(content)
+
+	oldName, _ := pyModuleName(file, isPackageDir)
+	newName, _ := pyModuleName(m.moved(file), m.isPackageAfter)
+	oldPackage, newPackage := pyPackageOf(file, oldName), pyPackageOf(m.moved(file), newName)
+	fileMoved := m.moved(file) != file
+
+	edits := map[int]textEdit{}
+	add := func(edit textEdit) {
+		if existing, ok := edits[edit.start]; !ok || edit.end > existing.end {
+			edits[edit.start] = edit
+		}
+	}
+
+	for _, statement := range pyImports(content, code) {
+		if !statement.from {
+			for _, name := range statement.names {
+				newModule, ok := m.mapName(name.text)
+				if !ok {
+					continue
+				}
+				add(textEdit{name.start, name.end, newModule})
+
+				// Without an alias the module is used by its full name
+				if name.alias == "" {
+					for _, offset := range pyReferences(content, code, name.text) {
+						add(textEdit{offset, offset + len(name.text), newModule})
+					}
+				}
+			}
+			continue
+		}
+
+		// Resolve relative imports against the module's package before the move
+		module := statement.module.text
+		if statement.level > 0 {
+			resolved, ok := pyResolveRelative(oldPackage, statement.level, module)
+			if !ok {
+				continue
+			}
+			module = resolved
+		}
+		newModule, moduleMoved := m.mapName(module)
+
+		// Names that are themselves moved modules may end up in another package
+		groups := map[string][]string{}
+		kept := []string{}
+		for _, name := range statement.names {
+			full := name.text
+			if module != "" {
+				full = module + "." + name.text
+			}
+			newFull, ok := m.mapName(full)
+			if moduleMoved || !ok || name.text == "*" {
+				kept = append(kept, pyImportedName(name.text, name.alias, name.text))
+				continue
+			}
+
+			parent, last := "", newFull
+			if dot := strings.LastIndex(newFull, "."); dot >= 0 {
+				parent, last = newFull[:dot], newFull[dot+1:]
+			}
+			local := name.alias
+			if local == "" {
+				local = name.text
+			}
+			groups[parent] = append(groups[parent], pyImportedName(last, local, local))
+		}
+
+		if len(groups) == 0 {
+			if !moduleMoved && !(fileMoved && statement.level > 0) {
+				continue
+			}
+			written := pyModuleSpecifier(newPackage, newModule, statement.level > 0)
+			if written != string(content[statement.module.start:statement.module.end]) {
+				add(textEdit{statement.module.start, statement.module.end, written})
+			}
+			continue
+		}
+
+		// Rewrite the statement, splitting off the moved names per new package
+		lines := []string{}
+		if len(kept) > 0 {
+			lines = append(lines, "from "+pyModuleSpecifier(newPackage, newModule, statement.level > 0)+" import "+strings.Join(kept, ", "))
+		}
+		for _, parent := range sortedKeys(groups) {
+			lines = append(lines, "from "+pyModuleSpecifier(newPackage, parent, statement.level > 0)+" import "+strings.Join(groups[parent], ", "))
+		}
+		add(textEdit{statement.start, statement.end, strings.Join(lines, "\n"+statement.indent)})
+	}
+
+	if len(edits) == 0 {
+		return nil
+	}
+	list := make([]textEdit, 0, len(edits))
+	for _, edit := range edits {
+		list = append(list, edit)
+	}
+	set.edit(file, applyEdits(content, list))
+	return nil
+}
+
+// pyImportedName writes an imported name, aliased if its local name differs
+func pyImportedName(name, alias, local string) string {
+	if alias != "" && alias != name {
+		return name + " as " + alias
+	}
+	if local != name {
+		return name + " as " + local
+	}
+	return name
+}
+
+// pyResolveRelative returns the absolute name a relative import refers to.
+// Like Python, it fails when the import goes beyond the top-level package.
+func pyResolveRelative(pkg string, level int, module string) (string, bool) {
+	parts := []string{}
+	if pkg != "" {
+		parts = strings.Split(pkg, ".")
+	}
+	if level > len(parts) {
+		return "", false
+	}
+	parts = parts[:len(parts)-(level-1)]
+	if module != "" {
+		parts = append(parts, module)
+	}
+	return strings.Join(parts, "."), true
+}
+
+// pyModuleSpecifier writes the module of a from-import, relative to a
+// package if the import was relative and the module shares its top-level
+// package, and absolute otherwise
+func pyModuleSpecifier(pkg, module string, relative bool) string {
+	if !relative || pkg == "" {
+		return module
+	}
+	pkgParts, moduleParts := strings.Split(pkg, "."), strings.Split(module, ".")
+	common := 0
+	for common < len(pkgParts) && common < len(moduleParts) && pkgParts[common] == moduleParts[common] {
+		common++
+	}
+	if common == 0 {
+		return module
+	}
+	return strings.Repeat(".", len(pkgParts)-common+1) + strings.Join(moduleParts[common:], ".")
+}
+
+// pyReferences returns the offsets of the uses of a dotted module name in the
+// code of a file
+func pyReferences(content []byte, code []bool, name string) []int {
+	pattern := regexp.MustCompile(`(?:^|[^\w.])(` + regexp.QuoteMeta(name) + `)\b`)
+	offsets := []int{}
+	for _, match := range pattern.FindAllSubmatchIndex(content, -1) {
+		if code[match[2]] {
+			offsets = append(offsets, match[2])
+		}
+	}
+	return offsets
+}
+
+// pyCodeMask reports for every byte of a Python file whether it is code, as
+// opposed to a comment or a string
+func pyCodeMask(content []byte) []bool {
+	code := make([]bool, len(content))
+	for i := 0; i < len(content); {
+		c := content[i]
+		switch {
+		case c == '#':
+			for i < len(content) && content[i] != '\n' {
+				i++
+			}
+		case c == '\'' || c == '"':
+			quote := string(c)
+			if i+2 < len(content) && content[i+1] == c && content[i+2] == c {
+				quote = strings.Repeat(quote, 3)
+			}
+			i += len(quote)
+			for i < len(content) {
+				if content[i] == '\\' {
+					i += 2
+					continue
+				}
+				if strings.HasPrefix(string(content[i:min(i+len(quote), len(content))]), quote) {
+					i += len(quote)
+					break
+				}
+				if content[i] == '\n' && len(quote) == 1 {
+					break
+				}
+				i++
+			}
+		default:
+			code[i] = true
+			i++
+		}
+	}
+	return code
+}
+
+// pyImports returns the import statements of a Python file
+func pyImports(content []byte, code []bool) []pyImport {
+	imports := []pyImport{}
+	for lineStart := 0; lineStart < len(content); {
+		lineEnd := lineStart
+		for lineEnd < len(content) && content[lineEnd] != '\n' {
+			lineEnd++
+		}
+
+		start := lineStart
+		for start < lineEnd && (content[start] == ' ' || content[start] == '\t') {
+			start++
+		}
+		if start < lineEnd && code[start] {
+			if statement, ok := parsePyImport(content, start); ok {
+				statement.indent = string(content[lineStart:start])
+				imports = append(imports, statement)
+				lineEnd = statement.end
+				for lineEnd < len(content) && content[lineEnd] != '\n' {
+					lineEnd++
+				}
+			}
+		}
+		lineStart = lineEnd + 1
+	}
+	return imports
+}
+
+// parsePyImport parses an import statement starting at an offset
+func parsePyImport(content []byte, start int) (pyImport, bool) {
+	p := &pyParser{content: content, pos: start}
+	statement := pyImport{start: start}
+
+	switch {
+	case p.keyword("import"):
+		for {
+			name, ok := p.dotted()
+			if !ok {
+				return statement, false
+			}
+			statement.names = append(statement.names, p.alias(name))
+			if !p.char(',') {
+				break
+			}
+		}
+	case p.keyword("from"):
+		statement.from = true
+		p.space()
+		moduleStart := p.pos
+		for p.pos < len(content) && content[p.pos] == '.' {
+			statement.level++
+			p.pos++
+		}
+		dots := p.pos
+		module, ok := p.dotted()
+		if !ok || module.text == "import" {
+			if statement.level == 0 {
+				return statement, false
+			}
+			p.pos = dots
+			module = pyToken{end: dots}
+		}
+
+		// The module token spans the leading dots of a relative import
+		statement.module = module
+		statement.module.start = moduleStart
+		if !p.keyword("import") {
+			return statement, false
+		}
+		statement.paren = p.char('(')
+		for {
+			if p.char('*') {
+				statement.names = append(statement.names, pyName{pyToken: pyToken{p.pos - 1, p.pos, "*"}})
+				break
+			}
+			name, ok := p.identifier()
+			if !ok {
+				break
+			}
+			statement.names = append(statement.names, p.alias(name))
+			if !p.char(',') {
+				break
+			}
+		}
+		if statement.paren && !p.char(')') {
+			return statement, false
+		}
+	default:
+		return statement, false
+	}
+
+	statement.end = p.pos
+	return statement, len(statement.names) > 0
+}
+
+// pyParser reads the tokens of an import statement
+type pyParser struct {
+	content []byte
+	pos     int
+	depth   int
+}
+
+// space skips blanks, line continuations and, inside parentheses, newlines and comments
+func (p *pyParser) space() {
+	for p.pos < len(p.content) {
+		c := p.content[p.pos]
+		switch {
+		case c == ' ' || c == '\t':
+			p.pos++
+		case c == '\\' && p.pos+1 < len(p.content) && p.content[p.pos+1] == '\n':
+			p.pos += 2
+		case p.depth > 0 && (c == '\n' || c == '\r'):
+			p.pos++
+		case p.depth > 0 && c == '#':
+			for p.pos < len(p.content) && p.content[p.pos] != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// keyword consumes a keyword
+func (p *pyParser) keyword(word string) bool {
+	p.space()
+	end := p.pos + len(word)
+	if end > len(p.content) || string(p.content[p.pos:end]) != word {
+		return false
+	}
+	if end < len(p.content) && isPyNameByte(p.content[end]) {
+		return false
+	}
+	p.pos = end
+	return true
+}
+
+// char consumes a punctuation character
+func (p *pyParser) char(c byte) bool {
+	p.space()
+	if p.pos >= len(p.content) || p.content[p.pos] != c {
+		return false
+	}
+	p.pos++
+	switch c {
+	case '(':
+		p.depth++
+	case ')':
+		p.depth--
+	}
+	return true
+}
+
+// identifier consumes a name
+func (p *pyParser) identifier() (pyToken, bool) {
+	p.space()
+	start := p.pos
+	for p.pos < len(p.content) && isPyNameByte(p.content[p.pos]) {
+		p.pos++
+	}
+	if p.pos == start || (p.content[start] >= '0' && p.content[start] <= '9') {
+		return pyToken{}, false
+	}
+	return pyToken{start, p.pos, string(p.content[start:p.pos])}, true
+}
+
+// dotted consumes a dotted name
+func (p *pyParser) dotted() (pyToken, bool) {
+	token, ok := p.identifier()
+	if !ok {
+		return token, false
+	}
+	for p.pos+1 < len(p.content) && p.content[p.pos] == '.' && isPyNameByte(p.content[p.pos+1]) {
+		p.pos++
+		part, _ := p.identifier()
+		token.end = part.end
+	}
+	token.text = string(p.content[token.start:token.end])
+	return token, true
+}
+
+// alias consumes the alias of an imported name, if any
+func (p *pyParser) alias(name pyToken) pyName {
+	imported := pyName{pyToken: name}
+	save := p.pos
+	if p.keyword("as") {
+		if alias, ok := p.identifier(); ok {
+			imported.alias = alias.text
+			return imported
+		}
+	}
+	p.pos = save
+	return imported
+}
+
+// isPyNameByte reports whether a byte can be part of a Python name
+func isPyNameByte(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c >= 0x80
+}
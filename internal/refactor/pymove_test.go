@@ -0,0 +1,146 @@
+package refactor
+
+import (
+	"reflect"
+	"testing"
+)
+
+// pyImportSummary is what a test checks of a parsed import statement, with
+// every token read back from its offsets
+type pyImportSummary struct {
+	Text   string
+	Indent string
+	From   bool
+	Level  int
+	Module string
+	Names  []string
+}
+
+func TestPyImports(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []pyImportSummary
+	}{
+		{
+			name:    "plain imports",
+			content: "import os\nimport a.b as c, d\n",
+			want: []pyImportSummary{
+				{Text: "import os", Names: []string{"os"}},
+				{Text: "import a.b as c, d", Names: []string{"a.b as c", "d"}},
+			},
+		},
+		{
+			name:    "from import",
+			content: "from pkg.mod import a, b as c\n",
+			want: []pyImportSummary{
+				{Text: "from pkg.mod import a, b as c", From: true, Module: "pkg.mod", Names: []string{"a", "b as c"}},
+			},
+		},
+		{
+			name:    "relative imports",
+			content: "from . import x\nfrom ..pkg import y\nfrom ... import z\n",
+			want: []pyImportSummary{
+				{Text: "from . import x", From: true, Level: 1, Module: ".", Names: []string{"x"}},
+				{Text: "from ..pkg import y", From: true, Level: 2, Module: "..pkg", Names: []string{"y"}},
+				{Text: "from ... import z", From: true, Level: 3, Module: "...", Names: []string{"z"}},
+			},
+		},
+		{
+			name:    "parenthesized names over several lines",
+			content: "from pkg import (\n    a,\n    b as c,  # comment\n)\nimport os\n",
+			want: []pyImportSummary{
+				{Text: "from pkg import (\n    a,\n    b as c,  # comment\n)", From: true, Module: "pkg", Names: []string{"a", "b as c"}},
+				{Text: "import os", Names: []string{"os"}},
+			},
+		},
+		{
+			name:    "line continuation",
+			content: "from pkg import a, \\\n    b\n",
+			want: []pyImportSummary{
+				{Text: "from pkg import a, \\\n    b", From: true, Module: "pkg", Names: []string{"a", "b"}},
+			},
+		},
+		{
+			name:    "star import",
+			content: "from pkg import *\n",
+			want: []pyImportSummary{
+				{Text: "from pkg import *", From: true, Module: "pkg", Names: []string{"*"}},
+			},
+		},
+		{
+			name:    "indented import",
+			content: "def f():\n    import json\n    return json\n",
+			want: []pyImportSummary{
+				{Text: "import json", Indent: "    ", Names: []string{"json"}},
+			},
+		},
+		{
+			name:    "imports in comments and strings",
+			content: "# import os\nx = \"import os\"\n\"\"\"\nimport sys\n\"\"\"\n",
+			want:    []pyImportSummary{},
+		},
+		{
+			name:    "not import statements",
+			content: "important = 1\nfromage = 2\nfrom pkg import\nfrom import x\nimport 3d\n",
+			want:    []pyImportSummary{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := []byte(tt.content)
+			got := []pyImportSummary{}
+			for _, statement := range pyImports(content, pyCodeMask(content)) {
+				summary := pyImportSummary{
+					Text:   string(content[statement.start:statement.end]),
+					Indent: statement.indent,
+					From:   statement.from,
+					Level:  statement.level,
+					Module: string(content[statement.module.start:statement.module.end]),
+				}
+				for _, name := range statement.names {
+					text := string(content[name.start:name.end])
+					if name.text != text {
+						t.Errorf("name %q has offsets of %q", name.text, text)
+					}
+					if name.alias != "" {
+						text += " as " + name.alias
+					}
+					summary.Names = append(summary.Names, text)
+				}
+				got = append(got, summary)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("pyImports(%q) =\n%+v\nwant\n%+v", tt.content, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPyResolveRelative(t *testing.T) {
+	tests := []struct {
+		pkg    string
+		level  int
+		module string
+		want   string
+		ok     bool
+	}{
+		{"app.core", 1, "utils", "app.core.utils", true},
+		{"app.core", 1, "", "app.core", true},
+		{"app.core", 2, "utils", "app.utils", true},
+		{"app.core", 2, "", "app", true},
+		{"app.core", 2, "a.b", "app.a.b", true},
+		{"app", 1, "models", "app.models", true},
+		{"app.core", 3, "utils", "", false},
+		{"app.core", 3, "", "", false},
+		{"", 1, "utils", "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := pyResolveRelative(tt.pkg, tt.level, tt.module)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("pyResolveRelative(%q, %d, %q) = %q, %v, want %q, %v", tt.pkg, tt.level, tt.module, got, ok, tt.want, tt.ok)
+		}
+	}
+}
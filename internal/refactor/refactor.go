@@ -1,35 +1,51 @@
 package refactor
 
 import (
-	"bytes"
 	"fmt"
-	"go/ast"
-	"go/format"
-	"go/parser"
-	"go/token"
+	"go/types"
 	"os"
 	"path/filepath"
 	"strings"
+	"mono-mind/internal/analyzer"
 	"mono-mind/internal/logger"
-	"golang.org/x/tools/go/ast/astutil"
 )
 
 // RefactorConfig holds configuration for the refactor process
 type RefactorConfig struct {
-	DryRun   bool   `json:"dry_run"`
-	OldName  string `json:"old_name"`
-	NewName  string `json:"new_name"`
-	FilePath string `json:"file_path"`
+	DryRun     bool                `json:"dry_run"`
+	OldName    string              `json:"old_name"`
+	NewName    string              `json:"new_name"`
+	FilePath   string              `json:"file_path"`
+	OldPath    string              `json:"old_path"`
+	NewPath    string              `json:"new_path"`
+	Files      []string            `json:"files"`
+	ModulePath string              `json:"module_path"`
+	PatchFile  string              `json:"patch_file"`
+	Force      bool                `json:"force"`
+	AllowDirty bool                `json:"allow_dirty"`
+	Graph      *analyzer.RepoGraph `json:"-"`
 }
 
 // RefactorResult holds the result of a refactor operation
 type RefactorResult struct {
-	FilesChanged []string `json:"files_changed"`
-	Errors       []string `json:"errors"`
-	Duration     string   `json:"duration"`
+	FilesChanged    []string      `json:"files_changed"`
+	Issues          []RenameIssue `json:"issues,omitempty"`
+	SkippedPackages []string      `json:"skipped_packages,omitempty"`
+	Patch           string        `json:"patch"`
+	Errors          []string      `json:"errors"`
+	Duration        string        `json:"duration"`
 }
 
-// Rename performs a safe rename operation across the repository
+// Rename performs a safe rename operation across the repository. OldName
+// names the Go object to rename: a package-level name, pkg.Name,
+// pkg.Type.Method or pkg.Type.Field, or the position file:line:col of one of
+// its identifiers. References are resolved with go/types, so only the chosen
+// object is renamed, in every package of the repository. The rename is
+// checked first and refused if it would cause conflicts, shadowing, lost
+// exportedness or broken interface implementations, or if Go packages with
+// errors could not be checked, unless it is forced.
+// A named export of a JS/TS file, given as file:name or with FilePath naming
+// the file, is renamed along with its import sites.
 func Rename(config RefactorConfig) *RefactorResult {
 	logger.Info("Performing rename operation", 
 		"old_name", config.OldName, 
@@ -41,41 +57,48 @@ func Rename(config RefactorConfig) *RefactorResult {
 		Errors:       []string{},
 	}
 	
-	// If a specific file path is provided, refactor only that file
-	if config.FilePath != "" {
-		err := refactorFile(config.FilePath, config.OldName, config.NewName, config.DryRun, result)
-		if err != nil {
-			result.Errors = append(result.Errors, fmt.Sprintf("Error refactoring file %s: %v", config.FilePath, err))
-		}
+	if err := checkWorktree(config); err != nil {
+		result.Errors = append(result.Errors, fmt.Sprintf("Refusing to rename %s: %v", config.OldName, err))
+		return result
+	}
+	
+	set := newChangeSet()
+	var err error
+	if file, name, ok := jsRenameTarget(config); ok {
+		logger.Info("Renaming JS/TS export", "file", file, "export", name, "new_name", config.NewName)
+		result.Issues, err = renameJS(file, name, config.NewName, set)
 	} else {
-		// Refactor all Go files in the current directory
-		err := filepath.Walk(".", func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
-			
-			// Skip non-Go files
-			if !strings.HasSuffix(path, ".go") {
-				return nil
-			}
-			
-			// Skip vendor directories
-			if strings.Contains(path, "vendor") || strings.Contains(path, "node_modules") {
-				return filepath.SkipDir
-			}
-			
-			err = refactorFile(path, config.OldName, config.NewName, config.DryRun, result)
-			if err != nil {
-				result.Errors = append(result.Errors, fmt.Sprintf("Error refactoring file %s: %v", path, err))
-			}
-			
-			return nil
-		})
-		
-		if err != nil {
-			result.Errors = append(result.Errors, fmt.Sprintf("Error walking directory: %v", err))
+		result.Issues, err = renameGoObject(&config, set, result)
+	}
+	if err != nil {
+		result.Errors = append(result.Errors, fmt.Sprintf("Error renaming %s: %v", config.OldName, err))
+		return result
+	}
+	
+	// Refuse the rename if any check failed
+	blocking := 0
+	for _, issue := range result.Issues {
+		if issue.Blocking {
+			blocking++
+			logger.Error("Rename check failed", "kind", issue.Kind, "position", issue.Position, "issue", issue.Message)
+		} else {
+			logger.Warn("Rename check", "kind", issue.Kind, "position", issue.Position, "issue", issue.Message)
 		}
 	}
+	// A dry run still shows the changes, but fails like the rename would
+	refusal := ""
+	if blocking > 0 && !config.Force {
+		refusal = fmt.Sprintf("Refusing to rename %s: %d checks failed (use --force to rename anyway)", config.OldName, blocking)
+	}
+	if refusal != "" && !config.DryRun {
+		result.Errors = append(result.Errors, refusal)
+		return result
+	}
+	
+	applyChangeSet(set, config, result)
+	if refusal != "" {
+		result.Errors = append(result.Errors, refusal)
+	}
 	
 	logger.Info("Rename operation completed", 
 		"files_changed", len(result.FilesChanged),
@@ -84,95 +107,155 @@ func Rename(config RefactorConfig) *RefactorResult {
 	return result
 }
 
-// refactorFile refactors a single file
-func refactorFile(filePath, oldName, newName string, dryRun bool, result *RefactorResult) error {
-	// Parse the file
-	fset := token.NewFileSet()
-	file, err := parser.ParseFile(fset, filePath, nil, parser.ParseComments)
+// renameGoObject resolves the Go object a rename targets, checks the rename
+// and records its edits in a change set
+func renameGoObject(config *RefactorConfig, set *changeSet, result *RefactorResult) ([]RenameIssue, error) {
+	program, err := loadGoProgram(".")
 	if err != nil {
-		return fmt.Errorf("error parsing file: %v", err)
-	}
-	
-	// Apply the refactoring
-	changed := false
-	astutil.Apply(file, func(c *astutil.Cursor) bool {
-		// Check for identifiers that match the old name
-		if ident, ok := c.Node().(*ast.Ident); ok {
-			if ident.Name == oldName {
-				// Replace with the new name
-				ident.Name = newName
-				changed = true
-			}
-		}
-		return true
-	}, nil)
-	
-	// If no changes were made, return early
-	if !changed {
-		return nil
+		return nil, fmt.Errorf("loading Go packages: %v", err)
 	}
+	result.SkippedPackages = program.skippedPackages()
 	
-	// Add file to changed list
-	result.FilesChanged = append(result.FilesChanged, filePath)
+	obj, err := resolveRename(program, config.OldName, config.FilePath, config.NewName)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("Renaming Go object", "object", types.ObjectString(obj, nil), "new_name", config.NewName)
 	
-	// If dry run, just report the change
-	if dryRun {
-		logger.Info("Would refactor file (dry-run)", "file", filePath)
-		return nil
+	// Check the rename before changing anything
+	if config.Graph == nil {
+		if config.Graph, err = analyzer.AnalyzeRepo("."); err != nil {
+			logger.Warn("Failed to analyze repository, not reporting affected modules", "error", err)
+		}
 	}
+	refs := program.references(obj)
+	issues := append(program.skippedIssues(), checkRename(program, config.Graph, obj, config.NewName, refs)...)
 	
-	// Format and write the modified file
-	var buf bytes.Buffer
-	err = format.Node(&buf, fset, file)
+	return issues, renameGo(program, refs, config.NewName, set)
+}
+
+// applyChangeSet records the diff of a change set in the result, writes it as
+// a patch file if requested, and applies the changes unless in a dry run
+func applyChangeSet(set *changeSet, config RefactorConfig, result *RefactorResult) {
+	patch, err := set.patch()
 	if err != nil {
-		return fmt.Errorf("error formatting file: %v", err)
+		result.Errors = append(result.Errors, fmt.Sprintf("Error computing diff: %v", err))
+		return
 	}
+	result.Patch = patch
 	
-	err = os.WriteFile(filePath, buf.Bytes(), 0600)
-	if err != nil {
-		return fmt.Errorf("error writing file: %v", err)
+	if config.PatchFile != "" {
+		if err := os.WriteFile(config.PatchFile, []byte(patch), 0600); err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("Error writing patch %s: %v", config.PatchFile, err))
+			return
+		}
+		logger.Info("Wrote patch", "file", config.PatchFile)
 	}
 	
-	logger.Info("Refactored file", "file", filePath)
-	return nil
+	writeChanges(set, config, result)
 }
 
-// Move moves a file or directory to a new location
-func Move(oldPath, newPath string, dryRun bool) *RefactorResult {
+// operation describes the refactoring a configuration performs, for the journal
+func (c RefactorConfig) operation() string {
+	if c.OldName != "" {
+		return fmt.Sprintf("rename %s to %s", c.OldName, c.NewName)
+	}
+	if len(c.Files) > 0 {
+		return fmt.Sprintf("extract %s to %s", strings.Join(c.Files, ", "), c.NewPath)
+	}
+	return fmt.Sprintf("move %s to %s", c.OldPath, c.NewPath)
+}
+
+// Move moves a file or directory to a new location. Moved Go packages have
+// their imports rewritten across the repository, their package clause renamed
+// along with their directory, and local go.mod replace directives follow them.
+// Relative, workspace and tsconfig alias imports of moved JS/TS files are
+// rewritten in every file that uses them. Absolute and relative imports of
+// moved Python modules are rewritten too, and new directories get an
+// __init__.py where the moved modules need a package.
+func Move(config RefactorConfig) *RefactorResult {
+	oldPath, newPath := filepath.Clean(config.OldPath), filepath.Clean(config.NewPath)
 	logger.Info("Moving file/directory", 
 		"old_path", oldPath, 
 		"new_path", newPath,
-		"dry_run", dryRun)
+		"dry_run", config.DryRun)
 	
 	result := &RefactorResult{
 		FilesChanged: []string{},
 		Errors:       []string{},
 	}
 	
-	// If dry run, just report what would be moved
-	if dryRun {
-		logger.Info("Would move file/directory (dry-run)", "old_path", oldPath, "new_path", newPath)
-		result.FilesChanged = append(result.FilesChanged, oldPath)
+	if err := checkWorktree(config); err != nil {
+		result.Errors = append(result.Errors, fmt.Sprintf("Refusing to move %s: %v", oldPath, err))
+		return result
+	}
+	if _, err := os.Stat(oldPath); err != nil {
+		result.Errors = append(result.Errors, fmt.Sprintf("Error moving file/directory: %v", err))
+		return result
+	}
+	if _, err := os.Stat(newPath); err == nil {
+		result.Errors = append(result.Errors, fmt.Sprintf("Error moving file/directory: %s already exists", newPath))
 		return result
 	}
 	
-	// Create the destination directory if it doesn't exist
-	destDir := filepath.Dir(newPath)
-	err := os.MkdirAll(destDir, 0750)
+	// Move every file under the old path to the same place under the new one
+	set := newChangeSet()
+	err := filepath.Walk(oldPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(oldPath, path)
+		if err != nil {
+			return err
+		}
+		set.move(path, filepath.Join(newPath, rel))
+		return nil
+	})
 	if err != nil {
-		result.Errors = append(result.Errors, fmt.Sprintf("Error creating destination directory: %v", err))
+		result.Errors = append(result.Errors, fmt.Sprintf("Error walking directory: %v", err))
 		return result
 	}
 	
-	// Move the file or directory
-	err = os.Rename(oldPath, newPath)
-	if err != nil {
-		result.Errors = append(result.Errors, fmt.Sprintf("Error moving file/directory: %v", err))
+	// Rewrite the code that refers to what moved
+	if config.Graph == nil {
+		if config.Graph, err = analyzer.AnalyzeRepo("."); err != nil {
+			logger.Warn("Failed to analyze repository, scanning all files for references", "error", err)
+		}
+	}
+	if err := moveGo(config.Graph, oldPath, newPath, set); err != nil {
+		result.Errors = append(result.Errors, fmt.Sprintf("Error rewriting Go imports: %v", err))
+		return result
+	}
+	if err := moveJS(oldPath, newPath, set); err != nil {
+		result.Errors = append(result.Errors, fmt.Sprintf("Error rewriting JS/TS imports: %v", err))
+		return result
+	}
+	if err := movePython(oldPath, newPath, set); err != nil {
+		result.Errors = append(result.Errors, fmt.Sprintf("Error rewriting Python imports: %v", err))
 		return result
 	}
 	
-	result.FilesChanged = append(result.FilesChanged, oldPath)
-	logger.Info("Moved file/directory", "old_path", oldPath, "new_path", newPath)
+	applyChangeSet(set, config, result)
+	if !config.DryRun && len(result.Errors) == 0 {
+		removeEmptyDirs(oldPath)
+		logger.Info("Moved file/directory", "old_path", oldPath, "new_path", newPath)
+	}
 	
 	return result
-}
\ No newline at end of file
+}
+
+// removeEmptyDirs removes a directory tree left without files by a move
+func removeEmptyDirs(root string) {
+	dirs := []string{}
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.IsDir() {
+			dirs = append(dirs, path)
+		}
+		return nil
+	})
+	
+	// Remove the deepest directories first; non-empty ones are kept
+	for i := len(dirs) - 1; i >= 0; i-- {
+		os.Remove(dirs[i])
+	}
+}
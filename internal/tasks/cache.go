@@ -0,0 +1,134 @@
+package tasks
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+	"mono-mind/internal/logger"
+)
+
+// StateDir is the directory, relative to the repository root, where mono keeps its state
+const StateDir = ".mono"
+
+// cacheIgnoredDirs are directories never included in a task fingerprint
+var cacheIgnoredDirs = map[string]bool{
+	".git":         true,
+	StateDir:       true,
+	"node_modules": true,
+	"vendor":       true,
+	"target":       true,
+	"build":        true,
+	"dist":         true,
+	"__pycache__":  true,
+}
+
+// Cache records the fingerprint of every successful task run so unchanged tasks can be skipped
+type Cache struct {
+	Dir string
+}
+
+// cacheEntry is the on-disk record of a successful task run
+type cacheEntry struct {
+	Fingerprint string `json:"fingerprint"`
+	Command     string `json:"command"`
+	CompletedAt string `json:"completed_at"`
+}
+
+// NewCache creates a task cache stored under .mono/cache
+func NewCache() *Cache {
+	return &Cache{Dir: filepath.Join(StateDir, "cache")}
+}
+
+// Fingerprint computes the fingerprint of a task from its command, working
+// directory, environment, module sources and the fingerprints of its dependencies.
+// For hermetic tasks the environment includes the allowed inherited values.
+func Fingerprint(node *Node, depFingerprints []string) (string, error) {
+	hash := sha256.New()
+
+	fmt.Fprintf(hash, "task %s\ncommand %s\ndir %s\n", node.ID, node.Task.Command, node.Task.Dir)
+	for _, env := range node.Task.fingerprintEnv() {
+		fmt.Fprintf(hash, "env %s\n", env)
+	}
+
+	deps := append([]string{}, depFingerprints...)
+	sort.Strings(deps)
+	for _, dep := range deps {
+		fmt.Fprintf(hash, "dep %s\n", dep)
+	}
+
+	err := filepath.Walk(node.Module.Path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path != node.Module.Path && cacheIgnoredDirs[info.Name()] {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		file, err := os.Open(filepath.Clean(path))
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		fmt.Fprintf(hash, "file %s\n", filepath.ToSlash(path))
+		_, err = io.Copy(hash, file)
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to fingerprint module %s: %v", node.Module.Name, err)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// entryPath returns the path of the cache entry for a node
+func (c *Cache) entryPath(node *Node) string {
+	return filepath.Join(c.Dir, node.Task.Module, node.Task.Name+".json")
+}
+
+// Hit reports whether the node last succeeded with the same fingerprint
+func (c *Cache) Hit(node *Node, fingerprint string) bool {
+	data, err := os.ReadFile(c.entryPath(node))
+	if err != nil {
+		return false
+	}
+
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		logger.Debug("Ignoring corrupt cache entry", "task", node.ID, "error", err)
+		return false
+	}
+
+	return entry.Fingerprint == fingerprint
+}
+
+// Store records a successful run of the node
+func (c *Cache) Store(node *Node, fingerprint string) error {
+	entryPath := c.entryPath(node)
+	if err := os.MkdirAll(filepath.Dir(entryPath), 0750); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(cacheEntry{
+		Fingerprint: fingerprint,
+		Command:     node.Task.Command,
+		CompletedAt: time.Now().Format(time.RFC3339),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(entryPath, data, 0600)
+}
@@ -0,0 +1,111 @@
+package tasks
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// hermeticBaseEnv are the variables always passed to hermetic tasks, since
+// commands cannot be found or started without them
+var hermeticBaseEnv = []string{"PATH", "SYSTEMROOT", "COMSPEC", "PATHEXT"}
+
+// Environment returns the environment a task's process runs with. Non-hermetic
+// tasks inherit the full environment of mono. Hermetic tasks only see the
+// allowlisted variables and get their own clean HOME and temporary directory.
+// The task's explicit env and report file variables are applied last in both cases.
+func (t *Task) Environment() []string {
+	if !t.Hermetic {
+		return append(append(os.Environ(), t.environ()...), t.reportEnv()...)
+	}
+
+	env := t.inheritedEnv()
+	sandbox := t.SandboxDir()
+	home := filepath.Join(sandbox, "home")
+	tmp := filepath.Join(sandbox, "tmp")
+	env = append(env, "HOME="+home, "USERPROFILE="+home, "TMPDIR="+tmp, "TMP="+tmp, "TEMP="+tmp)
+
+	return append(append(env, t.environ()...), t.reportEnv()...)
+}
+
+// reportEnv tells the command where to write its test report and coverage,
+// as MONO_REPORT_FILE and MONO_COVERAGE_FILE
+func (t *Task) reportEnv() []string {
+	env := []string{}
+	if t.ReportFile != "" {
+		env = append(env, "MONO_REPORT_FILE="+t.ReportFile)
+	}
+	if t.CoverageFile != "" {
+		env = append(env, "MONO_COVERAGE_FILE="+t.CoverageFile)
+	}
+	return env
+}
+
+// inheritedEnv returns the variables of mono's environment a hermetic task is
+// allowed to see, sorted by name. Allowlist entries ending in * match a prefix.
+func (t *Task) inheritedEnv() []string {
+	allowed := append(append([]string{}, hermeticBaseEnv...), t.EnvAllow...)
+
+	env := []string{}
+	for _, entry := range os.Environ() {
+		name, _, found := strings.Cut(entry, "=")
+		if !found || name == "" {
+			continue
+		}
+		for _, pattern := range allowed {
+			if envNameMatches(pattern, name) {
+				env = append(env, entry)
+				break
+			}
+		}
+	}
+
+	sort.Strings(env)
+	return env
+}
+
+// envNameMatches reports whether a variable name matches an allowlist entry
+func envNameMatches(pattern, name string) bool {
+	if runtime.GOOS == "windows" {
+		pattern = strings.ToUpper(pattern)
+		name = strings.ToUpper(name)
+	}
+	if prefix, wildcard := strings.CutSuffix(pattern, "*"); wildcard {
+		return strings.HasPrefix(name, prefix)
+	}
+	return pattern == name
+}
+
+// fingerprintEnv returns the environment that determines a task's result:
+// the explicit env, plus the allowed inherited values for hermetic tasks
+func (t *Task) fingerprintEnv() []string {
+	if !t.Hermetic {
+		return t.environ()
+	}
+	return append(t.inheritedEnv(), t.environ()...)
+}
+
+// SandboxDir returns the directory holding a hermetic task's HOME and temporary directory
+func (t *Task) SandboxDir() string {
+	dir := filepath.Join(StateDir, "sandbox", t.Module, t.Name)
+	if absDir, err := filepath.Abs(dir); err == nil {
+		dir = absDir
+	}
+	return dir
+}
+
+// prepareSandbox recreates an empty HOME and temporary directory for a hermetic task
+func (t *Task) prepareSandbox() error {
+	sandbox := t.SandboxDir()
+	if err := os.RemoveAll(sandbox); err != nil {
+		return err
+	}
+	for _, dir := range []string{"home", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(sandbox, dir), 0750); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package tasks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mono-mind/internal/logger"
+)
+
+// ErrTimeout is returned when a task is killed because it ran out of time
+var ErrTimeout = errors.New("timed out")
+
+// Execute runs the task's command with its output written to output. The
+// process group is killed when the task timeout expires or ctx is done, and
+// the configured resource limits are set on the process on Linux.
+func (t *Task) Execute(ctx context.Context, output io.Writer) error {
+	if t.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.Timeout)
+		defer cancel()
+	}
+
+	if t.Hermetic {
+		if err := t.prepareSandbox(); err != nil {
+			return fmt.Errorf("failed to prepare sandbox: %v", err)
+		}
+	}
+
+	cmd := t.CommandContext(ctx)
+	cmd.Stdout = output
+	cmd.Stderr = output
+
+	if t.Limits != (Limits{}) {
+		if err := applyLimits(cmd, t.Limits); err != nil {
+			logger.Warn("Failed to apply resource limits", "task", t.Module+":"+t.Name, "error", err)
+		}
+	}
+
+	err := cmd.Run()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%s: %w", t.Command, ErrTimeout)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", t.Command, err)
+	}
+	return nil
+}
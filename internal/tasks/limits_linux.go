@@ -0,0 +1,86 @@
+//go:build linux
+
+package tasks
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// limitsEnv marks a re-execution of mono that sets the resource limits given
+// in its value and then executes the task command in its place
+const limitsEnv = "MONO_EXEC_LIMITS"
+
+// The limits helper takes over before main runs, so the task command starts
+// with the limits set and its process and children inherit them
+func init() {
+	if spec, ok := os.LookupEnv(limitsEnv); ok {
+		err := execWithLimits(spec, os.Args[1:])
+		fmt.Fprintf(os.Stderr, "mono: failed to run task command with resource limits: %v\n", err)
+		os.Exit(126)
+	}
+}
+
+// applyLimits makes the command run through a re-execution of mono that sets
+// the memory and CPU rlimits before executing the command itself. The
+// command and its arguments are passed through unchanged.
+func applyLimits(cmd *exec.Cmd, limits Limits) error {
+	if cmd.Err != nil {
+		return cmd.Err
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	cmd.Env = append(append([]string{}, env...), fmt.Sprintf("%s=%d:%d", limitsEnv, limits.MemoryBytes, limits.CPUSeconds))
+	cmd.Args = append([]string{self, cmd.Path}, cmd.Args...)
+	cmd.Path = self
+	return nil
+}
+
+// execWithLimits sets the limits of a spec written by applyLimits and
+// executes the command given as its path followed by its arguments. It only
+// returns on failure.
+func execWithLimits(spec string, args []string) error {
+	memory, cpu, ok := strings.Cut(spec, ":")
+	if !ok || len(args) < 2 {
+		return fmt.Errorf("invalid invocation")
+	}
+	memoryBytes, err := strconv.ParseUint(memory, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid memory limit: %v", err)
+	}
+	cpuSeconds, err := strconv.ParseUint(cpu, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid cpu limit: %v", err)
+	}
+
+	// The command must not see the marker, or it would run as the helper again
+	env := []string{}
+	for _, entry := range os.Environ() {
+		if !strings.HasPrefix(entry, limitsEnv+"=") {
+			env = append(env, entry)
+		}
+	}
+
+	if memoryBytes > 0 {
+		if err := syscall.Setrlimit(syscall.RLIMIT_AS, &syscall.Rlimit{Cur: memoryBytes, Max: memoryBytes}); err != nil {
+			return fmt.Errorf("setting the memory limit: %v", err)
+		}
+	}
+	if cpuSeconds > 0 {
+		if err := syscall.Setrlimit(syscall.RLIMIT_CPU, &syscall.Rlimit{Cur: cpuSeconds, Max: cpuSeconds}); err != nil {
+			return fmt.Errorf("setting the cpu limit: %v", err)
+		}
+	}
+	return syscall.Exec(args[0], args[1:], env)
+}
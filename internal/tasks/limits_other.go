@@ -0,0 +1,13 @@
+//go:build !linux
+
+package tasks
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// applyLimits reports that resource limits are not supported on this platform
+func applyLimits(cmd *exec.Cmd, limits Limits) error {
+	return fmt.Errorf("resource limits are only supported on Linux")
+}
@@ -0,0 +1,129 @@
+package tasks
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// prefixColors are the ANSI colors used for module prefixes on a terminal
+var prefixColors = []string{"36", "32", "33", "35", "34", "96", "92", "93", "95", "94"}
+
+// Console serializes task output so lines from parallel tasks never interleave
+type Console struct {
+	out   io.Writer
+	color bool
+	mu    sync.Mutex
+}
+
+// NewConsole creates a console writing to a file, using colors when it is a terminal
+func NewConsole(out *os.File) *Console {
+	return &Console{out: out, color: isTerminal(out) && os.Getenv("NO_COLOR") == ""}
+}
+
+// isTerminal reports whether a file is attached to a terminal
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// Prefix returns the formatted prefix for a task's output lines
+func (c *Console) Prefix(name string) string {
+	if !c.color {
+		return name + " | "
+	}
+	hash := fnv.New32a()
+	hash.Write([]byte(name))
+	color := prefixColors[hash.Sum32()%uint32(len(prefixColors))]
+	return "\x1b[" + color + "m" + name + " |\x1b[0m "
+}
+
+// writeLines writes complete lines atomically with respect to other tasks
+func (c *Console) writeLines(prefix string, lines []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, line := range lines {
+		fmt.Fprintln(c.out, prefix+line)
+	}
+}
+
+// Writer returns a writer that streams a task's output to the console line by line
+func (c *Console) Writer(name string) *PrefixWriter {
+	return &PrefixWriter{console: c, prefix: c.Prefix(name)}
+}
+
+// PrefixWriter buffers partial lines and forwards complete, prefixed lines to a console
+type PrefixWriter struct {
+	console *Console
+	prefix  string
+	buf     bytes.Buffer
+	mu      sync.Mutex
+}
+
+// Write implements io.Writer
+func (w *PrefixWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf.Write(p)
+	data := w.buf.Bytes()
+	last := bytes.LastIndexByte(data, '\n')
+	if last < 0 {
+		return len(p), nil
+	}
+
+	lines := strings.Split(string(data[:last]), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, "\r")
+	}
+	w.console.writeLines(w.prefix, lines)
+	w.buf.Next(last + 1)
+	return len(p), nil
+}
+
+// Flush writes any trailing output that did not end with a newline
+func (w *PrefixWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.buf.Len() > 0 {
+		w.console.writeLines(w.prefix, []string{strings.TrimRight(w.buf.String(), "\r")})
+		w.buf.Reset()
+	}
+}
+
+// NewRunID returns a sortable identifier for a run, used to name its log directory
+func NewRunID() string {
+	now := time.Now()
+	return fmt.Sprintf("%s-%06d", now.Format("20060102-150405"), now.Nanosecond()/1000)
+}
+
+// LogDir returns the directory holding the logs of a run
+func LogDir(runID string) string {
+	return filepath.Join(StateDir, "logs", runID)
+}
+
+// displayName returns the name shown for a node: the module name, with the
+// task name appended for tasks other than the one being run
+func displayName(node *Node, task string) string {
+	if node.Task.Name != task {
+		return node.ID
+	}
+	return node.Task.Module
+}
+
+// logFileName returns the log file name for a node
+func logFileName(node *Node, task string) string {
+	name := strings.Replace(displayName(node, task), ":", ".", 1)
+	return strings.NewReplacer("/", "_", "\\", "_").Replace(name) + ".log"
+}
@@ -0,0 +1,147 @@
+package tasks
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"mono-mind/internal/analyzer"
+)
+
+// Node is a single task invocation for one module in a plan
+type Node struct {
+	ID     string          `json:"id"`
+	Task   *Task           `json:"task"`
+	Module analyzer.Module `json:"module"`
+	Deps   []string        `json:"deps"`
+}
+
+// Plan is a task graph in dependency order: every node comes after the nodes it depends on
+type Plan struct {
+	Task    string   `json:"task"`
+	Nodes   []*Node  `json:"nodes"`
+	Missing []string `json:"missing"`
+}
+
+// NodeID returns the identifier of a module task, e.g. "api:build"
+func NodeID(module, task string) string {
+	return module + ":" + task
+}
+
+// Plan builds the task graph for running a task in the given modules, or in
+// every module when modules is empty. Dependencies declared with depends_on
+// are expanded: "^name" refers to the task in each dependency module and
+// "name" to another task of the same module.
+func (r *Resolver) Plan(graph *analyzer.RepoGraph, name string, modules []string) (*Plan, error) {
+	if len(modules) == 0 {
+		for moduleName := range graph.Modules {
+			modules = append(modules, moduleName)
+		}
+	}
+	targets := append([]string{}, modules...)
+	sort.Strings(targets)
+
+	plan := &Plan{
+		Task:    name,
+		Nodes:   []*Node{},
+		Missing: []string{},
+	}
+	nodes := map[string]*Node{}
+	visiting := map[string]bool{}
+
+	var visit func(moduleName, taskName string, required bool) (bool, error)
+	visit = func(moduleName, taskName string, required bool) (bool, error) {
+		id := NodeID(moduleName, taskName)
+		if _, done := nodes[id]; done {
+			return true, nil
+		}
+		if visiting[id] {
+			return false, fmt.Errorf("task dependency cycle detected at %s", id)
+		}
+
+		module, exists := graph.Modules[moduleName]
+		if !exists {
+			return false, fmt.Errorf("unknown module: %s", moduleName)
+		}
+
+		task, err := r.Resolve(module, taskName)
+		if errors.Is(err, ErrNoTask) && !required {
+			// Dependencies that don't declare the task are simply left out
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+
+		visiting[id] = true
+		node := &Node{ID: id, Task: task, Module: module, Deps: []string{}}
+
+		for _, dep := range task.DependsOn {
+			depModules := []string{moduleName}
+			depTask := dep
+			if strings.HasPrefix(dep, "^") {
+				depModules = graph.GetInternalDependencies(moduleName)
+				depTask = strings.TrimPrefix(dep, "^")
+			}
+
+			for _, depModule := range depModules {
+				// A task declared on the module itself must exist, upstream ones are optional
+				ok, err := visit(depModule, depTask, depModule == moduleName)
+				if err != nil {
+					return false, fmt.Errorf("%s depends on %s: %v", id, dep, err)
+				}
+				if ok {
+					node.Deps = append(node.Deps, NodeID(depModule, depTask))
+				}
+			}
+		}
+
+		visiting[id] = false
+		nodes[id] = node
+		plan.Nodes = append(plan.Nodes, node)
+		return true, nil
+	}
+
+	for _, moduleName := range targets {
+		_, err := visit(moduleName, name, true)
+		if errors.Is(err, ErrNoTask) {
+			plan.Missing = append(plan.Missing, moduleName)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return plan, nil
+}
+
+// Only restricts the plan to nodes of the given modules, dropping upstream
+// nodes of other modules. Because an affected set contains every dependent of
+// its modules, the remaining nodes keep their relative dependency order.
+func (p *Plan) Only(modules []string) {
+	keep := map[string]bool{}
+	for _, moduleName := range modules {
+		keep[moduleName] = true
+	}
+
+	kept := map[string]bool{}
+	nodes := []*Node{}
+	for _, node := range p.Nodes {
+		if keep[node.Task.Module] {
+			kept[node.ID] = true
+			nodes = append(nodes, node)
+		}
+	}
+
+	for _, node := range nodes {
+		deps := []string{}
+		for _, dep := range node.Deps {
+			if kept[dep] {
+				deps = append(deps, dep)
+			}
+		}
+		node.Deps = deps
+	}
+	p.Nodes = nodes
+}
@@ -0,0 +1,10 @@
+//go:build windows
+
+package tasks
+
+import (
+	"os/exec"
+)
+
+// setProcessGroup is a no-op on Windows, where cancellation kills the process
+func setProcessGroup(cmd *exec.Cmd) {}
@@ -0,0 +1,166 @@
+package tasks
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// CriticalPath returns the chain of dependent nodes with the longest total
+// run time, from the first node to the last, and that total. It is the lower
+// bound on the run's wall time no matter how many tasks run in parallel.
+func CriticalPath(plan *Plan, result *RunResult) ([]string, time.Duration) {
+	durations := map[string]time.Duration{}
+	for _, node := range result.Nodes {
+		durations[node.ID] = node.Duration()
+	}
+
+	// Plan nodes are in dependency order, so every dependency is resolved first
+	total := map[string]time.Duration{}
+	previous := map[string]string{}
+	end := ""
+	for _, node := range plan.Nodes {
+		longest := time.Duration(0)
+		for _, dep := range node.Deps {
+			if total[dep] > longest || previous[node.ID] == "" {
+				longest = total[dep]
+				previous[node.ID] = dep
+			}
+		}
+		total[node.ID] = longest + durations[node.ID]
+		if end == "" || total[node.ID] > total[end] {
+			end = node.ID
+		}
+	}
+
+	path := []string{}
+	for id := end; id != ""; id = previous[id] {
+		path = append([]string{id}, path...)
+	}
+	return path, total[end]
+}
+
+// traceEvent is a complete event in the Chrome trace event format
+type traceEvent struct {
+	Name      string                 `json:"name"`
+	Category  string                 `json:"cat"`
+	Phase     string                 `json:"ph"`
+	Timestamp int64                  `json:"ts"`
+	Duration  int64                  `json:"dur"`
+	PID       int                    `json:"pid"`
+	TID       int                    `json:"tid"`
+	Args      map[string]interface{} `json:"args,omitempty"`
+}
+
+// WriteTrace writes the run as a Chrome trace event file, viewable in
+// chrome://tracing or Perfetto. Each concurrently running task gets its own lane.
+func WriteTrace(path string, plan *Plan, result *RunResult) error {
+	nodes := append([]NodeResult{}, result.Nodes...)
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return nodes[i].StartedAt.Before(nodes[j].StartedAt)
+	})
+
+	critical := map[string]bool{}
+	criticalPath, _ := CriticalPath(plan, result)
+	for _, id := range criticalPath {
+		critical[id] = true
+	}
+
+	events := []traceEvent{}
+	lanes := []time.Time{}
+	for _, node := range nodes {
+		if node.StartedAt.IsZero() {
+			continue
+		}
+
+		// Reuse the first lane that is free when the node starts
+		lane := len(lanes)
+		for i, busyUntil := range lanes {
+			if !busyUntil.After(node.StartedAt) {
+				lane = i
+				break
+			}
+		}
+		if lane == len(lanes) {
+			lanes = append(lanes, node.FinishedAt)
+		} else {
+			lanes[lane] = node.FinishedAt
+		}
+
+		events = append(events, traceEvent{
+			Name:      node.ID,
+			Category:  node.Task,
+			Phase:     "X",
+			Timestamp: node.StartedAt.Sub(result.StartedAt).Microseconds(),
+			Duration:  node.Duration().Microseconds(),
+			PID:       1,
+			TID:       lane + 1,
+			Args: map[string]interface{}{
+				"module":        node.Module,
+				"status":        node.Status,
+				"attempts":      node.Attempts,
+				"queue_wait_ms": node.QueueWait().Milliseconds(),
+				"critical_path": critical[node.ID],
+			},
+		})
+	}
+
+	data, err := json.MarshalIndent(map[string]interface{}{
+		"traceEvents":     events,
+		"displayTimeUnit": "ms",
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0750); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// WriteSummary prints the slowest tasks, queue wait and critical path of a run
+func WriteSummary(w io.Writer, plan *Plan, result *RunResult) {
+	fmt.Fprintf(w, "Timing Profile (%s):\n", result.Task)
+	fmt.Fprintln(w, "=================")
+	fmt.Fprintf(w, "Wall time: %s\n", result.Duration().Round(time.Millisecond))
+
+	nodes := append([]NodeResult{}, result.Nodes...)
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return nodes[i].Duration() > nodes[j].Duration()
+	})
+
+	totalWait := time.Duration(0)
+	for _, node := range nodes {
+		totalWait += node.QueueWait()
+	}
+	fmt.Fprintf(w, "Queue wait: %s\n\n", totalWait.Round(time.Millisecond))
+
+	fmt.Fprintln(w, "Slowest tasks:")
+	for i, node := range nodes {
+		if i == 5 {
+			break
+		}
+		fmt.Fprintf(w, "  %-30s %10s  (waited %s, %s)\n", node.ID,
+			node.Duration().Round(time.Millisecond),
+			node.QueueWait().Round(time.Millisecond),
+			node.Status)
+	}
+
+	path, total := CriticalPath(plan, result)
+	durations := map[string]time.Duration{}
+	for _, node := range result.Nodes {
+		durations[node.ID] = node.Duration()
+	}
+	fmt.Fprintf(w, "\nCritical path (%s):\n", total.Round(time.Millisecond))
+	for _, id := range path {
+		fmt.Fprintf(w, "  └─ %-27s %10s\n", id, durations[id].Round(time.Millisecond))
+	}
+	fmt.Fprintln(w)
+}
@@ -0,0 +1,301 @@
+package tasks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	"mono-mind/internal/logger"
+	"mono-mind/internal/plugins"
+)
+
+// Status values reported for each node of a run
+const (
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+	StatusCached    = "cached"
+	StatusSkipped   = "skipped"
+	StatusDryRun    = "dry-run"
+)
+
+// ExecFunc executes a single node of a plan, writing the command output to
+// output. It must stop when ctx is done.
+type ExecFunc func(ctx context.Context, node *Node, output io.Writer) error
+
+// RunOptions holds configuration for running a plan
+type RunOptions struct {
+	MaxConcurrent int                    `json:"max_concurrent"`
+	DryRun        bool                   `json:"dry_run"`
+	Cache         *Cache                 `json:"-"`
+	Hooks         *plugins.PluginManager `json:"-"`
+	Exec          ExecFunc               `json:"-"`
+	Console       *Console               `json:"-"`
+	RunID         string                 `json:"run_id"`
+	Timeout       time.Duration          `json:"timeout"`
+	Context       context.Context        `json:"-"`
+}
+
+// NodeResult holds the outcome of a single node
+type NodeResult struct {
+	ID       string `json:"id"`
+	Module   string `json:"module"`
+	Task     string `json:"task"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	LogFile  string `json:"log_file,omitempty"`
+	Attempts int    `json:"attempts"`
+	TimedOut bool   `json:"timed_out"`
+
+	ReadyAt    time.Time `json:"ready_at"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+// Duration returns how long the node ran
+func (n NodeResult) Duration() time.Duration {
+	return n.FinishedAt.Sub(n.StartedAt)
+}
+
+// QueueWait returns how long the node waited for a free slot after its dependencies finished
+func (n NodeResult) QueueWait() time.Duration {
+	return n.StartedAt.Sub(n.ReadyAt)
+}
+
+// RunResult holds the result of running a plan
+type RunResult struct {
+	Task     string       `json:"task"`
+	RunID    string       `json:"run_id"`
+	LogDir   string       `json:"log_dir"`
+	Nodes    []NodeResult `json:"nodes"`
+	Errors   []string     `json:"errors"`
+	TimedOut bool         `json:"timed_out"`
+
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+// Duration returns the wall time of the run
+func (r *RunResult) Duration() time.Duration {
+	return r.FinishedAt.Sub(r.StartedAt)
+}
+
+// Run executes a plan, running independent nodes concurrently. A node only
+// starts once all of its dependencies succeeded; nodes whose dependencies
+// failed are skipped. The pre-<task> and post-<task> plugin hooks run around the plan.
+func Run(plan *Plan, opts RunOptions) *RunResult {
+	logger.Info("Starting task run", "task", plan.Task, "nodes", len(plan.Nodes))
+
+	if opts.MaxConcurrent < 1 {
+		opts.MaxConcurrent = 1
+	}
+	if opts.Exec == nil {
+		opts.Exec = execNode
+	}
+	if opts.Context == nil {
+		opts.Context = context.Background()
+	}
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		opts.Context, cancel = context.WithTimeout(opts.Context, opts.Timeout)
+		defer cancel()
+	}
+	if opts.Console == nil {
+		opts.Console = NewConsole(os.Stdout)
+	}
+	if opts.RunID == "" {
+		opts.RunID = NewRunID()
+	}
+	if opts.Hooks == nil {
+		opts.Hooks = plugins.NewPluginManager()
+		if err := opts.Hooks.LoadPluginsFromDir("plugins"); err != nil {
+			logger.Error("Failed to load plugins", "error", err)
+		}
+	}
+
+	result := &RunResult{
+		Task:      plan.Task,
+		RunID:     opts.RunID,
+		LogDir:    LogDir(opts.RunID),
+		Nodes:     make([]NodeResult, len(plan.Nodes)),
+		Errors:    []string{},
+		StartedAt: time.Now(),
+	}
+
+	if err := opts.Hooks.ExecuteHook("pre-" + plan.Task); err != nil {
+		logger.Error("Failed to execute pre-task hook", "task", plan.Task, "error", err)
+	}
+
+	var (
+		mu           sync.Mutex
+		wg           sync.WaitGroup
+		done         = make(map[string]chan struct{}, len(plan.Nodes))
+		statuses     = make(map[string]string, len(plan.Nodes))
+		fingerprints = make(map[string]string, len(plan.Nodes))
+		slots        = make(chan struct{}, opts.MaxConcurrent)
+	)
+	for _, node := range plan.Nodes {
+		done[node.ID] = make(chan struct{})
+	}
+
+	for i, node := range plan.Nodes {
+		wg.Add(1)
+		go func(i int, node *Node) {
+			defer wg.Done()
+			defer close(done[node.ID])
+
+			// Wait for every dependency to finish
+			depsOK := true
+			depFingerprints := []string{}
+			for _, dep := range node.Deps {
+				<-done[dep]
+				mu.Lock()
+				status := statuses[dep]
+				depFingerprints = append(depFingerprints, fingerprints[dep])
+				mu.Unlock()
+				if status == StatusFailed || status == StatusSkipped {
+					depsOK = false
+				}
+			}
+
+			nodeResult := NodeResult{ID: node.ID, Module: node.Task.Module, Task: node.Task.Name, ReadyAt: time.Now()}
+			nodeResult.StartedAt = nodeResult.ReadyAt
+			fingerprint := ""
+
+			switch {
+			case !depsOK:
+				nodeResult.Status = StatusSkipped
+				nodeResult.Error = "dependency failed"
+				logger.Info("Skipping task, a dependency failed", "task", node.ID)
+			case opts.DryRun:
+				nodeResult.Status = StatusDryRun
+				logger.Info("Would run task (dry-run)", "task", node.ID, "command", node.Task.Command)
+			case opts.Context.Err() != nil:
+				nodeResult.Status = StatusSkipped
+				nodeResult.Error = runStoppedReason(opts.Context)
+			default:
+				slots <- struct{}{}
+				nodeResult.StartedAt = time.Now()
+				nodeResult.LogFile = filepath.Join(result.LogDir, logFileName(node, plan.Task))
+				fingerprint = runNode(node, depFingerprints, displayName(node, plan.Task), &nodeResult, opts)
+				<-slots
+			}
+
+			nodeResult.FinishedAt = time.Now()
+			mu.Lock()
+			statuses[node.ID] = nodeResult.Status
+			fingerprints[node.ID] = fingerprint
+			result.Nodes[i] = nodeResult
+			mu.Unlock()
+		}(i, node)
+	}
+	wg.Wait()
+	result.FinishedAt = time.Now()
+
+	for _, nodeResult := range result.Nodes {
+		if nodeResult.Status == StatusFailed {
+			result.Errors = append(result.Errors, nodeResult.ID+": "+nodeResult.Error)
+		}
+	}
+	if errors.Is(opts.Context.Err(), context.DeadlineExceeded) {
+		result.TimedOut = true
+		result.Errors = append(result.Errors, fmt.Sprintf("%s run timed out after %s", plan.Task, opts.Timeout))
+	}
+
+	if err := opts.Hooks.ExecuteHook("post-" + plan.Task); err != nil {
+		logger.Error("Failed to execute post-task hook", "task", plan.Task, "error", err)
+	}
+
+	logger.Info("Task run completed", "task", plan.Task, "nodes", len(plan.Nodes), "errors", len(result.Errors))
+	return result
+}
+
+// runNode runs a single node, consulting the cache first and retrying failed
+// attempts up to the task's retry count. The output is streamed to the console
+// under the given name and written to the node's log file. It fills in the
+// node result and returns the node's fingerprint.
+func runNode(node *Node, depFingerprints []string, name string, nodeResult *NodeResult, opts RunOptions) string {
+	fingerprint := ""
+	if opts.Cache != nil {
+		// Uncached tasks are still fingerprinted so their dependents notice changes
+		var err error
+		fingerprint, err = Fingerprint(node, depFingerprints)
+		if err != nil {
+			logger.Error("Failed to fingerprint task", "task", node.ID, "error", err)
+		} else if node.Task.Cache && opts.Cache.Hit(node, fingerprint) {
+			logger.Info("Task up to date, skipping", "task", node.ID)
+			nodeResult.Status = StatusCached
+			return fingerprint
+		}
+	}
+
+	var err error
+	for attempt := 1; attempt <= node.Task.Retries+1; attempt++ {
+		nodeResult.Attempts = attempt
+		logger.Info("Running task", "task", node.ID, "attempt", attempt)
+
+		err = runWithOutput(node, name, nodeResult.LogFile, attempt, opts)
+		if err == nil || opts.Context.Err() != nil {
+			break
+		}
+		if attempt <= node.Task.Retries {
+			logger.Warn("Task failed, retrying", "task", node.ID, "attempt", attempt, "error", err)
+		}
+	}
+
+	if err != nil {
+		nodeResult.Status = StatusFailed
+		nodeResult.Error = err.Error()
+		nodeResult.TimedOut = errors.Is(err, ErrTimeout)
+		if opts.Context.Err() != nil {
+			nodeResult.Error = runStoppedReason(opts.Context)
+		}
+		logger.Error("Task failed", "task", node.ID, "log", nodeResult.LogFile, "attempts", nodeResult.Attempts, "error", nodeResult.Error)
+		return fingerprint
+	}
+
+	nodeResult.Status = StatusSucceeded
+	if opts.Cache != nil && node.Task.Cache && fingerprint != "" {
+		if err := opts.Cache.Store(node, fingerprint); err != nil {
+			logger.Error("Failed to store task cache entry", "task", node.ID, "error", err)
+		}
+	}
+	return fingerprint
+}
+
+// runStoppedReason describes why a run stopped before a node could finish
+func runStoppedReason(ctx context.Context) string {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return "run " + ErrTimeout.Error()
+	}
+	return "run cancelled"
+}
+
+// runWithOutput executes a node with its output streamed to the console under
+// the given name and written to its log file. Retries append to the log.
+func runWithOutput(node *Node, name, logFile string, attempt int, opts RunOptions) error {
+	if err := os.MkdirAll(filepath.Dir(logFile), 0750); err != nil {
+		return fmt.Errorf("failed to create log directory: %v", err)
+	}
+	file, err := os.OpenFile(filepath.Clean(logFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create log file: %v", err)
+	}
+	defer file.Close()
+	if attempt > 1 {
+		fmt.Fprintf(file, "\n--- attempt %d ---\n", attempt)
+	}
+
+	console := opts.Console.Writer(name)
+	defer console.Flush()
+
+	return opts.Exec(opts.Context, node, io.MultiWriter(console, file))
+}
+
+// execNode runs the node's command, streaming its output
+func execNode(ctx context.Context, node *Node, output io.Writer) error {
+	return node.Task.Execute(ctx, output)
+}
@@ -0,0 +1,429 @@
+package tasks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"strconv"
+	"sync"
+	"time"
+	"mono-mind/internal/analyzer"
+	"mono-mind/internal/config"
+)
+
+// ErrNoTask is returned when a module does not declare the requested task
+var ErrNoTask = errors.New("task not declared")
+
+// ErrNoSelect is returned when a task cannot run only some of its tests
+var ErrNoSelect = errors.New("task cannot select tests")
+
+// Task is a task resolved for a single module, with all inherited settings applied
+type Task struct {
+	Name      string            `json:"name"`
+	Module    string            `json:"module"`
+	Command   string            `json:"command"`
+	Dir       string            `json:"dir"`
+	Env       map[string]string `json:"env"`
+	DependsOn []string          `json:"depends_on"`
+	Cache     bool              `json:"cache"`
+	Source    string            `json:"source"`
+	Timeout   time.Duration     `json:"timeout"`
+	Retries   int               `json:"retries"`
+	Limits    Limits            `json:"limits"`
+	Hermetic  bool              `json:"hermetic"`
+	EnvAllow  []string          `json:"env_allow"`
+
+	ReportFormat string `json:"report_format"`
+	ReportFile   string `json:"report_file"`
+
+	CoverageCommand string   `json:"coverage_command"`
+	CoverageFormat  string   `json:"coverage_format"`
+	CoverageFile    string   `json:"coverage_file"`
+	MinCoverage     *float64 `json:"min_coverage,omitempty"`
+	SelectArgs      string   `json:"select_args"`
+}
+
+// Limits holds the resource limits applied to a task's process on Linux
+type Limits struct {
+	MemoryBytes uint64 `json:"memory_bytes"`
+	CPUSeconds  uint64 `json:"cpu_seconds"`
+}
+
+// Sources a task command can be resolved from
+const (
+	SourceDeclared       = "declared"
+	SourceMakefile       = "makefile"
+	SourceDefaultCommand = "default_command"
+)
+
+// makefileNames are the file names make looks for, in order
+var makefileNames = []string{"GNUmakefile", "makefile", "Makefile"}
+
+// Resolver resolves module tasks from language defaults, the root config
+// and the modules' mono.yaml manifests
+type Resolver struct {
+	config    *config.Config
+	manifests map[string]*config.ModuleConfig
+	mu        sync.Mutex
+}
+
+// NewResolver creates a new task resolver. A nil config uses the defaults.
+func NewResolver(cfg *config.Config) *Resolver {
+	if cfg == nil {
+		cfg = config.DefaultConfig()
+	}
+	return &Resolver{
+		config:    cfg,
+		manifests: make(map[string]*config.ModuleConfig),
+	}
+}
+
+// Config returns the configuration the resolver was created with
+func (r *Resolver) Config() *config.Config {
+	return r.config
+}
+
+// Resolve returns the named task for a module. Settings are inherited in order
+// from the built-in language defaults, the languages section of the root
+// config, the module's entry in the root config and finally its mono.yaml.
+func (r *Resolver) Resolve(module analyzer.Module, name string) (*Task, error) {
+	manifest, err := r.manifest(module.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	language := module.Language
+	if moduleConfig, ok := r.config.Modules[module.Name]; ok && moduleConfig.Language != "" {
+		language = moduleConfig.Language
+	}
+	if manifest != nil && manifest.Language != "" {
+		language = manifest.Language
+	}
+
+	task := &Task{
+		Name:   name,
+		Module: module.Name,
+		Env:    map[string]string{},
+		Cache:  true,
+	}
+
+	// The build section provides run-wide defaults for every task
+	layers := []config.TaskConfig{{
+		Timeout:  r.config.Build.TaskTimeout,
+		Retries:  &r.config.Build.Retries,
+		Hermetic: &r.config.Build.Hermetic,
+		EnvAllow: r.config.Build.EnvAllow,
+	}}
+	if defaults, ok := config.DefaultLanguages()[language]; ok {
+		layers = append(layers, defaults.Tasks[name])
+	}
+	if languageConfig, ok := r.config.Languages[language]; ok {
+		layers = append(layers, languageConfig.Tasks[name])
+	}
+	if moduleConfig, ok := r.config.Modules[module.Name]; ok {
+		layers = append(layers, config.TaskConfig{Env: moduleConfig.Env, EnvAllow: moduleConfig.EnvAllow, Hermetic: moduleConfig.Hermetic}, moduleConfig.Tasks[name])
+	}
+	if manifest != nil {
+		layers = append(layers, config.TaskConfig{Env: manifest.Env, EnvAllow: manifest.EnvAllow, Hermetic: manifest.Hermetic}, manifest.Tasks[name])
+	}
+
+	for _, layer := range layers {
+		if err := task.apply(layer); err != nil {
+			return nil, fmt.Errorf("invalid %s task for module %s: %v", name, module.Name, err)
+		}
+	}
+
+	task.Source = SourceDeclared
+	if task.Command == "" {
+		task.Command, task.Source = r.fallbackCommand(module, name)
+	}
+	if task.Command == "" {
+		return nil, fmt.Errorf("%w: %s for module %s", ErrNoTask, name, module.Name)
+	}
+
+	task.Dir = filepath.Join(module.Path, task.Dir)
+	task.ReportFile = task.reportPath()
+	task.CoverageFile = task.coveragePath()
+	return task, nil
+}
+
+// apply overlays a task configuration on top of the task
+func (t *Task) apply(layer config.TaskConfig) error {
+	if layer.Command != "" {
+		t.Command = layer.Command
+	}
+	if layer.Dir != "" {
+		t.Dir = layer.Dir
+	}
+	for key, value := range layer.Env {
+		t.Env[key] = value
+	}
+	if layer.DependsOn != nil {
+		t.DependsOn = layer.DependsOn
+	}
+	if layer.Cache != nil {
+		t.Cache = *layer.Cache
+	}
+	if layer.Hermetic != nil {
+		t.Hermetic = *layer.Hermetic
+	}
+	t.EnvAllow = append(t.EnvAllow, layer.EnvAllow...)
+	if layer.ReportFormat != "" {
+		t.ReportFormat = layer.ReportFormat
+	}
+	if layer.ReportFile != "" {
+		t.ReportFile = layer.ReportFile
+	}
+	if layer.CoverageCommand != "" {
+		t.CoverageCommand = layer.CoverageCommand
+	}
+	if layer.CoverageFormat != "" {
+		t.CoverageFormat = layer.CoverageFormat
+	}
+	if layer.CoverageFile != "" {
+		t.CoverageFile = layer.CoverageFile
+	}
+	if layer.SelectArgs != "" {
+		t.SelectArgs = layer.SelectArgs
+	}
+	if layer.MinCoverage != nil {
+		if *layer.MinCoverage < 0 || *layer.MinCoverage > 100 {
+			return fmt.Errorf("min_coverage must be between 0 and 100")
+		}
+		t.MinCoverage = layer.MinCoverage
+	}
+	if layer.Retries != nil {
+		if *layer.Retries < 0 {
+			return fmt.Errorf("retries must not be negative")
+		}
+		t.Retries = *layer.Retries
+	}
+	if layer.Timeout != "" {
+		timeout, err := time.ParseDuration(layer.Timeout)
+		if err != nil {
+			return fmt.Errorf("invalid timeout %q: %v", layer.Timeout, err)
+		}
+		t.Timeout = timeout
+	}
+	if layer.MemoryLimit != "" {
+		memory, err := ParseBytes(layer.MemoryLimit)
+		if err != nil {
+			return err
+		}
+		t.Limits.MemoryBytes = memory
+	}
+	if layer.CPULimit != "" {
+		cpu, err := time.ParseDuration(layer.CPULimit)
+		if err != nil || cpu < time.Second {
+			return fmt.Errorf("invalid cpu limit %q: must be a duration of at least 1s", layer.CPULimit)
+		}
+		t.Limits.CPUSeconds = uint64(cpu / time.Second)
+	}
+	return nil
+}
+
+// reportPath returns the absolute path of the report file a task writes. A
+// report_file is relative to the task directory; formats read from a file
+// default to a file under .mono/reports. Formats read from stdout have none.
+func (t *Task) reportPath() string {
+	path := t.ReportFile
+	switch {
+	case path != "":
+		path = filepath.Join(t.Dir, path)
+	case t.ReportFormat != "" && t.ReportFormat != "go-json":
+		path = filepath.Join(StateDir, "reports", t.Module, t.Name+".report")
+	default:
+		return ""
+	}
+
+	if absPath, err := filepath.Abs(path); err == nil {
+		path = absPath
+	}
+	return path
+}
+
+// Select returns a copy of the task that runs only the named tests. The
+// task's select_args are appended to its command and coverage command, with
+// MONO_TEST_PATTERN set to an anchored regular expression matching the names
+// (for go test -run and jest -t) and MONO_TEST_EXPRESSION to a pytest -k
+// expression.
+func (t *Task) Select(names []string) (*Task, error) {
+	if t.SelectArgs == "" {
+		return nil, fmt.Errorf("%w: task %s of module %s has no select_args", ErrNoSelect, t.Name, t.Module)
+	}
+
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = regexp.QuoteMeta(name)
+	}
+
+	selected := *t
+	selected.Env = map[string]string{}
+	for key, value := range t.Env {
+		selected.Env[key] = value
+	}
+	selected.Env["MONO_TEST_PATTERN"] = "^(" + strings.Join(quoted, "|") + ")$"
+	selected.Env["MONO_TEST_EXPRESSION"] = strings.Join(names, " or ")
+	selected.Command = t.Command + " " + t.SelectArgs
+	if t.CoverageCommand != "" {
+		selected.CoverageCommand = t.CoverageCommand + " " + t.SelectArgs
+	}
+	return &selected, nil
+}
+
+// coveragePath returns the absolute path of the coverage file a task writes. A
+// coverage_file is relative to the task directory and defaults to a file under
+// .mono/coverage when the task collects coverage.
+func (t *Task) coveragePath() string {
+	path := t.CoverageFile
+	switch {
+	case path != "":
+		path = filepath.Join(t.Dir, path)
+	case t.CoverageCommand != "":
+		path = filepath.Join(StateDir, "coverage", t.Module, t.Name+".out")
+	default:
+		return ""
+	}
+
+	if absPath, err := filepath.Abs(path); err == nil {
+		path = absPath
+	}
+	return path
+}
+
+// ParseBytes parses a size such as 512M, 2G or 1048576 into bytes
+func ParseBytes(size string) (uint64, error) {
+	value := strings.ToUpper(strings.TrimSpace(size))
+	value = strings.TrimSuffix(strings.TrimSuffix(value, "B"), "I")
+
+	multiplier := uint64(1)
+	switch {
+	case strings.HasSuffix(value, "K"):
+		multiplier = 1 << 10
+	case strings.HasSuffix(value, "M"):
+		multiplier = 1 << 20
+	case strings.HasSuffix(value, "G"):
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		value = value[:len(value)-1]
+	}
+
+	number, err := strconv.ParseUint(value, 10, 64)
+	if err != nil || number == 0 {
+		return 0, fmt.Errorf("invalid size %q", size)
+	}
+	return number * multiplier, nil
+}
+
+// fallbackCommand returns the command for a task the module does not declare:
+// a matching Makefile target, or for builds the configured default command.
+// The default command is only used for make when the module has a Makefile.
+func (r *Resolver) fallbackCommand(module analyzer.Module, name string) (string, string) {
+	makefile := findMakefile(module.Path)
+	if makefile != "" && hasMakeTarget(makefile, name) {
+		return "make " + name, SourceMakefile
+	}
+
+	if name == "build" {
+		defaultCommand := strings.TrimSpace(r.config.Build.DefaultCommand)
+		fields := strings.Fields(defaultCommand)
+		if len(fields) > 0 && (fields[0] != "make" || makefile != "") {
+			return defaultCommand, SourceDefaultCommand
+		}
+	}
+
+	return "", ""
+}
+
+// findMakefile returns the path of the module's Makefile, or an empty string
+func findMakefile(moduleDir string) string {
+	for _, name := range makefileNames {
+		path := filepath.Join(moduleDir, name)
+		if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+			return path
+		}
+	}
+	return ""
+}
+
+// hasMakeTarget reports whether a Makefile defines the named target
+func hasMakeTarget(makefile, target string) bool {
+	data, err := os.ReadFile(filepath.Clean(makefile))
+	if err != nil {
+		return false
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		// Recipe lines are indented and variable assignments use := or ::=
+		if strings.HasPrefix(line, "\t") || strings.HasPrefix(line, " ") {
+			continue
+		}
+		colon := strings.Index(line, ":")
+		if colon < 0 || strings.HasPrefix(line[colon:], ":=") || strings.HasPrefix(line[colon:], "::=") {
+			continue
+		}
+		for _, name := range strings.Fields(line[:colon]) {
+			if name == target {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// manifest returns the cached mono.yaml manifest for a module directory
+func (r *Resolver) manifest(moduleDir string) (*config.ModuleConfig, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if manifest, ok := r.manifests[moduleDir]; ok {
+		return manifest, nil
+	}
+
+	manifest, err := config.LoadModuleManifest(moduleDir)
+	if err != nil {
+		return nil, err
+	}
+	r.manifests[moduleDir] = manifest
+	return manifest, nil
+}
+
+// CommandContext creates the command that executes the task through the
+// system shell. The command runs in its own process group so that the whole
+// tree is killed when ctx is done.
+func (t *Task) CommandContext(ctx context.Context) *exec.Cmd {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", t.Command) // #nosec G204 -- Command comes from the repository configuration
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", t.Command) // #nosec G204 -- Command comes from the repository configuration
+	}
+	cmd.Dir = t.Dir
+	cmd.Env = t.Environment()
+	cmd.WaitDelay = 5 * time.Second
+	setProcessGroup(cmd)
+	return cmd
+}
+
+// environ returns the task environment as sorted KEY=value pairs
+func (t *Task) environ() []string {
+	env := make([]string, 0, len(t.Env))
+	for key, value := range t.Env {
+		env = append(env, key+"="+value)
+	}
+	sort.Strings(env)
+	return env
+}
+
+// String returns a short description of the task for logging
+func (t *Task) String() string {
+	return strings.TrimSpace(t.Module + ":" + t.Name + " (" + t.Command + ")")
+}
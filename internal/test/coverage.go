@@ -0,0 +1,487 @@
+package test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Coverage formats understood by ParseCoverage
+const (
+	CoverageGo        = "go-cover"
+	CoverageLCOV      = "lcov"
+	CoverageIstanbul  = "istanbul-json"
+	CoverageCobertura = "cobertura"
+)
+
+// Coverage holds the hit count of every instrumented line, by repository-relative file path
+type Coverage struct {
+	Files map[string]map[int]int `json:"files"`
+}
+
+// ModuleCoverage holds the line coverage of a single module's test run
+type ModuleCoverage struct {
+	Module       string  `json:"module"`
+	LinesFound   int     `json:"lines_found"`
+	LinesHit     int     `json:"lines_hit"`
+	Percent      float64 `json:"percent"`
+	MinCoverage  float64 `json:"min_coverage"`
+	BelowMinimum bool    `json:"below_minimum"`
+}
+
+// NewCoverage creates an empty coverage set
+func NewCoverage() *Coverage {
+	return &Coverage{Files: map[string]map[int]int{}}
+}
+
+// setMax records a line's hits within a single report, where a line can be
+// part of several blocks or statements
+func (c *Coverage) setMax(path string, line, hits int) {
+	lines := c.Files[path]
+	if lines == nil {
+		lines = map[int]int{}
+		c.Files[path] = lines
+	}
+	if current, ok := lines[line]; !ok || hits > current {
+		lines[line] = hits
+	}
+}
+
+// Merge adds the hits of another coverage set, e.g. another module's run
+func (c *Coverage) Merge(other *Coverage) {
+	for path, lines := range other.Files {
+		if c.Files[path] == nil {
+			c.Files[path] = map[int]int{}
+		}
+		for line, hits := range lines {
+			c.Files[path][line] += hits
+		}
+	}
+}
+
+// Lines returns the number of instrumented lines and of lines that were hit
+func (c *Coverage) Lines() (found, hit int) {
+	for _, lines := range c.Files {
+		for _, hits := range lines {
+			found++
+			if hits > 0 {
+				hit++
+			}
+		}
+	}
+	return found, hit
+}
+
+// Percent returns the share of instrumented lines that were hit, from 0 to 100
+func (c *Coverage) Percent() float64 {
+	found, hit := c.Lines()
+	return percent(found, hit)
+}
+
+// paths returns the covered files in sorted order
+func (c *Coverage) paths() []string {
+	paths := []string{}
+	for path := range c.Files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// ParseCoverage parses a coverage file. Relative paths in the file are
+// resolved against dir, and all paths are made relative to the repository root.
+func ParseCoverage(format string, data []byte, dir string) (*Coverage, error) {
+	switch format {
+	case CoverageGo:
+		return parseGoCoverage(data, dir)
+	case CoverageLCOV:
+		return parseLCOV(data, dir)
+	case CoverageIstanbul:
+		return parseIstanbul(data, dir)
+	case CoverageCobertura:
+		return parseCobertura(data, dir)
+	default:
+		return nil, fmt.Errorf("unknown coverage format: %s", format)
+	}
+}
+
+// parseGoCoverage parses a go test -coverprofile file, whose paths are import
+// paths that are mapped back to files through the nearest go.mod
+func parseGoCoverage(data []byte, dir string) (*Coverage, error) {
+	coverage := NewCoverage()
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	modulePath, moduleDir := goModule(absDir)
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "mode:") {
+			continue
+		}
+
+		// file.go:startLine.startCol,endLine.endCol statements count
+		fields := strings.Fields(line)
+		colon := strings.LastIndex(line, ":")
+		if len(fields) != 3 || colon < 0 {
+			return nil, fmt.Errorf("invalid coverage profile line: %s", line)
+		}
+		var startLine, startCol, endLine, endCol int
+		if _, err := fmt.Sscanf(fields[0][colon+1:], "%d.%d,%d.%d", &startLine, &startCol, &endLine, &endCol); err != nil {
+			return nil, fmt.Errorf("invalid coverage profile block: %s", fields[0])
+		}
+		hits, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid coverage profile count: %s", fields[2])
+		}
+
+		file := line[:colon]
+		if modulePath != "" && strings.HasPrefix(file, modulePath+"/") {
+			file = filepath.Join(moduleDir, filepath.FromSlash(strings.TrimPrefix(file, modulePath+"/")))
+		}
+		path := repoPath(file, dir)
+		for l := startLine; l <= endLine; l++ {
+			coverage.setMax(path, l, hits)
+		}
+	}
+	return coverage, scanner.Err()
+}
+
+// parseLCOV parses an LCOV tracefile, as written by Istanbul, nyc, c8 and Vitest
+func parseLCOV(data []byte, dir string) (*Coverage, error) {
+	coverage := NewCoverage()
+	path := ""
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "SF:"):
+			path = repoPath(strings.TrimPrefix(line, "SF:"), dir)
+			if coverage.Files[path] == nil {
+				coverage.Files[path] = map[int]int{}
+			}
+		case strings.HasPrefix(line, "DA:") && path != "":
+			fields := strings.Split(strings.TrimPrefix(line, "DA:"), ",")
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("invalid lcov line: %s", line)
+			}
+			number, err := strconv.Atoi(fields[0])
+			if err != nil {
+				return nil, fmt.Errorf("invalid lcov line: %s", line)
+			}
+			hits, err := strconv.Atoi(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid lcov line: %s", line)
+			}
+			coverage.setMax(path, number, hits)
+		case line == "end_of_record":
+			path = ""
+		}
+	}
+	return coverage, scanner.Err()
+}
+
+// istanbulFile is the coverage of one file in an Istanbul coverage-final.json
+type istanbulFile struct {
+	Path         string `json:"path"`
+	StatementMap map[string]struct {
+		Start struct {
+			Line int `json:"line"`
+		} `json:"start"`
+	} `json:"statementMap"`
+	Statements map[string]int `json:"s"`
+}
+
+// parseIstanbul parses an Istanbul JSON report (coverage-final.json), using
+// the first line of every statement
+func parseIstanbul(data []byte, dir string) (*Coverage, error) {
+	var report map[string]istanbulFile
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("invalid istanbul coverage: %v", err)
+	}
+
+	coverage := NewCoverage()
+	for key, file := range report {
+		if file.Path == "" {
+			file.Path = key
+		}
+		path := repoPath(file.Path, dir)
+		for id, statement := range file.StatementMap {
+			coverage.setMax(path, statement.Start.Line, file.Statements[id])
+		}
+	}
+	return coverage, nil
+}
+
+// coberturaReport is the subset of a Cobertura XML report read by mono
+type coberturaReport struct {
+	Sources  []string `xml:"sources>source"`
+	Packages []struct {
+		Classes []struct {
+			Filename string `xml:"filename,attr"`
+			Lines    []struct {
+				Number int `xml:"number,attr"`
+				Hits   int `xml:"hits,attr"`
+			} `xml:"lines>line"`
+		} `xml:"classes>class"`
+	} `xml:"packages>package"`
+}
+
+// parseCobertura parses a Cobertura XML report, as written by coverage.py.
+// File names are relative to the report's first source directory.
+func parseCobertura(data []byte, dir string) (*Coverage, error) {
+	var report coberturaReport
+	if err := xml.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("invalid cobertura coverage: %v", err)
+	}
+
+	base := dir
+	if len(report.Sources) > 0 && strings.TrimSpace(report.Sources[0]) != "" {
+		base = strings.TrimSpace(report.Sources[0])
+		if !filepath.IsAbs(base) {
+			base = filepath.Join(dir, base)
+		}
+	}
+
+	coverage := NewCoverage()
+	for _, pkg := range report.Packages {
+		for _, class := range pkg.Classes {
+			path := repoPath(class.Filename, base)
+			for _, line := range class.Lines {
+				coverage.setMax(path, line.Number, line.Hits)
+			}
+		}
+	}
+	return coverage, nil
+}
+
+// repoPath makes a path from a coverage file relative to the repository root
+// (the working directory). Relative paths are resolved against dir.
+func repoPath(path, dir string) string {
+	path = filepath.FromSlash(strings.TrimSpace(path))
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	if absPath, err := filepath.Abs(path); err == nil {
+		if root, err := os.Getwd(); err == nil {
+			if relPath, err := filepath.Rel(root, absPath); err == nil && !strings.HasPrefix(relPath, "..") {
+				path = relPath
+			}
+		}
+	}
+	return filepath.ToSlash(filepath.Clean(path))
+}
+
+// goModule returns the module path and directory of the go.mod nearest to dir
+func goModule(dir string) (string, string) {
+	for current := filepath.Clean(dir); ; current = filepath.Dir(current) {
+		data, err := os.ReadFile(filepath.Join(current, "go.mod"))
+		if err == nil {
+			for _, line := range strings.Split(string(data), "\n") {
+				if fields := strings.Fields(line); len(fields) >= 2 && fields[0] == "module" {
+					return strings.Trim(fields[1], `"`), current
+				}
+			}
+			return "", current
+		}
+		if filepath.Dir(current) == current {
+			return "", ""
+		}
+	}
+}
+
+// WriteLCOV writes the coverage as an LCOV tracefile
+func WriteLCOV(w io.Writer, coverage *Coverage) error {
+	var out strings.Builder
+	for _, path := range coverage.paths() {
+		lines := coverage.Files[path]
+		numbers := sortedLines(lines)
+
+		out.WriteString("TN:\n")
+		fmt.Fprintf(&out, "SF:%s\n", path)
+		hit := 0
+		for _, number := range numbers {
+			fmt.Fprintf(&out, "DA:%d,%d\n", number, lines[number])
+			if lines[number] > 0 {
+				hit++
+			}
+		}
+		fmt.Fprintf(&out, "LF:%d\nLH:%d\nend_of_record\n", len(numbers), hit)
+	}
+
+	_, err := io.WriteString(w, out.String())
+	return err
+}
+
+// coberturaOutput is the root of a written Cobertura report
+type coberturaOutput struct {
+	XMLName      xml.Name                 `xml:"coverage"`
+	LineRate     string                   `xml:"line-rate,attr"`
+	BranchRate   string                   `xml:"branch-rate,attr"`
+	LinesCovered int                      `xml:"lines-covered,attr"`
+	LinesValid   int                      `xml:"lines-valid,attr"`
+	Version      string                   `xml:"version,attr"`
+	Timestamp    int64                    `xml:"timestamp,attr"`
+	Sources      []string                 `xml:"sources>source"`
+	Packages     []coberturaPackageOutput `xml:"packages>package"`
+}
+
+// coberturaPackageOutput is a package (directory) of a written Cobertura report
+type coberturaPackageOutput struct {
+	Name       string                 `xml:"name,attr"`
+	LineRate   string                 `xml:"line-rate,attr"`
+	BranchRate string                 `xml:"branch-rate,attr"`
+	Classes    []coberturaClassOutput `xml:"classes>class"`
+}
+
+// coberturaClassOutput is a file of a written Cobertura report
+type coberturaClassOutput struct {
+	Name       string                `xml:"name,attr"`
+	Filename   string                `xml:"filename,attr"`
+	LineRate   string                `xml:"line-rate,attr"`
+	BranchRate string                `xml:"branch-rate,attr"`
+	Methods    struct{}              `xml:"methods"`
+	Lines      []coberturaLineOutput `xml:"lines>line"`
+}
+
+// coberturaLineOutput is a line of a written Cobertura report
+type coberturaLineOutput struct {
+	Number int `xml:"number,attr"`
+	Hits   int `xml:"hits,attr"`
+}
+
+// WriteCobertura writes the coverage as a Cobertura XML report with one package per directory
+func WriteCobertura(w io.Writer, coverage *Coverage) error {
+	found, hit := coverage.Lines()
+	report := coberturaOutput{
+		LineRate:     rate(found, hit),
+		BranchRate:   "0",
+		LinesCovered: hit,
+		LinesValid:   found,
+		Version:      "mono",
+		Timestamp:    time.Now().Unix(),
+		Sources:      []string{"."},
+	}
+
+	packages := map[string]int{}
+	packageLines := [][2]int{}
+	for _, path := range coverage.paths() {
+		lines := coverage.Files[path]
+		class := coberturaClassOutput{Name: path, Filename: path, BranchRate: "0"}
+		classHit := 0
+		for _, number := range sortedLines(lines) {
+			class.Lines = append(class.Lines, coberturaLineOutput{Number: number, Hits: lines[number]})
+			if lines[number] > 0 {
+				classHit++
+			}
+		}
+		class.LineRate = rate(len(lines), classHit)
+
+		name := filepath.ToSlash(filepath.Dir(filepath.FromSlash(path)))
+		index, ok := packages[name]
+		if !ok {
+			index = len(report.Packages)
+			packages[name] = index
+			report.Packages = append(report.Packages, coberturaPackageOutput{Name: name, BranchRate: "0"})
+			packageLines = append(packageLines, [2]int{})
+		}
+		report.Packages[index].Classes = append(report.Packages[index].Classes, class)
+		packageLines[index][0] += len(lines)
+		packageLines[index][1] += classHit
+	}
+	for i := range report.Packages {
+		report.Packages[i].LineRate = rate(packageLines[i][0], packageLines[i][1])
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// WriteCoverageFiles writes the merged coverage as lcov.info and cobertura.xml into dir
+func WriteCoverageFiles(dir string, coverage *Coverage) ([]string, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+
+	writers := []struct {
+		name  string
+		write func(io.Writer, *Coverage) error
+	}{
+		{"lcov.info", WriteLCOV},
+		{"cobertura.xml", WriteCobertura},
+	}
+
+	paths := []string{}
+	for _, writer := range writers {
+		path := filepath.Join(dir, writer.name)
+		var out bytes.Buffer
+		if err := writer.write(&out, coverage); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(path, out.Bytes(), 0600); err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// WriteCoverageSummary prints the coverage of each module and of the whole run
+func WriteCoverageSummary(w io.Writer, modules []ModuleCoverage, total *Coverage) {
+	fmt.Fprintln(w, "Coverage:")
+	for _, module := range modules {
+		status := ""
+		if module.MinCoverage > 0 {
+			status = fmt.Sprintf("  (minimum %.1f%%)", module.MinCoverage)
+			if module.BelowMinimum {
+				status += " BELOW MINIMUM"
+			}
+		}
+		fmt.Fprintf(w, "  %-30s %6.1f%%  %d/%d lines%s\n", module.Module, module.Percent, module.LinesHit, module.LinesFound, status)
+	}
+	found, hit := total.Lines()
+	fmt.Fprintf(w, "  %-30s %6.1f%%  %d/%d lines\n", "total", percent(found, hit), hit, found)
+}
+
+// sortedLines returns the line numbers of a file in order
+func sortedLines(lines map[int]int) []int {
+	numbers := make([]int, 0, len(lines))
+	for number := range lines {
+		numbers = append(numbers, number)
+	}
+	sort.Ints(numbers)
+	return numbers
+}
+
+// percent returns hit as a percentage of found, 100 when nothing was instrumented
+func percent(found, hit int) float64 {
+	if found == 0 {
+		return 100
+	}
+	return float64(hit) * 100 / float64(found)
+}
+
+// rate formats hit as a fraction of found, as Cobertura reports expect
+func rate(found, hit int) string {
+	return strconv.FormatFloat(percent(found, hit)/100, 'f', 4, 64)
+}
@@ -0,0 +1,183 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"mono-mind/internal/tasks"
+)
+
+// HistoryFile is where the outcomes of recent test runs are kept
+var HistoryFile = filepath.Join(tasks.StateDir, "test-history.json")
+
+// historyRuns is the number of runs kept in the history
+const historyRuns = 50
+
+// Outcomes recorded in the history. A flaky test passed only after a retry.
+const (
+	OutcomePass  = "pass"
+	OutcomeFail  = "fail"
+	OutcomeFlaky = "flaky"
+)
+
+// History holds the per-test outcomes of recent test runs, oldest first
+type History struct {
+	Runs []HistoryRun `json:"runs"`
+}
+
+// HistoryRun holds the outcomes of a single test run, keyed by test ID
+type HistoryRun struct {
+	StartedAt string                  `json:"started_at"`
+	Tests     map[string]HistoryEntry `json:"tests"`
+}
+
+// HistoryEntry is the recorded outcome of a single test
+type HistoryEntry struct {
+	Module   string        `json:"module"`
+	Outcome  string        `json:"outcome"`
+	Duration time.Duration `json:"duration"`
+}
+
+// FlakyTest summarizes how often a test was flaky over recent runs
+type FlakyTest struct {
+	ID       string  `json:"id"`
+	Module   string  `json:"module"`
+	Runs     int     `json:"runs"`
+	Flaky    int     `json:"flaky"`
+	Failures int     `json:"failures"`
+	Rate     float64 `json:"rate"`
+}
+
+// LoadHistory reads the test history, returning an empty history if there is none
+func LoadHistory(path string) (*History, error) {
+	history := &History{Runs: []HistoryRun{}}
+
+	data, err := os.ReadFile(filepath.Clean(path))
+	if os.IsNotExist(err) {
+		return history, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, history); err != nil {
+		return nil, fmt.Errorf("invalid test history %s: %v", path, err)
+	}
+	return history, nil
+}
+
+// Record adds the outcomes of a test run, dropping the oldest runs beyond the limit.
+// Skipped tests are not recorded.
+func (h *History) Record(startedAt time.Time, cases []TestCase) {
+	run := HistoryRun{StartedAt: startedAt.Format(time.RFC3339), Tests: map[string]HistoryEntry{}}
+	for _, testCase := range cases {
+		outcome := OutcomePass
+		switch {
+		case testCase.Status == StatusSkip:
+			continue
+		case testCase.Status == StatusFail:
+			outcome = OutcomeFail
+		case testCase.Flaky:
+			outcome = OutcomeFlaky
+		}
+		run.Tests[testCase.ID()] = HistoryEntry{Module: testCase.Module, Outcome: outcome, Duration: testCase.Duration}
+	}
+
+	h.Runs = append(h.Runs, run)
+	if len(h.Runs) > historyRuns {
+		h.Runs = h.Runs[len(h.Runs)-historyRuns:]
+	}
+}
+
+// Save writes the test history
+func (h *History) Save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(h, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// FlakyTests returns the tests that were flaky in the most recent runs, most
+// flaky first. The rate is the share of the runs a test ran in where it was flaky.
+func (h *History) FlakyTests(runs int) []FlakyTest {
+	recent := h.Runs
+	if runs > 0 && len(recent) > runs {
+		recent = recent[len(recent)-runs:]
+	}
+
+	stats := map[string]*FlakyTest{}
+	for _, run := range recent {
+		for id, entry := range run.Tests {
+			stat, ok := stats[id]
+			if !ok {
+				stat = &FlakyTest{ID: id, Module: entry.Module}
+				stats[id] = stat
+			}
+			stat.Runs++
+			switch entry.Outcome {
+			case OutcomeFlaky:
+				stat.Flaky++
+			case OutcomeFail:
+				stat.Failures++
+			}
+		}
+	}
+
+	flaky := []FlakyTest{}
+	for _, stat := range stats {
+		if stat.Flaky == 0 {
+			continue
+		}
+		stat.Rate = float64(stat.Flaky) / float64(stat.Runs)
+		flaky = append(flaky, *stat)
+	}
+	sort.Slice(flaky, func(i, j int) bool {
+		if flaky[i].Rate != flaky[j].Rate {
+			return flaky[i].Rate > flaky[j].Rate
+		}
+		return flaky[i].ID < flaky[j].ID
+	})
+	return flaky
+}
+
+// WriteFlakyReport prints the flaky tests with their flakiness rates
+func WriteFlakyReport(w io.Writer, flaky []FlakyTest, runs int) {
+	fmt.Fprintf(w, "Flaky Tests (last %d runs):\n", runs)
+	fmt.Fprintln(w, "=================")
+	if len(flaky) == 0 {
+		fmt.Fprintln(w, "No flaky tests")
+		return
+	}
+
+	for _, test := range flaky {
+		fmt.Fprintf(w, "  %5.1f%%  %-50s flaky %d/%d, failed %d\n",
+			test.Rate*100, test.ID, test.Flaky, test.Runs, test.Failures)
+	}
+}
+
+// Quarantined reports whether a test matches one of the quarantine patterns.
+// A pattern matches the test ID or the bare test name; * matches any characters.
+func Quarantined(testCase TestCase, patterns []string) bool {
+	for _, pattern := range patterns {
+		expr := "^" + strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*") + "$"
+		matcher, err := regexp.Compile(expr)
+		if err != nil {
+			continue
+		}
+		if matcher.MatchString(testCase.ID()) || matcher.MatchString(testCase.Name) {
+			return true
+		}
+	}
+	return false
+}
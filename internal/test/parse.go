@@ -0,0 +1,302 @@
+package test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Test case statuses
+const (
+	StatusPass = "pass"
+	StatusFail = "fail"
+	StatusSkip = "skip"
+)
+
+// Report formats understood by ParseReport
+const (
+	FormatGoJSON   = "go-json"
+	FormatJestJSON = "jest-json"
+	FormatJUnit    = "junit"
+)
+
+// TestCase holds the outcome of a single test
+type TestCase struct {
+	Module      string        `json:"module"`
+	Package     string        `json:"package"`
+	Name        string        `json:"name"`
+	Status      string        `json:"status"`
+	Duration    time.Duration `json:"duration"`
+	Output      string        `json:"output,omitempty"`
+	Attempts    int           `json:"attempts"`
+	Flaky       bool          `json:"flaky,omitempty"`
+	Quarantined bool          `json:"quarantined,omitempty"`
+}
+
+// ID returns the identifier of a test that is stable across runs
+func (c TestCase) ID() string {
+	return suiteName(c) + "::" + c.Name
+}
+
+// ParseReport parses a test report in one of the supported formats into test cases
+func ParseReport(format string, data []byte) ([]TestCase, error) {
+	switch format {
+	case FormatGoJSON:
+		return parseGoTestJSON(data)
+	case FormatJestJSON:
+		return parseJestJSON(data)
+	case FormatJUnit:
+		return parseJUnitXML(data)
+	default:
+		return nil, fmt.Errorf("unknown test report format: %s", format)
+	}
+}
+
+// goTestEvent is a single event of go test -json (see go doc test2json)
+type goTestEvent struct {
+	Action  string  `json:"Action"`
+	Package string  `json:"Package"`
+	Test    string  `json:"Test"`
+	Elapsed float64 `json:"Elapsed"`
+	Output  string  `json:"Output"`
+}
+
+// parseGoTestJSON parses the output of go test -json. Lines that are not JSON
+// events, such as build errors printed to stderr, are ignored. A package that
+// fails without any failing test (e.g. it does not compile) is reported as a
+// failed case named after the package.
+func parseGoTestJSON(data []byte) ([]TestCase, error) {
+	cases := []TestCase{}
+	output := map[string]*strings.Builder{}
+	failedTests := map[string]bool{}
+	events := 0
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 || line[0] != '{' {
+			continue
+		}
+
+		var event goTestEvent
+		if err := json.Unmarshal(line, &event); err != nil {
+			continue
+		}
+		events++
+
+		key := event.Package + "\x00" + event.Test
+		switch event.Action {
+		case "output":
+			if output[key] == nil {
+				output[key] = &strings.Builder{}
+			}
+			output[key].WriteString(event.Output)
+		case "pass", "fail", "skip":
+			if event.Test == "" {
+				// Package result: only reported when it failed on its own
+				if event.Action == "fail" && !failedTests[event.Package] {
+					cases = append(cases, TestCase{
+						Package:  event.Package,
+						Name:     event.Package,
+						Status:   StatusFail,
+						Duration: seconds(event.Elapsed),
+						Output:   builderString(output[key]),
+					})
+				}
+				continue
+			}
+
+			testCase := TestCase{
+				Package:  event.Package,
+				Name:     event.Test,
+				Status:   map[string]string{"pass": StatusPass, "fail": StatusFail, "skip": StatusSkip}[event.Action],
+				Duration: seconds(event.Elapsed),
+			}
+			if event.Action == "fail" {
+				failedTests[event.Package] = true
+				testCase.Output = builderString(output[key])
+			}
+			cases = append(cases, testCase)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if events == 0 {
+		return nil, fmt.Errorf("no go test -json events found")
+	}
+
+	return cases, nil
+}
+
+// jestReport is the JSON report written by Jest --json and Vitest --reporter=json
+type jestReport struct {
+	TestResults []struct {
+		Name             string `json:"name"`
+		Message          string `json:"message"`
+		Status           string `json:"status"`
+		AssertionResults []struct {
+			AncestorTitles  []string `json:"ancestorTitles"`
+			FullName        string   `json:"fullName"`
+			Title           string   `json:"title"`
+			Status          string   `json:"status"`
+			Duration        *float64 `json:"duration"`
+			FailureMessages []string `json:"failureMessages"`
+		} `json:"assertionResults"`
+	} `json:"testResults"`
+}
+
+// parseJestJSON parses a Jest or Vitest JSON report. A test file that fails
+// without any test results (e.g. a syntax error) is reported as a failed case.
+func parseJestJSON(data []byte) ([]TestCase, error) {
+	var report jestReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("invalid jest report: %v", err)
+	}
+
+	cases := []TestCase{}
+	for _, file := range report.TestResults {
+		if len(file.AssertionResults) == 0 && file.Status == "failed" {
+			cases = append(cases, TestCase{Package: file.Name, Name: file.Name, Status: StatusFail, Output: file.Message})
+			continue
+		}
+
+		for _, assertion := range file.AssertionResults {
+			name := assertion.FullName
+			if name == "" {
+				name = strings.Join(append(append([]string{}, assertion.AncestorTitles...), assertion.Title), " ")
+			}
+
+			testCase := TestCase{Package: file.Name, Name: name}
+			switch assertion.Status {
+			case "passed":
+				testCase.Status = StatusPass
+			case "failed":
+				testCase.Status = StatusFail
+				testCase.Output = strings.Join(assertion.FailureMessages, "\n")
+			default:
+				// pending, skipped, todo and disabled
+				testCase.Status = StatusSkip
+			}
+			if assertion.Duration != nil {
+				testCase.Duration = time.Duration(*assertion.Duration * float64(time.Millisecond))
+			}
+			cases = append(cases, testCase)
+		}
+	}
+
+	return cases, nil
+}
+
+// junitFailure is a failure or error element of a JUnit test case
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// junitTestCase is a testcase element of a JUnit report
+type junitTestCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure"`
+	Error     *junitFailure `xml:"error"`
+	Skipped   *struct{}     `xml:"skipped"`
+	SystemOut string        `xml:"system-out"`
+}
+
+// junitTestSuite is a testsuite element, which may be nested
+type junitTestSuite struct {
+	Name      string           `xml:"name,attr"`
+	TestCases []junitTestCase  `xml:"testcase"`
+	Suites    []junitTestSuite `xml:"testsuite"`
+}
+
+// parseJUnitXML parses a JUnit XML report, as written by pytest --junitxml,
+// whose root is either <testsuites> or a single <testsuite>
+func parseJUnitXML(data []byte) ([]TestCase, error) {
+	var root struct {
+		XMLName xml.Name
+		junitTestSuite
+	}
+	if err := xml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("invalid junit report: %v", err)
+	}
+
+	cases := []TestCase{}
+	var collect func(suite junitTestSuite)
+	collect = func(suite junitTestSuite) {
+		for _, junitCase := range suite.TestCases {
+			pkg := junitCase.ClassName
+			if pkg == "" {
+				pkg = suite.Name
+			}
+
+			testCase := TestCase{Package: pkg, Name: junitCase.Name, Status: StatusPass}
+			if elapsed, err := parseSeconds(junitCase.Time); err == nil {
+				testCase.Duration = elapsed
+			}
+
+			failure := junitCase.Failure
+			if failure == nil {
+				failure = junitCase.Error
+			}
+			switch {
+			case failure != nil:
+				testCase.Status = StatusFail
+				testCase.Output = strings.TrimSpace(strings.Join([]string{failure.Message, failure.Text}, "\n"))
+			case junitCase.Skipped != nil:
+				testCase.Status = StatusSkip
+			}
+			cases = append(cases, testCase)
+		}
+		for _, nested := range suite.Suites {
+			collect(nested)
+		}
+	}
+	collect(root.junitTestSuite)
+
+	return cases, nil
+}
+
+// sortCases orders test cases by module, package and name
+func sortCases(cases []TestCase) {
+	sort.SliceStable(cases, func(i, j int) bool {
+		if cases[i].Module != cases[j].Module {
+			return cases[i].Module < cases[j].Module
+		}
+		if cases[i].Package != cases[j].Package {
+			return cases[i].Package < cases[j].Package
+		}
+		return cases[i].Name < cases[j].Name
+	})
+}
+
+// seconds converts fractional seconds to a duration
+func seconds(value float64) time.Duration {
+	return time.Duration(value * float64(time.Second))
+}
+
+// parseSeconds parses a fractional number of seconds, as used by JUnit reports
+func parseSeconds(value string) (time.Duration, error) {
+	var secs float64
+	if _, err := fmt.Sscanf(strings.ReplaceAll(value, ",", ""), "%g", &secs); err != nil {
+		return 0, err
+	}
+	return seconds(secs), nil
+}
+
+// builderString returns the contents of a possibly nil builder
+func builderString(builder *strings.Builder) string {
+	if builder == nil {
+		return ""
+	}
+	return builder.String()
+}
@@ -0,0 +1,143 @@
+package test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseReport(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		data    string
+		want    []TestCase
+		wantErr bool
+	}{
+		{
+			name:   "go test -json",
+			format: FormatGoJSON,
+			data: `{"Action":"run","Package":"example.com/m/lib","Test":"TestA"}
+{"Action":"output","Package":"example.com/m/lib","Test":"TestA","Output":"=== RUN   TestA\n"}
+{"Action":"pass","Package":"example.com/m/lib","Test":"TestA","Elapsed":0.5}
+{"Action":"run","Package":"example.com/m/lib","Test":"TestB"}
+{"Action":"output","Package":"example.com/m/lib","Test":"TestB","Output":"    lib_test.go:9: boom\n"}
+{"Action":"fail","Package":"example.com/m/lib","Test":"TestB","Elapsed":1}
+{"Action":"skip","Package":"example.com/m/lib","Test":"TestC"}
+{"Action":"fail","Package":"example.com/m/lib","Elapsed":1.5}
+`,
+			want: []TestCase{
+				{Package: "example.com/m/lib", Name: "TestA", Status: StatusPass, Duration: 500 * time.Millisecond},
+				{Package: "example.com/m/lib", Name: "TestB", Status: StatusFail, Duration: time.Second, Output: "    lib_test.go:9: boom\n"},
+				{Package: "example.com/m/lib", Name: "TestC", Status: StatusSkip},
+			},
+		},
+		{
+			name:   "go test -json with a build failure",
+			format: FormatGoJSON,
+			data: `# example.com/m/lib
+lib/lib.go:3:1: syntax error
+{"Action":"output","Package":"example.com/m/lib","Output":"FAIL\texample.com/m/lib [build failed]\n"}
+{"Action":"fail","Package":"example.com/m/lib","Elapsed":0}
+`,
+			want: []TestCase{
+				{Package: "example.com/m/lib", Name: "example.com/m/lib", Status: StatusFail, Output: "FAIL\texample.com/m/lib [build failed]\n"},
+			},
+		},
+		{
+			name:    "go test without -json",
+			format:  FormatGoJSON,
+			data:    "ok  \texample.com/m/lib\t0.01s\n",
+			wantErr: true,
+		},
+		{
+			name:   "jest",
+			format: FormatJestJSON,
+			data: `{"testResults":[{"name":"/repo/src/a.test.js","status":"failed","assertionResults":[
+  {"ancestorTitles":["math"],"fullName":"math adds","title":"adds","status":"passed","duration":12},
+  {"ancestorTitles":["math"],"title":"divides","status":"failed","duration":3,"failureMessages":["expected 1","received 2"]},
+  {"ancestorTitles":[],"fullName":"later","title":"later","status":"pending","duration":null}
+]}]}`,
+			want: []TestCase{
+				{Package: "/repo/src/a.test.js", Name: "math adds", Status: StatusPass, Duration: 12 * time.Millisecond},
+				{Package: "/repo/src/a.test.js", Name: "math divides", Status: StatusFail, Duration: 3 * time.Millisecond, Output: "expected 1\nreceived 2"},
+				{Package: "/repo/src/a.test.js", Name: "later", Status: StatusSkip},
+			},
+		},
+		{
+			name:   "jest with a test file that does not load",
+			format: FormatJestJSON,
+			data:   `{"testResults":[{"name":"/repo/src/b.test.ts","status":"failed","message":"SyntaxError: Unexpected token","assertionResults":[]}]}`,
+			want: []TestCase{
+				{Package: "/repo/src/b.test.ts", Name: "/repo/src/b.test.ts", Status: StatusFail, Output: "SyntaxError: Unexpected token"},
+			},
+		},
+		{
+			name:    "jest with invalid JSON",
+			format:  FormatJestJSON,
+			data:    "PASS src/a.test.js",
+			wantErr: true,
+		},
+		{
+			name:   "junit with nested suites",
+			format: FormatJUnit,
+			data: `<?xml version="1.0" encoding="utf-8"?>
+<testsuites>
+  <testsuite name="pytest">
+    <testcase classname="tests.test_math" name="test_add" time="0.25"/>
+    <testcase classname="tests.test_math" name="test_div" time="1,000.5">
+      <failure message="ZeroDivisionError">Traceback</failure>
+    </testcase>
+    <testsuite name="nested">
+      <testcase name="test_error" time="0"><error message="fixture failed"/></testcase>
+      <testcase classname="tests.test_io" name="test_skip"><skipped/></testcase>
+    </testsuite>
+  </testsuite>
+</testsuites>`,
+			want: []TestCase{
+				{Package: "tests.test_math", Name: "test_add", Status: StatusPass, Duration: 250 * time.Millisecond},
+				{Package: "tests.test_math", Name: "test_div", Status: StatusFail, Duration: 1000500 * time.Millisecond, Output: "ZeroDivisionError\nTraceback"},
+				{Package: "nested", Name: "test_error", Status: StatusFail, Output: "fixture failed"},
+				{Package: "tests.test_io", Name: "test_skip", Status: StatusSkip},
+			},
+		},
+		{
+			name:   "junit with a single suite",
+			format: FormatJUnit,
+			data:   `<testsuite name="suite"><testcase name="test_one" time="2"/></testsuite>`,
+			want: []TestCase{
+				{Package: "suite", Name: "test_one", Status: StatusPass, Duration: 2 * time.Second},
+			},
+		},
+		{
+			name:    "junit with invalid XML",
+			format:  FormatJUnit,
+			data:    "<testsuite",
+			wantErr: true,
+		},
+		{
+			name:    "unknown format",
+			format:  "tap",
+			data:    "ok 1",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseReport(tt.format, []byte(tt.data))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseReport(%s) = %+v, want an error", tt.format, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseReport(%s) =\n%+v\nwant\n%+v", tt.format, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,199 @@
+package test
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Report output formats written by WriteReportFile
+const (
+	OutputJUnit = "junit"
+	OutputTAP   = "tap"
+)
+
+// junitReport is the root <testsuites> element of a JUnit XML report
+type junitReport struct {
+	XMLName  xml.Name           `xml:"testsuites"`
+	Name     string             `xml:"name,attr"`
+	Tests    int                `xml:"tests,attr"`
+	Failures int                `xml:"failures,attr"`
+	Skipped  int                `xml:"skipped,attr"`
+	Time     string             `xml:"time,attr"`
+	Suites   []junitSuiteOutput `xml:"testsuite"`
+}
+
+// junitSuiteOutput is a <testsuite> element, one per module package
+type junitSuiteOutput struct {
+	Name     string            `xml:"name,attr"`
+	Tests    int               `xml:"tests,attr"`
+	Failures int               `xml:"failures,attr"`
+	Errors   int               `xml:"errors,attr"`
+	Skipped  int               `xml:"skipped,attr"`
+	Time     string            `xml:"time,attr"`
+	Cases    []junitCaseOutput `xml:"testcase"`
+}
+
+// junitCaseOutput is a <testcase> element
+type junitCaseOutput struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *struct{}     `xml:"skipped,omitempty"`
+}
+
+// suiteName returns the module-qualified suite name of a test case
+func suiteName(testCase TestCase) string {
+	if testCase.Package == "" || testCase.Package == testCase.Module {
+		return testCase.Module
+	}
+	return testCase.Module + "/" + testCase.Package
+}
+
+// WriteJUnit writes the test cases of a result as a single JUnit XML report
+// with one test suite per module and package
+func WriteJUnit(w io.Writer, result *TestResult) error {
+	report := junitReport{Name: "mono"}
+	suites := map[string]int{}
+	suiteTimes := []time.Duration{}
+	total := time.Duration(0)
+
+	cases := append([]TestCase{}, result.Cases...)
+	sortCases(cases)
+	for _, testCase := range cases {
+		name := suiteName(testCase)
+		index, ok := suites[name]
+		if !ok {
+			index = len(report.Suites)
+			suites[name] = index
+			report.Suites = append(report.Suites, junitSuiteOutput{Name: name})
+			suiteTimes = append(suiteTimes, 0)
+		}
+		suite := &report.Suites[index]
+
+		output := junitCaseOutput{ClassName: name, Name: testCase.Name, Time: formatSeconds(testCase.Duration)}
+		switch testCase.Status {
+		case StatusFail:
+			output.Failure = &junitFailure{Message: failureMessage(testCase.Output), Type: "failure", Text: testCase.Output}
+			suite.Failures++
+			report.Failures++
+		case StatusSkip:
+			output.Skipped = &struct{}{}
+			suite.Skipped++
+			report.Skipped++
+		}
+
+		suite.Cases = append(suite.Cases, output)
+		suite.Tests++
+		report.Tests++
+		suiteTimes[index] += testCase.Duration
+		total += testCase.Duration
+	}
+
+	for i := range report.Suites {
+		report.Suites[i].Time = formatSeconds(suiteTimes[i])
+	}
+	report.Time = formatSeconds(total)
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// WriteTAP writes the test cases of a result as a TAP version 13 stream.
+// Failures carry their message and output in a YAML diagnostic block.
+func WriteTAP(w io.Writer, result *TestResult) error {
+	cases := append([]TestCase{}, result.Cases...)
+	sortCases(cases)
+
+	var out strings.Builder
+	out.WriteString("TAP version 13\n")
+	fmt.Fprintf(&out, "1..%d\n", len(cases))
+	for i, testCase := range cases {
+		description := suiteName(testCase) + " " + testCase.Name
+		switch testCase.Status {
+		case StatusFail:
+			fmt.Fprintf(&out, "not ok %d - %s\n", i+1, tapEscape(description))
+			out.WriteString("  ---\n")
+			fmt.Fprintf(&out, "  message: %q\n", failureMessage(testCase.Output))
+			fmt.Fprintf(&out, "  duration_ms: %d\n", testCase.Duration.Milliseconds())
+			if testCase.Output != "" {
+				out.WriteString("  output: |\n")
+				for _, line := range strings.Split(strings.TrimRight(testCase.Output, "\n"), "\n") {
+					out.WriteString("    " + line + "\n")
+				}
+			}
+			out.WriteString("  ...\n")
+		case StatusSkip:
+			fmt.Fprintf(&out, "ok %d - %s # SKIP\n", i+1, tapEscape(description))
+		default:
+			fmt.Fprintf(&out, "ok %d - %s\n", i+1, tapEscape(description))
+		}
+	}
+
+	_, err := io.WriteString(w, out.String())
+	return err
+}
+
+// WriteReportFile writes the result to a file in the junit or tap format
+func WriteReportFile(path, format string, result *TestResult) error {
+	var write func(io.Writer, *TestResult) error
+	switch format {
+	case OutputJUnit:
+		write = WriteJUnit
+	case OutputTAP:
+		write = WriteTAP
+	default:
+		return fmt.Errorf("unknown report format: %s", format)
+	}
+
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0750); err != nil {
+			return err
+		}
+	}
+
+	file, err := os.OpenFile(filepath.Clean(path), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if err := write(file, result); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// formatSeconds formats a duration as fractional seconds, as JUnit reports expect
+func formatSeconds(duration time.Duration) string {
+	return fmt.Sprintf("%.3f", duration.Seconds())
+}
+
+// failureMessage returns the first line of a failed test's output, skipping
+// the === RUN, === PAUSE and === CONT lines printed by go test
+func failureMessage(output string) string {
+	for _, line := range strings.Split(output, "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "=== ") {
+			return line
+		}
+	}
+	return ""
+}
+
+// tapEscape escapes the characters with a meaning in a TAP test line
+func tapEscape(description string) string {
+	description = strings.ReplaceAll(description, "\\", "\\\\")
+	return strings.ReplaceAll(description, "#", "\\#")
+}
@@ -0,0 +1,184 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"mono-mind/internal/analyzer"
+	"mono-mind/internal/logger"
+)
+
+// Shard identifies one of Total partitions of the test targets, numbered from 1
+type Shard struct {
+	Index int `json:"index"`
+	Total int `json:"total"`
+}
+
+// ParseShard parses a shard given as index/total, such as 3/8
+func ParseShard(spec string) (Shard, error) {
+	indexText, totalText, found := strings.Cut(spec, "/")
+	if !found {
+		return Shard{}, fmt.Errorf("invalid shard %q: expected index/total", spec)
+	}
+
+	index, err := strconv.Atoi(strings.TrimSpace(indexText))
+	if err != nil {
+		return Shard{}, fmt.Errorf("invalid shard index %q", indexText)
+	}
+	total, err := strconv.Atoi(strings.TrimSpace(totalText))
+	if err != nil {
+		return Shard{}, fmt.Errorf("invalid shard total %q", totalText)
+	}
+	if total < 1 || index < 1 || index > total {
+		return Shard{}, fmt.Errorf("invalid shard %q: index must be between 1 and the total", spec)
+	}
+
+	return Shard{Index: index, Total: total}, nil
+}
+
+// String returns the shard as index/total
+func (s Shard) String() string {
+	return fmt.Sprintf("%d/%d", s.Index, s.Total)
+}
+
+// ModuleDurations returns the test time of each module in the most recent run
+// that tested it
+func (h *History) ModuleDurations() map[string]time.Duration {
+	durations := map[string]time.Duration{}
+	for i := len(h.Runs) - 1; i >= 0; i-- {
+		run := map[string]time.Duration{}
+		for _, entry := range h.Runs[i].Tests {
+			run[entry.Module] += entry.Duration
+		}
+		for module, duration := range run {
+			if _, seen := durations[module]; !seen {
+				durations[module] = duration
+			}
+		}
+	}
+	return durations
+}
+
+// LoadShardDurations reads the module test times that weight the shards from
+// a file shared by every shard: either a test history, whose most recent run
+// of each module is used, or a JSON object of module names to seconds
+func LoadShardDurations(path string) (map[string]time.Duration, error) {
+	data, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("invalid shard durations %s: %v", path, err)
+	}
+	if _, isHistory := fields["runs"]; isHistory {
+		history, err := LoadHistory(path)
+		if err != nil {
+			return nil, err
+		}
+		return history.ModuleDurations(), nil
+	}
+
+	seconds := map[string]float64{}
+	if err := json.Unmarshal(data, &seconds); err != nil {
+		return nil, fmt.Errorf("invalid shard durations %s: %v", path, err)
+	}
+	durations := map[string]time.Duration{}
+	for module, value := range seconds {
+		durations[module] = time.Duration(value * float64(time.Second))
+	}
+	return durations, nil
+}
+
+// ShardModules returns the modules of a shard. Modules are weighted by their
+// test time in the given durations, which must be the same on every shard;
+// modules without a duration are estimated from their number of test files.
+// The heaviest modules are assigned first, each to the least loaded shard, and
+// ties are broken by name, so every shard computes the same partition.
+func ShardModules(graph *analyzer.RepoGraph, modules []string, durations map[string]time.Duration, shard Shard) []string {
+	// Estimate unknown modules with the average time per test file of known ones
+	perFile := time.Second
+	knownTime, knownFiles := time.Duration(0), 0
+	for _, moduleName := range modules {
+		if duration, ok := durations[moduleName]; ok && duration > 0 {
+			knownTime += duration
+			knownFiles += testFileCount(graph.Modules[moduleName])
+		}
+	}
+	if knownFiles > 0 {
+		perFile = knownTime / time.Duration(knownFiles)
+	}
+
+	weights := map[string]time.Duration{}
+	for _, moduleName := range modules {
+		if duration, ok := durations[moduleName]; ok && duration > 0 {
+			weights[moduleName] = duration
+		} else {
+			weights[moduleName] = time.Duration(testFileCount(graph.Modules[moduleName])) * perFile
+		}
+	}
+
+	ordered := append([]string{}, modules...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		if weights[ordered[i]] != weights[ordered[j]] {
+			return weights[ordered[i]] > weights[ordered[j]]
+		}
+		return ordered[i] < ordered[j]
+	})
+
+	loads := make([]time.Duration, shard.Total)
+	selected := []string{}
+	for _, moduleName := range ordered {
+		lightest := 0
+		for i, load := range loads {
+			if load < loads[lightest] {
+				lightest = i
+			}
+		}
+		loads[lightest] += weights[moduleName]
+		if lightest == shard.Index-1 {
+			selected = append(selected, moduleName)
+		}
+	}
+
+	logger.Info("Selected test shard", "shard", shard.String(), "modules", len(selected),
+		"estimated_time", loads[shard.Index-1].Round(time.Millisecond))
+
+	sort.Strings(selected)
+	return selected
+}
+
+// testFileCount returns the number of test files in a module's directory and
+// its subdirectories, at least one. Directories skipped by the analysis, such
+// as node_modules, and hidden directories are not counted.
+func testFileCount(module analyzer.Module) int {
+	count := 0
+	err := filepath.WalkDir(module.Path, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if path != module.Path && (analyzer.IsIgnoredPath(entry.Name()) || strings.HasPrefix(entry.Name(), ".")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if analyzer.IsTestFile(path) {
+			count++
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Debug("Failed to count test files", "module", module.Name, "error", err)
+	}
+	if count == 0 {
+		count = 1
+	}
+	return count
+}
@@ -1,12 +1,21 @@
 package test
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
-	"os/exec"
+	"io"
+	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 	"mono-mind/internal/analyzer"
+	"mono-mind/internal/impact"
 	"mono-mind/internal/logger"
+	"mono-mind/internal/tasks"
 )
 
 // TestConfig holds configuration for the test process
@@ -14,6 +23,21 @@ type TestConfig struct {
 	Parallel      bool `json:"parallel"`
 	MaxConcurrent int  `json:"max_concurrent"`
 	DryRun        bool `json:"dry_run"`
+	Timeout       time.Duration `json:"timeout"`
+	Modules       []string `json:"modules"`
+	Retries       int      `json:"retries"`
+	Quarantine    []string `json:"quarantine"`
+	Shard         *Shard   `json:"shard,omitempty"`
+	ShardDurations string  `json:"shard_durations,omitempty"`
+	FailFast      bool     `json:"fail_fast"`
+	Coverage      bool     `json:"coverage"`
+	CoverageDir   string   `json:"coverage_dir"`
+	MinCoverage   float64  `json:"min_coverage"`
+	Tests         map[string][]string `json:"tests,omitempty"`
+	RecordImpact  bool     `json:"record_impact"`
+	Tasks         *tasks.Resolver `json:"-"`
+	Context       context.Context `json:"-"`
+	Output        io.Writer       `json:"-"`
 }
 
 // TestResult holds the result of a test operation
@@ -21,6 +45,13 @@ type TestResult struct {
 	TestsRun     int      `json:"tests_run"`
 	TestsPassed  int      `json:"tests_passed"`
 	TestsFailed  int      `json:"tests_failed"`
+	TestsSkipped int      `json:"tests_skipped"`
+	TestsFlaky   int      `json:"tests_flaky"`
+	TestsQuarantined int  `json:"tests_quarantined"`
+	Cases        []TestCase `json:"cases"`
+	ModulesCancelled []string `json:"modules_cancelled"`
+	Coverage     []ModuleCoverage `json:"coverage,omitempty"`
+	CoverageFiles []string `json:"coverage_files,omitempty"`
 	Errors       []string `json:"errors"`
 	Duration     string   `json:"duration"`
 }
@@ -29,101 +60,703 @@ type TestResult struct {
 func RunTests(graph *analyzer.RepoGraph, config TestConfig) *TestResult {
 	logger.Info("Running tests")
 	
+	if config.Tasks == nil {
+		config.Tasks = tasks.NewResolver(nil)
+	}
+	if config.Context == nil {
+		config.Context = context.Background()
+	}
+	
+	// Bound the whole test run by the global timeout, if any
+	ctx := config.Context
+	if config.Timeout > 0 {
+		var timeoutCancel context.CancelFunc
+		ctx, timeoutCancel = context.WithTimeout(ctx, config.Timeout)
+		defer timeoutCancel()
+	}
+	
+	// Fail-fast and interrupts cancel the modules still running or waiting
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	
+	startedAt := time.Now()
 	result := &TestResult{
 		TestsRun:    0,
 		TestsPassed: 0,
 		TestsFailed: 0,
+		Cases:       []TestCase{},
+		ModulesCancelled: []string{},
 		Errors:      []string{},
 	}
 	
-	// In a real implementation, we would:
-	// 1. Determine which tests to run based on changes
-	// 2. Execute tests in the correct order based on dependencies
-	// 3. Handle parallel execution if enabled
-	// 4. Collect results and errors
+	// Run tests for the selected modules, or all modules if none were selected,
+	// restricted to this machine's shard
+	modules := selectModules(graph, config.Modules)
+	if config.Shard != nil {
+		// The local history differs between machines, so only a shared file weights the shards
+		durations := map[string]time.Duration{}
+		if config.ShardDurations != "" {
+			var err error
+			if durations, err = LoadShardDurations(config.ShardDurations); err != nil {
+				logger.Error("Failed to load shard durations", "file", config.ShardDurations, "error", err)
+				result.Errors = append(result.Errors, "shard: "+err.Error())
+				return result
+			}
+		}
+		modules = ShardModules(graph, modules, durations, *config.Shard)
+	}
+	
+	concurrency := 1
+	if config.Parallel && config.MaxConcurrent > 1 {
+		concurrency = config.MaxConcurrent
+	}
+	
+	// Workers take the modules in order; modules not started when the run is
+	// cancelled are reported as cancelled
+	var (
+		wg       sync.WaitGroup
+		next     = make(chan int)
+		outcomes = make([]moduleOutcome, len(modules))
+		printer  = newModulePrinter(config.Output, len(modules))
+	)
+	for worker := 0; worker < concurrency; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				moduleStarted := time.Now()
+				outcomes[i] = testModule(ctx, modules[i], graph.Modules[modules[i]], config)
+				outcomes[i].duration = time.Since(moduleStarted)
+				printer.done(i, outcomes[i])
+				
+				if config.FailFast && outcomes[i].failed() {
+					logger.Warn("Stopping tests after failure (fail-fast)", "module", modules[i])
+					cancel()
+				}
+			}
+		}()
+	}
+	
+	for i, moduleName := range modules {
+		if ctx.Err() == nil {
+			select {
+			case next <- i:
+				continue
+			case <-ctx.Done():
+			}
+		}
+		outcomes[i] = moduleOutcome{module: moduleName, cancelled: true}
+		printer.done(i, outcomes[i])
+	}
+	close(next)
+	wg.Wait()
+	
+	for _, outcome := range outcomes {
+		switch {
+		case outcome.cancelled:
+			result.ModulesCancelled = append(result.ModulesCancelled, outcome.module)
+		case outcome.err != nil && outcome.quarantinedOnly:
+			logger.Warn("Only quarantined tests failed", "module", outcome.module, "error", outcome.err)
+		case outcome.err != nil:
+			logger.Error("Failed to run tests for module", "module", outcome.module, "error", outcome.err)
+			result.Errors = append(result.Errors, outcome.module+": "+outcome.err.Error())
+		}
+		if !outcome.cancelled {
+			result.Cases = append(result.Cases, outcome.cases...)
+		}
+	}
+	if len(result.ModulesCancelled) > 0 {
+		logger.Warn("Test run cancelled", "modules_cancelled", len(result.ModulesCancelled))
+	}
+	
+	if config.Coverage {
+		collectCoverage(result, outcomes, config)
+	}
+	if config.RecordImpact {
+		if err := saveTestImpact(outcomes); err != nil {
+			logger.Warn("Failed to record test impact", "error", err)
+		}
+	}
 	
-	// For demonstration, we'll run tests for all modules
-	for moduleName := range graph.Modules {
-		if config.DryRun {
-			logger.Info("Would run tests for module (dry-run)", "module", moduleName)
-			result.TestsRun++
+	// Count the individual test outcomes
+	sortCases(result.Cases)
+	for _, testCase := range result.Cases {
+		switch {
+		case testCase.Status == StatusFail && testCase.Quarantined:
+			result.TestsQuarantined++
+		case testCase.Status == StatusFail:
+			result.TestsFailed++
+		case testCase.Status == StatusSkip:
+			result.TestsSkipped++
+		default:
 			result.TestsPassed++
-		} else {
-			logger.Info("Running tests for module", "module", moduleName)
-			
-			// Execute test command for the module
-			// In a real implementation, this would be language-specific
-			passed, err := runTestsForModule(moduleName, graph.Modules[moduleName])
-			if err != nil {
-				logger.Error("Failed to run tests for module", "module", moduleName, "error", err)
-				result.Errors = append(result.Errors, moduleName+": "+err.Error())
-				result.TestsFailed++
-			} else if passed {
-				result.TestsPassed++
-			} else {
-				result.TestsFailed++
+			if testCase.Flaky {
+				result.TestsFlaky++
 			}
-			result.TestsRun++
+		}
+	}
+	result.TestsRun = result.TestsPassed + result.TestsFailed + result.TestsQuarantined
+	result.Duration = time.Since(startedAt).String()
+	
+	// Keep the outcomes for flakiness reports
+	if !config.DryRun {
+		if err := recordHistory(startedAt, result.Cases); err != nil {
+			logger.Warn("Failed to record test history", "error", err)
 		}
 	}
 	
 	logger.Info("Test execution completed", 
 		"tests_run", result.TestsRun,
 		"tests_passed", result.TestsPassed,
-		"tests_failed", result.TestsFailed)
+		"tests_failed", result.TestsFailed,
+		"tests_skipped", result.TestsSkipped,
+		"tests_flaky", result.TestsFlaky,
+		"tests_quarantined", result.TestsQuarantined)
 	
 	return result
 }
 
-// runTestsForModule executes the test command for a specific module
-func runTestsForModule(moduleName string, module analyzer.Module) (bool, error) {
-	// This is a simplified implementation
-	// In a real system, we would determine the appropriate test command
-	// based on the language and project configuration
+// moduleOutcome holds the outcome of testing a single module
+type moduleOutcome struct {
+	module          string
+	cases           []TestCase
+	err             error
+	quarantinedOnly bool
+	cancelled       bool
+	duration        time.Duration
+	coverage        *Coverage
+	minCoverage     float64
+	impact          *moduleImpact
+}
+
+// failed reports whether the module has failures that fail the run
+func (o moduleOutcome) failed() bool {
+	return o.err != nil && !o.quarantinedOnly && !o.cancelled
+}
+
+// testModule runs a module's tests with retries and applies the quarantine list.
+// A module whose run was cancelled, rather than failed, is marked cancelled.
+func testModule(ctx context.Context, moduleName string, module analyzer.Module, config TestConfig) moduleOutcome {
+	outcome := moduleOutcome{module: moduleName}
+	
+	if config.DryRun {
+		logger.Info("Would run tests for module (dry-run)", "module", moduleName)
+		outcome.cases = []TestCase{{Module: moduleName, Package: moduleName, Name: "test", Status: StatusPass}}
+		return outcome
+	}
+	
+	logger.Info("Running tests for module", "module", moduleName)
+	tests := config.Tests[moduleName]
+	run, err := runTestsForModule(ctx, moduleName, module, config.Tasks, config.Coverage, tests)
+	cases := run.cases
+	
+	// Rerun the failed tests while any fail; tests that pass on a retry are flaky
+	for attempt := 1; attempt <= config.Retries && hasFailures(cases) && ctx.Err() == nil; attempt++ {
+		failed := failedTests(cases)
+		logger.Warn("Retrying failed tests", "module", moduleName, "attempt", attempt+1, "tests", strings.Join(failed, ","))
+		retried, retryErr := runTestsForModule(ctx, moduleName, module, config.Tasks, config.Coverage, failed)
+		if errors.Is(retryErr, tasks.ErrNoSelect) || failed == nil {
+			// The task cannot run single tests, or a failure was not of a single test
+			retried, retryErr = runTestsForModule(ctx, moduleName, module, config.Tasks, config.Coverage, tests)
+		}
+		cases = mergeRetry(cases, retried.cases)
+		if retried.coverage != nil {
+			run.coverage = retried.coverage
+		}
+		err = retryErr
+	}
+	
+	// A run whose tests all passed succeeded; an error without any test cases,
+	// such as an invalid manifest, is kept and fails the module
+	if err != nil && len(cases) > 0 && !hasFailures(cases) {
+		err = nil
+	}
+	if err != nil && errors.Is(ctx.Err(), context.Canceled) {
+		outcome.cancelled = true
+	}
+	
+	// Failures of quarantined tests are reported but do not fail the run
+	outcome.quarantinedOnly = hasFailures(cases)
+	for i := range cases {
+		if cases[i].Status != StatusFail {
+			continue
+		}
+		cases[i].Quarantined = Quarantined(cases[i], config.Quarantine)
+		outcome.quarantinedOnly = outcome.quarantinedOnly && cases[i].Quarantined
+	}
+	
+	sortCases(cases)
+	outcome.cases = cases
+	outcome.err = err
+	outcome.coverage = run.coverage
+	outcome.minCoverage = config.MinCoverage
+	if run.minCoverage != nil {
+		outcome.minCoverage = *run.minCoverage
+	}
+	
+	if config.RecordImpact && !outcome.cancelled && ctx.Err() == nil {
+		outcome.impact = recordModuleImpact(ctx, moduleName, module, config.Tasks, cases)
+	}
+	return outcome
+}
+
+// moduleImpact holds the files instrumented by a module's coverage runs and
+// the files each of its tests covered
+type moduleImpact struct {
+	files []string
+	tests map[string][]string
+}
+
+// recordModuleImpact reruns each test of a module on its own with coverage to
+// find the source files it covers. Subtests are covered by their parent test.
+// It returns nil when the module's test task cannot select or cover tests.
+func recordModuleImpact(ctx context.Context, moduleName string, module analyzer.Module, resolver *tasks.Resolver, cases []TestCase) *moduleImpact {
+	names := []string{}
+	seen := map[string]bool{}
+	for _, testCase := range cases {
+		if testCase.Status == StatusSkip || strings.Contains(testCase.Name, "/") || seen[testCase.Name] {
+			continue
+		}
+		// The case of the run itself names no real test
+		if isRunCase(testCase) {
+			continue
+		}
+		seen[testCase.Name] = true
+		names = append(names, testCase.Name)
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	
+	logger.Info("Recording test impact", "module", moduleName, "tests", len(names))
+	recorded := &moduleImpact{files: []string{}, tests: map[string][]string{}}
+	instrumented := map[string]bool{}
+	for _, name := range names {
+		run, err := runTestsForModule(ctx, moduleName, module, resolver, true, []string{name})
+		if ctx.Err() != nil {
+			return nil
+		}
+		if run.coverage == nil {
+			logger.Warn("Failed to record test impact", "module", moduleName, "test", name, "error", err)
+			return nil
+		}
+		
+		covered := []string{}
+		for _, path := range run.coverage.paths() {
+			if !instrumented[path] {
+				instrumented[path] = true
+				recorded.files = append(recorded.files, path)
+			}
+			for _, hits := range run.coverage.Files[path] {
+				if hits > 0 {
+					covered = append(covered, path)
+					break
+				}
+			}
+		}
+		recorded.tests[name] = covered
+	}
+	return recorded
+}
+
+// saveTestImpact merges the recorded test impact of the tested modules into the test impact map
+func saveTestImpact(outcomes []moduleOutcome) error {
+	impactMap, err := impact.LoadTestImpactMap(impact.TestImpactFile)
+	if err != nil {
+		return err
+	}
+	
+	recorded := 0
+	for _, outcome := range outcomes {
+		if outcome.impact == nil {
+			continue
+		}
+		impactMap.Record(outcome.module, outcome.impact.files, outcome.impact.tests)
+		recorded++
+	}
+	if recorded == 0 {
+		return nil
+	}
+	
+	logger.Info("Recorded test impact", "modules", recorded, "file", impact.TestImpactFile)
+	return impactMap.Save(impact.TestImpactFile)
+}
+
+// collectCoverage merges the coverage of the tested modules, writes the merged
+// reports and fails modules whose coverage is below their minimum
+func collectCoverage(result *TestResult, outcomes []moduleOutcome, config TestConfig) {
+	total := NewCoverage()
+	result.Coverage = []ModuleCoverage{}
+	for _, outcome := range outcomes {
+		if outcome.coverage == nil || outcome.cancelled {
+			continue
+		}
+		total.Merge(outcome.coverage)
+		
+		found, hit := outcome.coverage.Lines()
+		module := ModuleCoverage{
+			Module:      outcome.module,
+			LinesFound:  found,
+			LinesHit:    hit,
+			Percent:     percent(found, hit),
+			MinCoverage: outcome.minCoverage,
+		}
+		if module.MinCoverage > 0 && module.Percent < module.MinCoverage {
+			module.BelowMinimum = true
+			logger.Error("Coverage below minimum", "module", module.Module,
+				"coverage", fmt.Sprintf("%.1f%%", module.Percent), "minimum", fmt.Sprintf("%.1f%%", module.MinCoverage))
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: coverage %.1f%% is below the minimum of %.1f%%",
+				module.Module, module.Percent, module.MinCoverage))
+		}
+		result.Coverage = append(result.Coverage, module)
+	}
+	
+	dir := config.CoverageDir
+	if dir == "" {
+		dir = filepath.Join(tasks.StateDir, "coverage")
+	}
+	files, err := WriteCoverageFiles(dir, total)
+	if err != nil {
+		logger.Error("Failed to write coverage reports", "error", err)
+		result.Errors = append(result.Errors, "coverage: "+err.Error())
+	}
+	result.CoverageFiles = files
+	
+	if config.Output != nil {
+		WriteCoverageSummary(config.Output, result.Coverage, total)
+	}
+	logger.Info("Coverage collected", "coverage", fmt.Sprintf("%.1f%%", total.Percent()), "files", len(files))
+}
+
+// modulePrinter writes the summary of each tested module as a single block,
+// in module order, as soon as the module and all modules before it are done
+type modulePrinter struct {
+	mu       sync.Mutex
+	w        io.Writer
+	outcomes []*moduleOutcome
+	next     int
+}
+
+// newModulePrinter creates a printer for the given number of modules; a nil writer prints nothing
+func newModulePrinter(w io.Writer, count int) *modulePrinter {
+	return &modulePrinter{w: w, outcomes: make([]*moduleOutcome, count)}
+}
+
+// done records the outcome of the i-th module and prints every block that is ready
+func (p *modulePrinter) done(i int, outcome moduleOutcome) {
+	if p.w == nil {
+		return
+	}
+	
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	
+	p.outcomes[i] = &outcome
+	for p.next < len(p.outcomes) && p.outcomes[p.next] != nil {
+		writeModuleBlock(p.w, *p.outcomes[p.next])
+		p.next++
+	}
+}
+
+// writeModuleBlock prints a module's test counts followed by its failed and flaky tests
+func writeModuleBlock(w io.Writer, outcome moduleOutcome) {
+	if outcome.cancelled {
+		fmt.Fprintf(w, "=== %s: cancelled\n", outcome.module)
+		return
+	}
+	
+	counts := map[string]int{}
+	for _, testCase := range outcome.cases {
+		counts[testCase.Status]++
+	}
+	coverage := ""
+	if outcome.coverage != nil {
+		coverage = fmt.Sprintf(", coverage %.1f%%", outcome.coverage.Percent())
+	}
+	fmt.Fprintf(w, "=== %s: %d passed, %d failed, %d skipped (%s%s)\n", outcome.module,
+		counts[StatusPass], counts[StatusFail], counts[StatusSkip], outcome.duration.Round(time.Millisecond), coverage)
+	if outcome.err != nil && !hasFailures(outcome.cases) {
+		fmt.Fprintf(w, "--- ERROR: %v\n", outcome.err)
+	}
+	
+	for _, testCase := range outcome.cases {
+		switch {
+		case testCase.Status == StatusFail:
+			label := "FAIL"
+			if testCase.Quarantined {
+				label = "FAIL (quarantined)"
+			}
+			fmt.Fprintf(w, "--- %s: %s %s\n", label, testCase.Package, testCase.Name)
+			for _, line := range strings.Split(strings.TrimRight(testCase.Output, "\n"), "\n") {
+				if line != "" {
+					fmt.Fprintf(w, "    %s\n", line)
+				}
+			}
+		case testCase.Flaky:
+			fmt.Fprintf(w, "--- FLAKY: %s %s (passed on attempt %d)\n", testCase.Package, testCase.Name, testCase.Attempts)
+		}
+	}
+}
+
+// hasFailures reports whether any of the test cases failed
+func hasFailures(cases []TestCase) bool {
+	for _, testCase := range cases {
+		if testCase.Status == StatusFail {
+			return true
+		}
+	}
+	return false
+}
+
+// failedTests returns the top-level tests to rerun after a run with failures,
+// or nil when the module must be rerun as a whole: after a failure of the run
+// itself, such as a build error
+func failedTests(cases []TestCase) []string {
+	names := []string{}
+	seen := map[string]bool{}
+	for _, testCase := range cases {
+		if testCase.Status != StatusFail {
+			continue
+		}
+		if isRunCase(testCase) {
+			return nil
+		}
+		// Subtests are rerun by their top-level test
+		name, _, _ := strings.Cut(testCase.Name, "/")
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// isRunCase reports whether a test case is the case moduleCases reports for
+// the run itself: for a run without a report, or one that failed without a
+// failing test
+func isRunCase(testCase TestCase) bool {
+	return testCase.Package == testCase.Module && testCase.Name == "test"
+}
+
+// mergeRetry updates the failed cases of a module with the outcome of a retry.
+// A failed test that passes on the retry is marked flaky; the output of its
+// failure is kept. Tests that passed before keep their first outcome. A failed
+// run without a failing test, such as a build error, is replaced by the tests
+// of a retry that got past it.
+func mergeRetry(cases, retried []TestCase) []TestCase {
+	retriedByID := map[string]TestCase{}
+	for _, testCase := range retried {
+		retriedByID[testCase.ID()] = testCase
+	}
 	
+	merged := []TestCase{}
+	replacedAttempts := 0
+	for _, testCase := range cases {
+		retry, ok := retriedByID[testCase.ID()]
+		switch {
+		case testCase.Status != StatusFail:
+		case isRunCase(testCase) && !ok && len(retried) > 0:
+			replacedAttempts = testCase.Attempts + 1
+			continue
+		case ok:
+			testCase.Attempts++
+			testCase.Duration = retry.Duration
+			if retry.Status == StatusFail {
+				testCase.Output = retry.Output
+			} else {
+				testCase.Status = retry.Status
+				testCase.Flaky = retry.Status == StatusPass
+			}
+		}
+		merged = append(merged, testCase)
+	}
+	
+	if replacedAttempts > 0 {
+		known := map[string]bool{}
+		for _, testCase := range merged {
+			known[testCase.ID()] = true
+		}
+		for _, retry := range retried {
+			if !known[retry.ID()] {
+				retry.Attempts = replacedAttempts
+				merged = append(merged, retry)
+			}
+		}
+	}
+	return merged
+}
+
+// recordHistory adds the outcomes of a run to the test history
+func recordHistory(startedAt time.Time, cases []TestCase) error {
+	history, err := LoadHistory(HistoryFile)
+	if err != nil {
+		return err
+	}
+	history.Record(startedAt, cases)
+	return history.Save(HistoryFile)
+}
+
+// selectModules returns the sorted names of the modules to test, skipping
+// selected modules that are not in the graph
+func selectModules(graph *analyzer.RepoGraph, selected []string) []string {
+	modules := []string{}
+	if selected == nil {
+		for moduleName := range graph.Modules {
+			modules = append(modules, moduleName)
+		}
+	} else {
+		for _, moduleName := range selected {
+			if _, exists := graph.Modules[moduleName]; exists {
+				modules = append(modules, moduleName)
+			} else {
+				logger.Warn("Selected module not found", "module", moduleName)
+			}
+		}
+	}
+	
+	sort.Strings(modules)
+	return modules
+}
+
+// runTestsForModule executes the test task declared for a specific module and
+// returns the outcome of each test. Without a report format the module's whole
+// test run is reported as a single case. When tests are given, only those are run.
+func runTestsForModule(ctx context.Context, moduleName string, module analyzer.Module, resolver *tasks.Resolver, withCoverage bool, tests []string) (moduleRun, error) {
 	// Validate the module path to prevent directory traversal and command injection attacks
 	// Clean the path to remove any .. or . components
 	cleanPath := filepath.Clean(module.Path)
 
 	// Ensure the path is relative and doesn't start with ..
 	if filepath.IsAbs(cleanPath) || strings.HasPrefix(cleanPath, "..") {
-		return false, fmt.Errorf("invalid module path: %s", module.Path)
+		return moduleRun{}, fmt.Errorf("invalid module path: %s", module.Path)
 	}
 
 	// Additional validation to prevent command injection
 	if strings.Contains(cleanPath, ";") || strings.Contains(cleanPath, "|") ||
 	   strings.Contains(cleanPath, "&") || strings.Contains(cleanPath, "`") {
-		return false, fmt.Errorf("module path contains potentially dangerous characters: %s", module.Path)
-	}
-	
-	var cmd *exec.Cmd
-	
-	switch module.Language {
-	case "go":
-		// For Go modules, we might run 'go test'
-		cmd = exec.Command("go", "test", "./"+cleanPath+"/...") // #nosec G204 -- Path validated above
-	case "javascript", "typescript":
-		// For JS/TS projects, we might run 'npm test' or 'yarn test'
-		// Check if package.json exists in the module directory
-		cmd = exec.Command("npm", "test")
-		cmd.Dir = cleanPath
-	case "python":
-		// For Python projects, we might run 'python -m pytest'
-		cmd = exec.Command("python", "-m", "pytest")
-		cmd.Dir = cleanPath
-	default:
+		return moduleRun{}, fmt.Errorf("module path contains potentially dangerous characters: %s", module.Path)
+	}
+	
+	// Resolve the test task from the language defaults, the root config and the module's mono.yaml
+	module.Path = cleanPath
+	task, err := resolver.Resolve(module, "test")
+	switch {
+	case err == nil:
+		logger.Debug("Resolved test task", "module", moduleName, "command", task.Command, "dir", task.Dir)
+	case errors.Is(err, tasks.ErrNoTask):
 		// Default test command
-		cmd = exec.Command("make", "test")
-		cmd.Dir = module.Path
+		task = &tasks.Task{Name: "test", Module: moduleName, Command: "make test", Dir: cleanPath, Env: map[string]string{}}
+	default:
+		return moduleRun{}, err
+	}
+	if len(tests) > 0 {
+		if task, err = task.Select(tests); err != nil {
+			return moduleRun{}, err
+		}
+		logger.Debug("Selected tests", "module", moduleName, "tests", strings.Join(tests, ","))
 	}
 	
-	// Execute the command
-	_, err := cmd.CombinedOutput()
+	// Collect coverage by running the task's coverage command instead
+	run := moduleRun{minCoverage: task.MinCoverage}
+	coverageFile := ""
+	if withCoverage && task.CoverageCommand == "" {
+		logger.Warn("Module has no coverage command, collecting no coverage", "module", moduleName)
+	} else if withCoverage {
+		coverageTask := *task
+		coverageTask.Command = task.CoverageCommand
+		task = &coverageTask
+		coverageFile = task.CoverageFile
+	}
+	
+	// Remove the report and coverage of a previous run so stale files are never parsed
+	for _, file := range []string{task.ReportFile, coverageFile} {
+		if file == "" {
+			continue
+		}
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return moduleRun{}, fmt.Errorf("failed to remove stale test report: %v", err)
+		}
+		if err := os.MkdirAll(filepath.Dir(file), 0750); err != nil {
+			return moduleRun{}, fmt.Errorf("failed to create test report directory: %v", err)
+		}
+	}
+	
+	// Execute the command; it is killed if it outlives the task or run timeout
+	var output bytes.Buffer
+	startedAt := time.Now()
+	runErr := task.Execute(ctx, &output)
+	elapsed := time.Since(startedAt)
+	if runErr != nil {
+		logger.Error("Tests failed", "module", moduleName, "error", runErr)
+		logger.Debug("Test output", "module", moduleName, "output", output.String())
+	} else {
+		logger.Debug("Tests passed", "module", moduleName)
+	}
+	
+	run.cases = moduleCases(task, output.Bytes(), elapsed, runErr)
+	for i := range run.cases {
+		run.cases[i].Module = moduleName
+		run.cases[i].Attempts = 1
+	}
+	
+	if coverageFile != "" {
+		data, err := os.ReadFile(coverageFile)
+		if err == nil {
+			run.coverage, err = ParseCoverage(task.CoverageFormat, data, task.Dir)
+		}
+		if err != nil {
+			logger.Warn("Failed to read coverage", "module", moduleName, "file", coverageFile, "error", err)
+			run.coverage = nil
+		}
+	}
+	return run, runErr
+}
+
+// moduleRun holds the outcome of a single run of a module's test task
+type moduleRun struct {
+	cases       []TestCase
+	coverage    *Coverage
+	minCoverage *float64
+}
+
+// moduleCases turns the report of a module's test run into test cases. A run
+// that failed without a failing test, such as a timeout or a crash of the test
+// runner, is reported as an extra failed case named after the task.
+func moduleCases(task *tasks.Task, output []byte, elapsed time.Duration, runErr error) []TestCase {
+	runCase := TestCase{Package: task.Module, Name: task.Name, Status: StatusPass, Duration: elapsed}
+	if runErr != nil {
+		runCase.Status = StatusFail
+		runCase.Output = strings.TrimSpace(string(output) + "\n" + runErr.Error())
+	}
+	if task.ReportFormat == "" {
+		return []TestCase{runCase}
+	}
+	
+	data := output
+	if task.ReportFile != "" {
+		var err error
+		data, err = os.ReadFile(task.ReportFile)
+		if err != nil {
+			logger.Warn("Test report not found", "module", task.Module, "file", task.ReportFile, "error", err)
+			return []TestCase{runCase}
+		}
+	}
+	
+	cases, err := ParseReport(task.ReportFormat, data)
 	if err != nil {
-		logger.Error("Tests failed", "module", moduleName, "error", err)
-		return false, err
+		logger.Warn("Failed to parse test report", "module", task.Module, "format", task.ReportFormat, "error", err)
+		return []TestCase{runCase}
 	}
 	
-	logger.Debug("Tests passed", "module", moduleName)
-	return true, nil
-}
\ No newline at end of file
+	if runErr != nil {
+		for _, testCase := range cases {
+			if testCase.Status == StatusFail {
+				return cases
+			}
+		}
+		// The run's time is already spent in the reported tests
+		runCase.Duration = 0
+		cases = append(cases, runCase)
+	}
+	return cases
+}
@@ -0,0 +1,167 @@
+//go:build linux
+
+package watch
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"unsafe"
+	"golang.org/x/sys/unix"
+	"mono-mind/internal/logger"
+)
+
+// inotifyMask selects the events that change the content or the set of files
+const inotifyMask = unix.IN_CLOSE_WRITE | unix.IN_CREATE | unix.IN_DELETE |
+	unix.IN_MOVED_FROM | unix.IN_MOVED_TO | unix.IN_DONT_FOLLOW | unix.IN_EXCL_UNLINK
+
+// inotifyWatcher watches every directory of a tree with one inotify instance
+type inotifyWatcher struct {
+	fd      int
+	root    string
+	dirs    map[int]string
+	ignored func(string) bool
+	events  chan<- string
+}
+
+// watchNative watches the tree under the root with inotify and sends the
+// paths of changed files. It fails if inotify is unavailable or the tree has
+// more directories than the user's inotify watch limit.
+func watchNative(ctx context.Context, root string, ignored func(string) bool, events chan<- string) error {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return err
+	}
+
+	w := &inotifyWatcher{fd: fd, root: root, dirs: map[int]string{}, ignored: ignored, events: events}
+	if err := w.addTree(ctx, root, false); err != nil {
+		unix.Close(fd)
+		return err
+	}
+
+	go func() {
+		defer unix.Close(fd)
+		w.read(ctx)
+	}()
+	return nil
+}
+
+// addTree watches a directory and its subdirectories. When notify is set, the
+// files found are sent as changed, since they may have been written before
+// their directory was watched.
+func (w *inotifyWatcher) addTree(ctx context.Context, dir string, notify bool) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if w.ignored(path) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.IsDir() {
+			if notify {
+				send(ctx, w.events, path)
+			}
+			return nil
+		}
+
+		wd, err := unix.InotifyAddWatch(w.fd, path, inotifyMask|unix.IN_ONLYDIR)
+		if err != nil {
+			if err == unix.ENOSPC {
+				return err
+			}
+			logger.Debug("Failed to watch directory", "dir", path, "error", err)
+			return nil
+		}
+		w.dirs[wd] = path
+		return nil
+	})
+}
+
+// removeTree stops watching a directory moved out of the tree and its subdirectories
+func (w *inotifyWatcher) removeTree(dir string) {
+	for wd, path := range w.dirs {
+		if path == dir || strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			unix.InotifyRmWatch(w.fd, uint32(wd))
+			delete(w.dirs, wd)
+		}
+	}
+}
+
+// read waits for inotify events until the context is done
+func (w *inotifyWatcher) read(ctx context.Context) {
+	buf := make([]byte, 64*1024)
+	for ctx.Err() == nil {
+		// Wake up regularly to notice the end of the watch
+		fds := []unix.PollFd{{Fd: int32(w.fd), Events: unix.POLLIN}}
+		n, err := unix.Poll(fds, 250)
+		if err == unix.EINTR || n == 0 {
+			continue
+		}
+		if err != nil {
+			logger.Error("Failed to wait for file events", "error", err)
+			return
+		}
+
+		n, err = unix.Read(w.fd, buf)
+		if err == unix.EAGAIN || err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			logger.Error("Failed to read file events", "error", err)
+			return
+		}
+
+		for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+			event := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameStart := offset + unix.SizeofInotifyEvent
+			name := strings.TrimRight(string(buf[nameStart:nameStart+int(event.Len)]), "\x00")
+			offset = nameStart + int(event.Len)
+
+			w.handle(ctx, event, name)
+		}
+	}
+}
+
+// handle sends the path of an event, keeping the watched directories in sync
+// with directories created, removed and moved
+func (w *inotifyWatcher) handle(ctx context.Context, event *unix.InotifyEvent, name string) {
+	if event.Mask&unix.IN_Q_OVERFLOW != 0 {
+		logger.Warn("Too many file changes, events were lost")
+		send(ctx, w.events, w.root)
+		return
+	}
+	if event.Mask&unix.IN_IGNORED != 0 {
+		delete(w.dirs, int(event.Wd))
+		return
+	}
+
+	dir, ok := w.dirs[int(event.Wd)]
+	if !ok || name == "" {
+		return
+	}
+	path := filepath.Join(dir, name)
+	if w.ignored(path) {
+		return
+	}
+
+	if event.Mask&unix.IN_ISDIR != 0 {
+		switch {
+		case event.Mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0:
+			if err := w.addTree(ctx, path, true); err != nil {
+				logger.Warn("Failed to watch new directory", "dir", path, "error", err)
+			}
+		case event.Mask&unix.IN_MOVED_FROM != 0:
+			w.removeTree(path)
+			send(ctx, w.events, path)
+		case event.Mask&unix.IN_DELETE != 0:
+			send(ctx, w.events, path)
+		}
+		return
+	}
+
+	send(ctx, w.events, path)
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package watch
+
+import (
+	"context"
+	"fmt"
+)
+
+// watchNative reports that native file watching is not supported on this platform
+func watchNative(ctx context.Context, root string, ignored func(string) bool, events chan<- string) error {
+	return fmt.Errorf("native file watching is only supported on Linux")
+}
@@ -0,0 +1,83 @@
+package watch
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// fileState is what polling compares to detect a changed file
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// watchPoll scans the files under the root at every interval and sends the
+// paths of files that were added, removed or modified since the previous scan
+func watchPoll(ctx context.Context, root string, interval time.Duration, ignored func(string) bool, events chan<- string) error {
+	previous, err := scan(root, ignored)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			current, err := scan(root, ignored)
+			if err != nil {
+				continue
+			}
+			for path, state := range current {
+				if old, exists := previous[path]; !exists || old != state {
+					send(ctx, events, path)
+				}
+			}
+			for path := range previous {
+				if _, exists := current[path]; !exists {
+					send(ctx, events, path)
+				}
+			}
+			previous = current
+		}
+	}()
+	return nil
+}
+
+// scan returns the state of every file under the root that is not ignored
+func scan(root string, ignored func(string) bool) (map[string]fileState, error) {
+	files := map[string]fileState{}
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// Files may disappear while the tree is walked
+			return nil
+		}
+		if ignored(path) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.IsDir() {
+			files[path] = fileState{modTime: info.ModTime(), size: info.Size()}
+		}
+		return nil
+	})
+	return files, err
+}
+
+// send delivers a changed path unless the watch has stopped
+func send(ctx context.Context, events chan<- string, path string) {
+	select {
+	case events <- path:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,116 @@
+package watch
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"mono-mind/internal/analyzer"
+	"mono-mind/internal/logger"
+)
+
+// Options configures how a repository is watched
+type Options struct {
+	Root         string        `json:"root"`
+	Debounce     time.Duration `json:"debounce"`
+	Poll         bool          `json:"poll"`
+	PollInterval time.Duration `json:"poll_interval"`
+	Ignore       []string      `json:"ignore"`
+}
+
+// Watch observes the files under the root and calls onChange with the changed
+// paths once no further change arrived for the debounce duration. Files are
+// watched with inotify on Linux and by polling elsewhere, or when inotify is
+// unavailable. Changes made while onChange runs are passed to the next call.
+// A path equal to the root means changes were lost and everything should be
+// considered changed. Watch blocks until the context is done.
+func Watch(ctx context.Context, opts Options, onChange func(paths []string)) error {
+	if opts.Root == "" {
+		opts.Root = "."
+	}
+	if opts.Debounce <= 0 {
+		opts.Debounce = 300 * time.Millisecond
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = time.Second
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	events := make(chan string, 1024)
+	ignored := ignoreFunc(opts.Root, opts.Ignore)
+
+	started := false
+	if !opts.Poll {
+		if err := watchNative(ctx, opts.Root, ignored, events); err != nil {
+			logger.Warn("Native file watching unavailable, polling for changes", "error", err)
+		} else {
+			logger.Debug("Watching with inotify", "root", opts.Root)
+			started = true
+		}
+	}
+	if !started {
+		if err := watchPoll(ctx, opts.Root, opts.PollInterval, ignored, events); err != nil {
+			return err
+		}
+		logger.Debug("Watching by polling", "root", opts.Root, "interval", opts.PollInterval)
+	}
+
+	// Collect the changed paths until the debounce duration passes without a change
+	pending := map[string]bool{}
+	timer := time.NewTimer(opts.Debounce)
+	timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case path := <-events:
+			pending[path] = true
+			timer.Reset(opts.Debounce)
+		case <-timer.C:
+			if len(pending) == 0 {
+				continue
+			}
+			paths := make([]string, 0, len(pending))
+			for path := range pending {
+				paths = append(paths, path)
+			}
+			sort.Strings(paths)
+			pending = map[string]bool{}
+
+			logger.Debug("Files changed", "files", strings.Join(paths, ","))
+			onChange(paths)
+		}
+	}
+}
+
+// ignoreFunc returns a function reporting whether a path under the root is
+// ignored: it lies in a directory the analyzer skips, or one of its path
+// elements or its path relative to the root matches an ignore pattern
+func ignoreFunc(root string, patterns []string) func(path string) bool {
+	return func(path string) bool {
+		rel, err := filepath.Rel(root, path)
+		if err != nil || rel == "." {
+			return false
+		}
+		if analyzer.IsIgnoredPath(rel) {
+			return true
+		}
+
+		rel = filepath.ToSlash(rel)
+		for _, pattern := range patterns {
+			if matched, _ := filepath.Match(pattern, rel); matched {
+				return true
+			}
+			for _, part := range strings.Split(rel, "/") {
+				if matched, _ := filepath.Match(pattern, part); matched {
+					return true
+				}
+			}
+		}
+		return false
+	}
+}